@@ -27,6 +27,7 @@ import (
 	"github.com/prometheus/log"
 	"github.com/prometheus/prometheus/notification"
 	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/retrieval"
 	"github.com/prometheus/prometheus/storage/local"
 	"github.com/prometheus/prometheus/storage/local/index"
 	"github.com/prometheus/prometheus/storage/remote"
@@ -47,6 +48,8 @@ var cfg = struct {
 	web          web.Options
 	remote       remote.Options
 
+	scrapeIntervalBuckets retrieval.HistogramBuckets
+
 	prometheusURL string
 }{}
 
@@ -58,6 +61,7 @@ func init() {
 
 	// Set additional defaults.
 	cfg.storage.SyncStrategy = local.Adaptive
+	cfg.scrapeIntervalBuckets = retrieval.DefaultScrapeIntervalBuckets
 
 	cfg.fs.BoolVar(
 		&cfg.printVersion, "version", false,
@@ -210,6 +214,12 @@ func init() {
 		&cfg.queryEngine.MaxConcurrentQueries, "query.max-concurrency", 20,
 		"Maximum number of queries executed concurrently.",
 	)
+
+	// Scraping.
+	cfg.fs.Var(
+		&cfg.scrapeIntervalBuckets, "scrape.interval-histogram-buckets",
+		"Comma-separated upper bounds in seconds of the buckets of the histogram of the actual intervals between scrapes.",
+	)
 }
 
 func parse(args []string) error {
@@ -245,7 +255,7 @@ func parse(args []string) error {
 	}
 	cfg.web.ExternalURL.Path = ppref
 
-	return nil
+	return retrieval.SetScrapeIntervalBuckets(cfg.scrapeIntervalBuckets)
 }
 
 var helpTmpl = `
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"mime"
+	"net"
 	"net/url"
 	"path/filepath"
 	"regexp"
@@ -14,6 +16,7 @@ import (
 
 	clientmodel "github.com/prometheus/client_golang/model"
 
+	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/util/strutil"
 )
 
@@ -26,6 +29,18 @@ var (
 
 // Load parses the YAML input s into a Config.
 func Load(s string) (*Config, error) {
+	cfg, err := load(s)
+	if err != nil {
+		return nil, err
+	}
+	// Relative paths are used relative to the working directory.
+	if err := checkCACerts(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func load(s string) (*Config, error) {
 	cfg := &Config{}
 	// If the entire config body is empty the UnmarshalYAML method is
 	// never called. We thus have to set the DefaultConfig at the entry
@@ -46,11 +61,14 @@ func LoadFile(filename string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	cfg, err := Load(string(content))
+	cfg, err := load(string(content))
 	if err != nil {
 		return nil, err
 	}
 	resolveFilepaths(filepath.Dir(filename), cfg)
+	if err := checkCACerts(cfg); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
@@ -77,6 +95,17 @@ var (
 		HonorLabels: false,
 	}
 
+	// The default response header holding the snapshot time of a target.
+	DefaultTimestampHeader = "X-Prometheus-Scrape-Timestamp"
+
+	// The default TTL of bearer tokens fetched from a bearer token URL.
+	DefaultBearerTokenTTL = Duration(5 * time.Minute)
+
+	// The default value transform configuration.
+	DefaultValueTransformConfig = ValueTransformConfig{
+		Scale: 1,
+	}
+
 	// The default Relabel configuration.
 	DefaultRelabelConfig = RelabelConfig{
 		Action:    RelabelReplace,
@@ -168,12 +197,50 @@ func resolveFilepaths(baseDir string, cfg *Config) {
 
 	for _, scfg := range cfg.ScrapeConfigs {
 		scfg.BearerTokenFile = join(scfg.BearerTokenFile)
+		scfg.CACert = join(scfg.CACert)
+		for i, ca := range scfg.CACerts {
+			scfg.CACerts[i] = join(ca)
+		}
 
 		if scfg.ClientCert != nil {
 			scfg.ClientCert.Cert = join(scfg.ClientCert.Cert)
 			scfg.ClientCert.Key = join(scfg.ClientCert.Key)
 		}
+		if scfg.ClientCertP12 != nil {
+			scfg.ClientCertP12.File = join(scfg.ClientCertP12.File)
+			scfg.ClientCertP12.PasswordFile = join(scfg.ClientCertP12.PasswordFile)
+		}
+		if scfg.NegotiateAuth != nil {
+			scfg.NegotiateAuth.KeytabFile = join(scfg.NegotiateAuth.KeytabFile)
+			scfg.NegotiateAuth.Krb5ConfigFile = join(scfg.NegotiateAuth.Krb5ConfigFile)
+		}
+	}
+}
+
+// checkCACerts ensures that all CA cert files referenced in the scrape
+// configurations are readable.
+func checkCACerts(cfg *Config) error {
+	for _, scfg := range cfg.ScrapeConfigs {
+		if err := scfg.checkCACerts(true); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// checkCACerts ensures that the CA cert files of the scrape config are
+// readable. Relative paths are skipped unless relative is set as they may
+// still have to be resolved against the directory of the config file.
+func (c *ScrapeConfig) checkCACerts(relative bool) error {
+	for _, ca := range append([]string{c.CACert}, c.CACerts...) {
+		if ca == "" || (!relative && !filepath.IsAbs(ca)) {
+			continue
+		}
+		if _, err := ioutil.ReadFile(ca); err != nil {
+			return fmt.Errorf("unable to read CA cert %s for scrape config %q: %s", ca, c.JobName, err)
+		}
+	}
+	return nil
 }
 
 func checkOverflow(m map[string]interface{}, ctx string) error {
@@ -231,6 +298,18 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		if scfg.ScrapeTimeout == 0 {
 			scfg.ScrapeTimeout = c.GlobalConfig.ScrapeTimeout
 		}
+		if scfg.ScrapeHeaderTimeout == 0 {
+			scfg.ScrapeHeaderTimeout = scfg.ScrapeTimeout
+		}
+		if scfg.ScrapeHeaderTimeout > scfg.ScrapeTimeout {
+			return fmt.Errorf("scrape header timeout %s of scrape config %q exceeds its scrape timeout %s", time.Duration(scfg.ScrapeHeaderTimeout), scfg.JobName, time.Duration(scfg.ScrapeTimeout))
+		}
+		if scfg.InitialScrapeDelay > scfg.ScrapeInterval {
+			return fmt.Errorf("initial scrape delay %s of scrape config %q exceeds its scrape interval %s", time.Duration(scfg.InitialScrapeDelay), scfg.JobName, time.Duration(scfg.ScrapeInterval))
+		}
+		if min := c.GlobalConfig.MinScrapeInterval; min > 0 && !scfg.AllowFastScrapes && scfg.ScrapeInterval < min {
+			return fmt.Errorf("scrape interval %s of scrape config %q is below the minimum scrape interval %s", time.Duration(scfg.ScrapeInterval), scfg.JobName, time.Duration(c.GlobalConfig.MinScrapeInterval))
+		}
 
 		if _, ok := jobNames[scfg.JobName]; ok {
 			return fmt.Errorf("found multiple scrape configs with job name %q", scfg.JobName)
@@ -249,6 +328,10 @@ type GlobalConfig struct {
 	ScrapeTimeout Duration `yaml:"scrape_timeout,omitempty"`
 	// How frequently to evaluate rules by default.
 	EvaluationInterval Duration `yaml:"evaluation_interval,omitempty"`
+	// The lowest scrape interval allowed for scrape configs that do not
+	// explicitly allow fast scrapes, e.g. 1s to reject typos like 1ms. There
+	// is no minimum if zero, which is the default.
+	MinScrapeInterval Duration `yaml:"min_scrape_interval,omitempty"`
 	// The labels to add to any timeseries that this Prometheus instance scrapes.
 	Labels clientmodel.LabelSet `yaml:"labels,omitempty"`
 
@@ -271,37 +354,233 @@ func (c *GlobalConfig) isZero() bool {
 	return c.Labels == nil &&
 		c.ScrapeInterval == 0 &&
 		c.ScrapeTimeout == 0 &&
-		c.EvaluationInterval == 0
+		c.EvaluationInterval == 0 &&
+		c.MinScrapeInterval == 0
 }
 
 // ScrapeConfig configures a scraping unit for Prometheus.
 type ScrapeConfig struct {
 	// The job name to which the job label is set by default.
 	JobName string `yaml:"job_name"`
+	// The tenant the scraped samples belong to. It is passed to the storage
+	// along with the samples rather than attached as a label.
+	TenantID string `yaml:"tenant_id,omitempty"`
+	// Annotations attached to the targets of this config. Unlike labels,
+	// they are never attached to scraped samples.
+	Annotations map[string]string `yaml:"annotations,omitempty"`
 	// Indicator whether the scraped metrics should remain unmodified.
 	HonorLabels bool `yaml:"honor_labels,omitempty"`
+	// Whether the job label of the scrape config is kept even if
+	// honor_labels is set. A scraped job label is moved to the exported_job
+	// label instead.
+	HonorLabelsKeepJob bool `yaml:"honor_labels_keep_job,omitempty"`
+	// The scraped labels that are honored like with honor_labels while all
+	// other labels are handled as if honor_labels was not set.
+	HonorLabelNames clientmodel.LabelNames `yaml:"honor_label_names,omitempty"`
+	// Labels added to scraped samples that do not have them after the
+	// target labels were attached, regardless of honor_labels.
+	DefaultLabels clientmodel.LabelSet `yaml:"default_labels,omitempty"`
+	// What happens to scraped labels colliding with target labels if honor
+	// labels is not set. If empty, the scraped labels are prefixed.
+	LabelCollisionPolicy LabelCollisionPolicy `yaml:"label_collision_policy,omitempty"`
+	// Whether characters not allowed in label names are replaced by
+	// underscores in scraped label names. Labels whose normalized name is
+	// already taken are dropped.
+	NormalizeLabelNames bool `yaml:"normalize_label_names,omitempty"`
+	// Whether the instance label is left to the scraped metrics rather than
+	// set to the target's address if no other value is configured. The
+	// synthetic metrics recorded for each scrape keep the instance label.
+	OmitInstanceLabel bool `yaml:"omit_instance_label,omitempty"`
+	// Whether the unit and counter suffixes of the OpenMetrics conventions
+	// are appended to the names of the synthetic metrics lacking them, i.e.
+	// scrape_tls_cert_not_after becomes scrape_tls_cert_not_after_seconds,
+	// and scrape_series_capped and scrape_samples_throttled get a _total
+	// suffix. The names of all other synthetic metrics are unchanged and no
+	// TYPE or UNIT metadata is recorded for them.
+	SyntheticNameSuffixes bool `yaml:"synthetic_name_suffixes,omitempty"`
+	// Whether the durations of the phases of each scrape request, i.e. DNS
+	// lookup, connection setup, TLS handshake and time to first byte, are
+	// recorded as synthetic metrics.
+	ScrapeTimingMetrics bool `yaml:"scrape_timing_metrics,omitempty"`
+	// Whether the exposition format served by each target is recorded as a
+	// synthetic info metric.
+	ScrapeFormatInfo bool `yaml:"scrape_format_info,omitempty"`
+	// Whether the number of distinct label names of the samples scraped from
+	// each target is recorded as a synthetic metric.
+	LabelCardinalityMetric bool `yaml:"label_cardinality_metric,omitempty"`
+	// Whether equal label names and values of scraped samples are interned to
+	// share their memory. This saves memory if many targets expose the same
+	// labels at the cost of a lookup per label.
+	InternLabels bool `yaml:"intern_labels,omitempty"`
+	// Indicator whether scrape intervals below the global minimum scrape
+	// interval are allowed.
+	AllowFastScrapes bool `yaml:"allow_fast_scrapes,omitempty"`
+	// Indicator whether the timestamp reported by targets in the timestamp
+	// header is used for scraped samples without an explicit timestamp.
+	HonorTimestamps bool `yaml:"honor_timestamps,omitempty"`
+	// The response header holding the time at which a target took its snapshot.
+	TimestampHeader string `yaml:"timestamp_header,omitempty"`
+	// How far the timestamps of scraped samples may be ahead of the current
+	// time. Timestamps are not checked if zero.
+	TimestampTolerance Duration `yaml:"timestamp_tolerance,omitempty"`
+	// What happens to samples with timestamps beyond the tolerance. If empty,
+	// they are rejected.
+	TimestampToleranceAction TimestampToleranceAction `yaml:"timestamp_tolerance_action,omitempty"`
+	// What happens to scraped samples with NaN or infinite values. If empty,
+	// they are passed through.
+	NonFiniteValueAction NonFiniteValueAction `yaml:"non_finite_value_action,omitempty"`
+	// What happens to samples of the text format whose metric family has no
+	// TYPE comment. If empty, samples do not require one.
+	RequireMetadata MissingMetadataAction `yaml:"require_metadata,omitempty"`
 	// A set of query parameters with which the target is scraped.
 	Params url.Values `yaml:"params,omitempty"`
+	// Query parameters taking their value from the target's label of the
+	// given name. Parameters of unset labels are omitted. They replace the
+	// first value of static parameters of the same name.
+	ParamLabels map[string]clientmodel.LabelName `yaml:"param_labels,omitempty"`
 	// How frequently to scrape the targets of this scrape config.
 	ScrapeInterval Duration `yaml:"scrape_interval,omitempty"`
-	// The timeout for scraping targets of this config.
+	// The timeout for scraping targets of this config. It covers reading and
+	// parsing the whole response.
 	ScrapeTimeout Duration `yaml:"scrape_timeout,omitempty"`
+	// The timeout for connecting to targets of this config and receiving the
+	// response headers. Defaults to the scrape timeout.
+	ScrapeHeaderTimeout Duration `yaml:"scrape_header_timeout,omitempty"`
+	// Whether the time spent resolving the host names of targets and
+	// connecting to them is not counted against the scrape timeout. Resolving
+	// and connecting are then limited by the scrape header timeout on their
+	// own.
+	DeadlineExcludesConnect bool `yaml:"deadline_excludes_connect,omitempty"`
+	// Whether a scrape answered with a 5xx status is retried once after the
+	// delay requested by the Retry-After header of the response, unless the
+	// retry would exceed the scrape timeout.
+	RetryServerErrors bool `yaml:"retry_server_errors,omitempty"`
+	// Whether successful scrapes without any samples count as failed, as
+	// they usually indicate a broken exporter.
+	FailEmptyScrapes bool `yaml:"fail_empty_scrapes,omitempty"`
+	// The HTTP status codes of successful scrape responses. Responses with
+	// status 204 No Content are successful scrapes without samples. If empty,
+	// only 200 OK is accepted.
+	AcceptStatusCodes []int `yaml:"accept_status_codes,omitempty"`
+	// The upper bound of the random delay before the first scrape of each
+	// target of this config. It must not exceed the scrape interval, which
+	// is the default bound.
+	InitialScrapeDelay Duration `yaml:"initial_scrape_delay,omitempty"`
+	// Whether targets of this config are connected to once when their
+	// scraper starts, before waiting for the first scrape.
+	Warmup bool `yaml:"warmup,omitempty"`
+	// The number of scrape outcomes retained per target for debugging. No
+	// outcomes are retained if zero.
+	ScrapeLogSize int `yaml:"scrape_log_size,omitempty"`
+	// The directory the raw response bodies of each scrape of a target are
+	// written to for post-mortem analysis. Bodies are not written if empty.
+	ScrapeDumpDir string `yaml:"scrape_dump_dir,omitempty"`
+	// The maximum number of bytes of the bodies of a scrape written to its
+	// dump file. Bodies are not truncated if zero.
+	ScrapeDumpSizeLimit int64 `yaml:"scrape_dump_size_limit,omitempty"`
+	// The number of dump files kept per target, including the one of the
+	// latest scrape. Defaults to 2 if zero.
+	ScrapeDumpFiles int `yaml:"scrape_dump_files,omitempty"`
+	// The weight of the latest scrape duration in the exponentially weighted
+	// moving average of the scrape durations of targets of this config. Must
+	// be between 0 and 1. A default weight is used if zero.
+	ScrapeDurationDecay float64 `yaml:"scrape_duration_decay,omitempty"`
+	// The maximum number of concurrent scrapes of targets of this config.
+	// Scrapes are not limited if zero.
+	MaxConcurrentScrapes int `yaml:"max_concurrent_scrapes,omitempty"`
+	// The maximum number of distinct series ingested per target. Once it is
+	// reached, samples of new series are dropped unless they replace a series
+	// that disappeared. Series are not limited if zero.
+	SeriesLimit int `yaml:"series_limit,omitempty"`
+	// The maximum number of samples per second appended per target. Appending
+	// blocks once it is exceeded, and samples that cannot be appended until
+	// the scrape timeout are dropped. Samples are not limited if zero.
+	SampleRateLimit float64 `yaml:"sample_rate_limit,omitempty"`
+	// The number of samples that may be appended at once before the rate
+	// limit applies. Defaults to the rate limit.
+	SampleRateBurst int `yaml:"sample_rate_burst,omitempty"`
+	// The maximum number of bytes read from the response bodies of a scrape.
+	// Scrapes exceeding it fail. Bodies are not limited if zero.
+	BodySizeLimit int64 `yaml:"body_size_limit,omitempty"`
+	// The size in bytes the response bodies of the last successful scrape of
+	// a target must exceed for its responses to be requested gzip-compressed.
+	// Compressed responses are always requested if zero.
+	GzipSizeThreshold int64 `yaml:"gzip_size_threshold,omitempty"`
+	// The size in bytes response bodies of the text format must exceed to be
+	// parsed concurrently in chunks. Bodies are always parsed sequentially if
+	// zero.
+	ParallelParseThreshold int64 `yaml:"parallel_parse_threshold,omitempty"`
+	// The maximum number of chunks a response body is parsed in concurrently.
+	// The number of CPUs usable at once is used if zero.
+	ParallelParseWorkers int `yaml:"parallel_parse_workers,omitempty"`
 	// The HTTP resource path on which to fetch metrics from targets.
 	MetricsPath string `yaml:"metrics_path,omitempty"`
+	// Further HTTP resource paths on which to fetch metrics from targets. Their
+	// metrics are merged with the ones fetched from the metrics path.
+	AdditionalMetricsPaths []string `yaml:"additional_metrics_paths,omitempty"`
+	// The HTTP resource path requested from targets before each scrape. If
+	// the request fails or does not return a 2xx status, the metrics are not
+	// fetched and the scrape fails. Not requested if empty.
+	HealthCheckPath string `yaml:"health_check_path,omitempty"`
 	// The URL scheme with which to fetch metrics from targets.
 	Scheme string `yaml:"scheme,omitempty"`
+	// The HTTP method with which metrics are fetched from targets. Defaults
+	// to GET.
+	HTTPMethod string `yaml:"http_method,omitempty"`
+	// A static body sent along with POST requests to the targets.
+	RequestBody string `yaml:"request_body,omitempty"`
+	// The Content-Type header of the request body. No Content-Type header is
+	// sent if empty.
+	RequestBodyContentType string `yaml:"request_body_content_type,omitempty"`
+	// Whether the request body is sent gzip-compressed.
+	CompressRequestBody bool `yaml:"compress_request_body,omitempty"`
+	// The Accept header sent when fetching metrics from targets. If empty,
+	// all supported exposition formats are accepted.
+	AcceptHeader string `yaml:"accept_header,omitempty"`
 	// The HTTP basic authentication credentials for the targets.
 	BasicAuth *BasicAuth `yaml:"basic_auth,omitempty"`
+	// The Kerberos credentials authenticating scrapes with the HTTP Negotiate
+	// scheme (SPNEGO).
+	NegotiateAuth *NegotiateAuth `yaml:"negotiate_auth,omitempty"`
 	// The bearer token for the targets.
 	BearerToken string `yaml:"bearer_token,omitempty"`
 	// The bearer token file for the targets.
 	BearerTokenFile string `yaml:"bearer_token_file,omitempty"`
+	// The URL from which the bearer token for the targets is fetched.
+	BearerTokenURL string `yaml:"bearer_token_url,omitempty"`
+	// How long a bearer token fetched from the bearer token URL is reused.
+	BearerTokenTTL Duration `yaml:"bearer_token_ttl,omitempty"`
 	// The ca cert to use for the targets.
 	CACert string `yaml:"ca_cert,omitempty"`
+	// Additional ca certs to use for the targets.
+	CACerts []string `yaml:"ca_certs,omitempty"`
+	// Whether the system cert pool is used in addition to the configured ca certs.
+	UseSystemCertPool bool `yaml:"use_system_cert_pool,omitempty"`
 	// The client cert authentication credentials for the targets.
 	ClientCert *ClientCert `yaml:"client_cert,omitempty"`
+	// The client cert authentication credentials for the targets read from a
+	// PKCS#12 bundle.
+	ClientCertP12 *ClientCertP12 `yaml:"client_cert_p12,omitempty"`
+	// The number of TLS sessions of the targets cached for resumption of
+	// later connections. A default size is used if zero. Sessions are not
+	// resumed if negative.
+	TLSSessionCacheSize int `yaml:"tls_session_cache_size,omitempty"`
 	// HTTP proxy server to use to connect to the targets.
 	ProxyURL URL `yaml:"proxy_url,omitempty"`
+	// SOCKS5 proxy server to use to connect to the targets.
+	SOCKS5Proxy *SOCKS5Proxy `yaml:"socks5_proxy,omitempty"`
+	// The address of the DNS server resolving the host names of the targets
+	// and proxies. The system resolver is used if empty.
+	DNSServer string `yaml:"dns_server,omitempty"`
+	// How samples of the same series occurring more than once in a scrape
+	// response are handled. If empty, samples are appended as they are parsed
+	// so that effectively the last sample wins.
+	DuplicateSampleHandling DuplicateSampleHandling `yaml:"duplicate_sample_handling,omitempty"`
+	// Whether to scrape the targets via HTTP/2. Targets with the http scheme,
+	// including relabeled ones, must support HTTP/2 over cleartext (h2c).
+	// HTTP/2 is provided by the standard library transport rather than by
+	// golang.org/x/net/http2.
+	EnableHTTP2 bool `yaml:"enable_http2,omitempty"`
 
 	// List of labeled target groups for this job.
 	TargetGroups []*TargetGroup `yaml:"target_groups,omitempty"`
@@ -320,6 +599,20 @@ type ScrapeConfig struct {
 	RelabelConfigs []*RelabelConfig `yaml:"relabel_configs,omitempty"`
 	// List of metric relabel configurations.
 	MetricRelabelConfigs []*RelabelConfig `yaml:"metric_relabel_configs,omitempty"`
+	// List of transformations of the values of scraped samples. They are
+	// applied after metric relabeling.
+	ValueTransforms []*ValueTransformConfig `yaml:"value_transforms,omitempty"`
+	// List of bounds of the values of scraped samples. Samples with finite
+	// values outside the bounds are dropped. They are applied after the
+	// value transformations.
+	ValueFilters []*ValueFilterConfig `yaml:"value_filters,omitempty"`
+	// Regular expressions of which the names of scraped metrics have to
+	// match at least one after metric relabeling. Samples of other metrics
+	// are dropped. All metrics are kept if empty.
+	MetricNameAllowlist []*Regexp `yaml:"metric_name_allowlist,omitempty"`
+	// List of metric selectors. Scraped samples matching any of them are
+	// dropped.
+	DropSampleSelectors []string `yaml:"drop_sample_selectors,omitempty"`
 
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]interface{} `yaml:",inline"`
@@ -336,15 +629,282 @@ func (c *ScrapeConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if !patJobName.MatchString(c.JobName) {
 		return fmt.Errorf("%q is not a valid job name", c.JobName)
 	}
+	if err := c.checkCACerts(false); err != nil {
+		return err
+	}
+	for _, sel := range c.DropSampleSelectors {
+		if _, err := promql.ParseMetricSelector(sel); err != nil {
+			return fmt.Errorf("invalid drop sample selector %q: %s", sel, err)
+		}
+	}
 	if len(c.BearerToken) > 0 && len(c.BearerTokenFile) > 0 {
 		return fmt.Errorf("at most one of bearer_token & bearer_token_file must be configured")
 	}
+	if c.ClientCert != nil && c.ClientCertP12 != nil {
+		return fmt.Errorf("at most one of client_cert & client_cert_p12 must be configured")
+	}
 	if c.BasicAuth != nil && (len(c.BearerToken) > 0 || len(c.BearerTokenFile) > 0) {
 		return fmt.Errorf("at most one of basic_auth, bearer_token & bearer_token_file must be configured")
 	}
+	if c.NegotiateAuth != nil && (c.BasicAuth != nil || len(c.BearerToken) > 0 || len(c.BearerTokenFile) > 0 || len(c.BearerTokenURL) > 0) {
+		return fmt.Errorf("at most one of basic_auth, bearer_token, bearer_token_file, bearer_token_url & negotiate_auth must be configured")
+	}
+	if len(c.BearerTokenURL) > 0 {
+		if c.BasicAuth != nil || len(c.BearerToken) > 0 || len(c.BearerTokenFile) > 0 {
+			return fmt.Errorf("at most one of basic_auth, bearer_token, bearer_token_file & bearer_token_url must be configured")
+		}
+		if _, err := url.Parse(c.BearerTokenURL); err != nil {
+			return fmt.Errorf("invalid bearer_token_url %q: %s", c.BearerTokenURL, err)
+		}
+		if c.BearerTokenTTL == 0 {
+			c.BearerTokenTTL = DefaultBearerTokenTTL
+		}
+	}
+	if c.HonorLabels && len(c.LabelCollisionPolicy) > 0 {
+		return fmt.Errorf("label_collision_policy has no effect if honor_labels is set")
+	}
+	if c.HonorLabelsKeepJob && !c.HonorLabels {
+		return fmt.Errorf("honor_labels_keep_job has no effect if honor_labels is not set")
+	}
+	if c.HonorLabels && len(c.HonorLabelNames) > 0 {
+		return fmt.Errorf("honor_label_names has no effect if honor_labels is set")
+	}
+	if len(c.TimestampToleranceAction) > 0 && c.TimestampTolerance == 0 {
+		return fmt.Errorf("timestamp_tolerance_action requires timestamp_tolerance to be set")
+	}
+	if c.SOCKS5Proxy != nil && c.ProxyURL.URL != nil {
+		return fmt.Errorf("at most one of proxy_url & socks5_proxy must be configured")
+	}
+	switch c.HTTPMethod = strings.ToUpper(c.HTTPMethod); c.HTTPMethod {
+	case "", "GET", "POST":
+	default:
+		return fmt.Errorf("unsupported http_method %q, expected GET or POST", c.HTTPMethod)
+	}
+	if len(c.RequestBody) > 0 && c.HTTPMethod != "POST" {
+		return fmt.Errorf("request_body requires http_method POST")
+	}
+	if len(c.RequestBodyContentType) > 0 && len(c.RequestBody) == 0 {
+		return fmt.Errorf("request_body_content_type requires request_body to be set")
+	}
+	if c.CompressRequestBody && len(c.RequestBody) == 0 {
+		return fmt.Errorf("compress_request_body requires request_body to be set")
+	}
+	if c.ScrapeDurationDecay < 0 || c.ScrapeDurationDecay > 1 {
+		return fmt.Errorf("scrape_duration_decay must be between 0 and 1, got %g", c.ScrapeDurationDecay)
+	}
+	if len(c.DNSServer) > 0 {
+		if _, _, err := net.SplitHostPort(c.DNSServer); err != nil {
+			return fmt.Errorf("invalid dns_server address %q: %s", c.DNSServer, err)
+		}
+	}
+	if c.SeriesLimit < 0 {
+		return fmt.Errorf("series_limit must not be negative, got %d", c.SeriesLimit)
+	}
+	if c.ScrapeLogSize < 0 {
+		return fmt.Errorf("scrape_log_size must not be negative, got %d", c.ScrapeLogSize)
+	}
+	if c.ScrapeDumpSizeLimit < 0 {
+		return fmt.Errorf("scrape_dump_size_limit must not be negative, got %d", c.ScrapeDumpSizeLimit)
+	}
+	if c.ScrapeDumpFiles < 0 {
+		return fmt.Errorf("scrape_dump_files must not be negative, got %d", c.ScrapeDumpFiles)
+	}
+	if c.SampleRateLimit < 0 {
+		return fmt.Errorf("sample_rate_limit must not be negative, got %v", c.SampleRateLimit)
+	}
+	if c.SampleRateBurst < 0 {
+		return fmt.Errorf("sample_rate_burst must not be negative, got %d", c.SampleRateBurst)
+	}
+	if c.SampleRateBurst > 0 && c.SampleRateLimit == 0 {
+		return fmt.Errorf("sample_rate_burst requires sample_rate_limit to be set")
+	}
+	for _, code := range c.AcceptStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid HTTP status code %d in accept_status_codes", code)
+		}
+	}
+	if c.BodySizeLimit < 0 {
+		return fmt.Errorf("body_size_limit must not be negative, got %d", c.BodySizeLimit)
+	}
+	if c.GzipSizeThreshold < 0 {
+		return fmt.Errorf("gzip_size_threshold must not be negative, got %d", c.GzipSizeThreshold)
+	}
+	if c.HealthCheckPath != "" && !strings.HasPrefix(c.HealthCheckPath, "/") {
+		return fmt.Errorf("health_check_path %q must start with a slash", c.HealthCheckPath)
+	}
+	if c.ParallelParseThreshold < 0 {
+		return fmt.Errorf("parallel_parse_threshold must not be negative, got %d", c.ParallelParseThreshold)
+	}
+	if c.ParallelParseWorkers < 0 {
+		return fmt.Errorf("parallel_parse_workers must not be negative, got %d", c.ParallelParseWorkers)
+	}
+	if c.MaxConcurrentScrapes < 0 {
+		return fmt.Errorf("max_concurrent_scrapes must not be negative, got %d", c.MaxConcurrentScrapes)
+	}
+	if c.HonorTimestamps && len(c.TimestampHeader) == 0 {
+		c.TimestampHeader = DefaultTimestampHeader
+	}
+	if len(c.AcceptHeader) > 0 && !acceptsSupportedFormat(c.AcceptHeader) {
+		return fmt.Errorf("accept header %q does not include a supported exposition format", c.AcceptHeader)
+	}
 	return checkOverflow(c.XXX, "scrape_config")
 }
 
+// supportedMediaTypes are the media types of the exposition formats that can
+// be processed when scraping targets.
+var supportedMediaTypes = map[string]struct{}{
+	"application/vnd.google.protobuf": {},
+	"text/plain":                      {},
+	"application/json":                {},
+}
+
+// acceptsSupportedFormat returns true iff the given Accept header value
+// contains at least one media range of a supported exposition format.
+func acceptsSupportedFormat(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		if _, ok := supportedMediaTypes[mediaType]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelCollisionPolicy is the way scraped labels colliding with target labels
+// are handled.
+type LabelCollisionPolicy string
+
+const (
+	// Stores the scraped label value in a label with the exported prefix.
+	LabelCollisionPrefix LabelCollisionPolicy = "prefix"
+	// Drops the scraped label value.
+	LabelCollisionDrop LabelCollisionPolicy = "drop"
+	// Fails the scrape. None of the scraped samples are appended.
+	LabelCollisionError LabelCollisionPolicy = "error"
+)
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (p *LabelCollisionPolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	switch lp := LabelCollisionPolicy(strings.ToLower(s)); lp {
+	case LabelCollisionPrefix, LabelCollisionDrop, LabelCollisionError:
+		*p = lp
+		return nil
+	}
+	return fmt.Errorf("unknown label collision policy %q", s)
+}
+
+// MissingMetadataAction is the action performed on scraped samples of metric
+// families without TYPE metadata.
+type MissingMetadataAction string
+
+const (
+	// Fails the scrape. None of the scraped samples are appended.
+	MissingMetadataError MissingMetadataAction = "error"
+	// Drops the sample.
+	MissingMetadataDrop MissingMetadataAction = "drop"
+)
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (a *MissingMetadataAction) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	switch act := MissingMetadataAction(strings.ToLower(s)); act {
+	case MissingMetadataError, MissingMetadataDrop:
+		*a = act
+		return nil
+	}
+	return fmt.Errorf("unknown missing metadata action %q", s)
+}
+
+// TimestampToleranceAction is the action performed on samples with timestamps
+// too far in the future.
+type TimestampToleranceAction string
+
+const (
+	// Sets the timestamp to the latest tolerated timestamp.
+	TimestampClamp TimestampToleranceAction = "clamp"
+	// Drops the sample.
+	TimestampReject TimestampToleranceAction = "reject"
+)
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (a *TimestampToleranceAction) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	switch act := TimestampToleranceAction(strings.ToLower(s)); act {
+	case TimestampClamp, TimestampReject:
+		*a = act
+		return nil
+	}
+	return fmt.Errorf("unknown timestamp tolerance action %q", s)
+}
+
+// NonFiniteValueAction is the action performed on samples with NaN or
+// infinite values.
+type NonFiniteValueAction string
+
+const (
+	// Keeps the sample unchanged.
+	NonFinitePass NonFiniteValueAction = "pass"
+	// Drops the sample.
+	NonFiniteDrop NonFiniteValueAction = "drop"
+	// Sets infinite values to the largest finite value of the same sign and
+	// NaN to zero.
+	NonFiniteClamp NonFiniteValueAction = "clamp"
+)
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (a *NonFiniteValueAction) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	switch act := NonFiniteValueAction(strings.ToLower(s)); act {
+	case NonFinitePass, NonFiniteDrop, NonFiniteClamp:
+		*a = act
+		return nil
+	}
+	return fmt.Errorf("unknown non-finite value action %q", s)
+}
+
+// DuplicateSampleHandling is the way samples of the same series occurring
+// more than once in a scrape response are handled.
+type DuplicateSampleHandling string
+
+const (
+	// Keeps the last sample of a series.
+	DuplicateSampleLastWins DuplicateSampleHandling = "last_wins"
+	// Keeps the first sample of a series.
+	DuplicateSampleFirstWins DuplicateSampleHandling = "first_wins"
+	// Fails the scrape.
+	DuplicateSampleError DuplicateSampleHandling = "error"
+)
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (h *DuplicateSampleHandling) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	switch dh := DuplicateSampleHandling(strings.ToLower(s)); dh {
+	case DuplicateSampleLastWins, DuplicateSampleFirstWins, DuplicateSampleError:
+		*h = dh
+		return nil
+	}
+	return fmt.Errorf("unknown duplicate sample handling %q", s)
+}
+
 // BasicAuth contains basic HTTP authentication credentials.
 type BasicAuth struct {
 	Username string `yaml:"username"`
@@ -354,15 +914,93 @@ type BasicAuth struct {
 	XXX map[string]interface{} `yaml:",inline"`
 }
 
-// ClientCert contains client cert credentials.
+// ClientCert contains client cert credentials. The cert and key are either
+// read from files or provided inline in PEM format.
 type ClientCert struct {
-	Cert string `yaml:"cert"`
-	Key  string `yaml:"key"`
+	Cert    string `yaml:"cert,omitempty"`
+	Key     string `yaml:"key,omitempty"`
+	CertPEM string `yaml:"cert_pem,omitempty"`
+	KeyPEM  string `yaml:"key_pem,omitempty"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *ClientCert) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ClientCert
+	err := unmarshal((*plain)(c))
+	if err != nil {
+		return err
+	}
+	if (len(c.Cert) > 0 || len(c.Key) > 0) && (len(c.CertPEM) > 0 || len(c.KeyPEM) > 0) {
+		return fmt.Errorf("at most one of cert & key or cert_pem & key_pem must be configured")
+	}
+	return checkOverflow(c.XXX, "client_cert")
+}
+
+// ClientCertP12 contains client cert credentials read from a PKCS#12 bundle
+// holding the cert and its key. The password of the bundle is either given
+// inline or read from a file.
+type ClientCertP12 struct {
+	File         string `yaml:"file"`
+	Password     string `yaml:"password,omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *ClientCertP12) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ClientCertP12
+	err := unmarshal((*plain)(c))
+	if err != nil {
+		return err
+	}
+	if len(c.File) == 0 {
+		return fmt.Errorf("client_cert_p12 requires a file")
+	}
+	if len(c.Password) > 0 && len(c.PasswordFile) > 0 {
+		return fmt.Errorf("at most one of password & password_file must be configured")
+	}
+	return checkOverflow(c.XXX, "client_cert_p12")
+}
+
+// NegotiateAuth configures the authentication of scrapes with the HTTP
+// Negotiate scheme (RFC 4559) using Kerberos service tickets. The tickets are
+// obtained from the KDCs of the krb5.conf file with the key of the user
+// principal read from the keytab file.
+type NegotiateAuth struct {
+	KeytabFile     string `yaml:"keytab_file"`
+	Krb5ConfigFile string `yaml:"krb5_config_file"`
+	// The name of the user principal without the realm.
+	Username string `yaml:"username"`
+	Realm    string `yaml:"realm"`
+	// The principal name of the scraped service. Defaults to HTTP/ followed
+	// by the host name of each target.
+	SPN string `yaml:"spn,omitempty"`
 
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]interface{} `yaml:",inline"`
 }
 
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (a *NegotiateAuth) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain NegotiateAuth
+	err := unmarshal((*plain)(a))
+	if err != nil {
+		return err
+	}
+	if len(a.KeytabFile) == 0 || len(a.Krb5ConfigFile) == 0 {
+		return fmt.Errorf("negotiate_auth requires a keytab_file and a krb5_config_file")
+	}
+	if len(a.Username) == 0 || len(a.Realm) == 0 {
+		return fmt.Errorf("negotiate_auth requires a username and a realm")
+	}
+	return checkOverflow(a.XXX, "negotiate_auth")
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
 func (a *BasicAuth) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	type plain BasicAuth
@@ -373,6 +1011,42 @@ func (a *BasicAuth) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return checkOverflow(a.XXX, "basic_auth")
 }
 
+// SOCKS5Proxy configures a SOCKS5 proxy server and its optional
+// username/password authentication.
+type SOCKS5Proxy struct {
+	Address  string `yaml:"address"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (p *SOCKS5Proxy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain SOCKS5Proxy
+	err := unmarshal((*plain)(p))
+	if err != nil {
+		return err
+	}
+	if _, _, err := net.SplitHostPort(p.Address); err != nil {
+		return fmt.Errorf("invalid socks5_proxy address %q: %s", p.Address, err)
+	}
+	if len(p.Password) > 0 && len(p.Username) == 0 {
+		return fmt.Errorf("socks5_proxy password requires a username")
+	}
+	return checkOverflow(p.XXX, "socks5_proxy")
+}
+
+// URL returns the URL of the proxy including its credentials.
+func (p *SOCKS5Proxy) URL() *url.URL {
+	u := &url.URL{Scheme: "socks5", Host: p.Address}
+	if len(p.Username) > 0 {
+		u.User = url.UserPassword(p.Username, p.Password)
+	}
+	return u
+}
+
 // TargetGroup is a set of targets with a common label set.
 type TargetGroup struct {
 	// Targets is a list of targets identified by a label set. Each target is
@@ -631,10 +1305,17 @@ type RelabelConfig struct {
 	// A list of labels from which values are taken and concatenated
 	// with the configured separator in order.
 	SourceLabels clientmodel.LabelNames `yaml:"source_labels,flow"`
+	// A list of environment variables of the Prometheus process whose values
+	// are concatenated after the values of the source labels. Unset
+	// variables yield empty values.
+	SourceEnv []string `yaml:"source_env,flow,omitempty"`
 	// Separator is the string between concatenated values from the source labels.
 	Separator string `yaml:"separator,omitempty"`
 	// Regex against which the concatenation is matched.
 	Regex *Regexp `yaml:"regex,omitempty"`
+	// Whether the regex must match the entire concatenation rather than
+	// any substring of it.
+	FullMatch bool `yaml:"full_match,omitempty"`
 	// Modulus to take of the hash of concatenated values from the source labels.
 	Modulus uint64 `yaml:"modulus,omitempty"`
 	// The label to which the resulting string is written in a replacement.
@@ -664,6 +1345,65 @@ func (c *RelabelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return checkOverflow(c.XXX, "relabel_config")
 }
 
+// ValueTransformConfig is the configuration for transforming the values of
+// scraped samples. The transformed value is value * scale + offset.
+type ValueTransformConfig struct {
+	// Regex against which the entire metric name is matched.
+	MetricName *Regexp `yaml:"metric_name"`
+	// Factor by which matching sample values are multiplied.
+	Scale float64 `yaml:"scale,omitempty"`
+	// Offset added to matching sample values after scaling.
+	Offset float64 `yaml:"offset,omitempty"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *ValueTransformConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultValueTransformConfig
+	type plain ValueTransformConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.MetricName == nil {
+		return fmt.Errorf("value transform configuration requires a metric name regular expression")
+	}
+	return checkOverflow(c.XXX, "value_transform")
+}
+
+// ValueFilterConfig is the configuration for dropping samples whose values
+// are out of range.
+type ValueFilterConfig struct {
+	// Regex against which the entire metric name is matched.
+	MetricName *Regexp `yaml:"metric_name"`
+	// The lowest value kept. Values are not bounded below if nil.
+	Min *float64 `yaml:"min,omitempty"`
+	// The highest value kept. Values are not bounded above if nil.
+	Max *float64 `yaml:"max,omitempty"`
+
+	// Catches all undefined fields and must be empty after parsing.
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *ValueFilterConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ValueFilterConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.MetricName == nil {
+		return fmt.Errorf("value filter configuration requires a metric name regular expression")
+	}
+	if c.Min == nil && c.Max == nil {
+		return fmt.Errorf("value filter configuration requires a min or max value")
+	}
+	if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+		return fmt.Errorf("value filter min %v is greater than max %v", *c.Min, *c.Max)
+	}
+	return checkOverflow(c.XXX, "value_filter")
+}
+
 // Regexp encapsulates a regexp.Regexp and makes it YAML marshallable.
 type Regexp struct {
 	regexp.Regexp
@@ -19,6 +19,7 @@ var expectedConf = &Config{
 		ScrapeInterval:     Duration(15 * time.Second),
 		ScrapeTimeout:      DefaultGlobalConfig.ScrapeTimeout,
 		EvaluationInterval: Duration(30 * time.Second),
+		MinScrapeInterval:  DefaultGlobalConfig.MinScrapeInterval,
 
 		Labels: clientmodel.LabelSet{
 			"monitor": "codelab",
@@ -36,9 +37,10 @@ var expectedConf = &Config{
 		{
 			JobName: "prometheus",
 
-			HonorLabels:    true,
-			ScrapeInterval: Duration(15 * time.Second),
-			ScrapeTimeout:  DefaultGlobalConfig.ScrapeTimeout,
+			HonorLabels:         true,
+			ScrapeInterval:      Duration(15 * time.Second),
+			ScrapeTimeout:       DefaultGlobalConfig.ScrapeTimeout,
+			ScrapeHeaderTimeout: DefaultGlobalConfig.ScrapeTimeout,
 
 			MetricsPath: DefaultScrapeConfig.MetricsPath,
 			Scheme:      DefaultScrapeConfig.Scheme,
@@ -83,8 +85,9 @@ var expectedConf = &Config{
 		{
 			JobName: "service-x",
 
-			ScrapeInterval: Duration(50 * time.Second),
-			ScrapeTimeout:  Duration(5 * time.Second),
+			ScrapeInterval:      Duration(50 * time.Second),
+			ScrapeTimeout:       Duration(5 * time.Second),
+			ScrapeHeaderTimeout: Duration(5 * time.Second),
 
 			BasicAuth: &BasicAuth{
 				Username: "admin_name",
@@ -131,6 +134,14 @@ var expectedConf = &Config{
 					Separator:    ";",
 					Action:       RelabelKeep,
 				},
+				{
+					SourceEnv:   []string{"DC"},
+					Regex:       &Regexp{*regexp.MustCompile("(.+)")},
+					TargetLabel: "datacenter",
+					Separator:   ";",
+					Replacement: "$1",
+					Action:      RelabelReplace,
+				},
 			},
 			MetricRelabelConfigs: []*RelabelConfig{
 				{
@@ -144,8 +155,9 @@ var expectedConf = &Config{
 		{
 			JobName: "service-y",
 
-			ScrapeInterval: Duration(15 * time.Second),
-			ScrapeTimeout:  DefaultGlobalConfig.ScrapeTimeout,
+			ScrapeInterval:      Duration(15 * time.Second),
+			ScrapeTimeout:       DefaultGlobalConfig.ScrapeTimeout,
+			ScrapeHeaderTimeout: DefaultGlobalConfig.ScrapeTimeout,
 
 			MetricsPath: DefaultScrapeConfig.MetricsPath,
 			Scheme:      DefaultScrapeConfig.Scheme,
@@ -162,8 +174,9 @@ var expectedConf = &Config{
 		{
 			JobName: "service-z",
 
-			ScrapeInterval: Duration(15 * time.Second),
-			ScrapeTimeout:  Duration(10 * time.Second),
+			ScrapeInterval:      Duration(15 * time.Second),
+			ScrapeTimeout:       Duration(10 * time.Second),
+			ScrapeHeaderTimeout: Duration(10 * time.Second),
 
 			MetricsPath: "/metrics",
 			Scheme:      "http",
@@ -174,6 +187,23 @@ var expectedConf = &Config{
 			},
 			BearerToken: "avalidtoken",
 		},
+		{
+			JobName: "service-k",
+
+			ScrapeInterval:      Duration(15 * time.Second),
+			ScrapeTimeout:       Duration(10 * time.Second),
+			ScrapeHeaderTimeout: Duration(10 * time.Second),
+
+			MetricsPath: "/metrics",
+			Scheme:      "http",
+
+			NegotiateAuth: &NegotiateAuth{
+				KeytabFile:     "testdata/valid_keytab_file",
+				Krb5ConfigFile: "testdata/valid_krb5_config_file",
+				Username:       "prometheus",
+				Realm:          "EXAMPLE.ORG",
+			},
+		},
 	},
 	original: "",
 }
@@ -247,6 +277,129 @@ var expectedErrors = []struct {
 	}, {
 		filename: "bearertoken_basicauth.bad.yml",
 		errMsg:   "at most one of basic_auth, bearer_token & bearer_token_file must be configured",
+	}, {
+		filename: "ca_certs_missing.bad.yml",
+		errMsg:   "unable to read CA cert",
+	}, {
+		filename: "ca_cert_missing.bad.yml",
+		errMsg:   "unable to read CA cert",
+	}, {
+		filename: "drop_sample_selector.bad.yml",
+		errMsg:   `invalid drop sample selector "up{job=}"`,
+	}, {
+		filename: "negotiate_auth.bad.yml",
+		errMsg:   "at most one of basic_auth, bearer_token, bearer_token_file, bearer_token_url & negotiate_auth must be configured",
+	}, {
+		filename: "negotiate_auth_keytab.bad.yml",
+		errMsg:   "negotiate_auth requires a keytab_file and a krb5_config_file",
+	}, {
+		filename: "negotiate_auth_principal.bad.yml",
+		errMsg:   "negotiate_auth requires a username and a realm",
+	}, {
+		filename: "client_cert_p12.bad.yml",
+		errMsg:   "at most one of client_cert & client_cert_p12 must be configured",
+	}, {
+		filename: "client_cert_p12_password.bad.yml",
+		errMsg:   "at most one of password & password_file must be configured",
+	}, {
+		filename: "clientcert_pem.bad.yml",
+		errMsg:   "at most one of cert & key or cert_pem & key_pem must be configured",
+	}, {
+		filename: "accept_header.bad.yml",
+		errMsg:   "does not include a supported exposition format",
+	}, {
+		filename: "bearertoken_url.bad.yml",
+		errMsg:   "at most one of basic_auth, bearer_token, bearer_token_file & bearer_token_url must be configured",
+	}, {
+		filename: "scrape_interval.bad.yml",
+		errMsg:   `scrape interval 0s of scrape config "prometheus" is below the minimum scrape interval 1s`,
+	}, {
+		filename: "max_concurrent_scrapes.bad.yml",
+		errMsg:   "max_concurrent_scrapes must not be negative, got -1",
+	}, {
+		filename: "duplicate_sample_handling.bad.yml",
+		errMsg:   `unknown duplicate sample handling "newest"`,
+	}, {
+		filename: "label_collision_policy.bad.yml",
+		errMsg:   `unknown label collision policy "rename"`,
+	}, {
+		filename: "label_collision_policy_honor.bad.yml",
+		errMsg:   "label_collision_policy has no effect if honor_labels is set",
+	}, {
+		filename: "timestamp_tolerance.bad.yml",
+		errMsg:   "timestamp_tolerance_action requires timestamp_tolerance to be set",
+	}, {
+		filename: "socks5_proxy.bad.yml",
+		errMsg:   "at most one of proxy_url & socks5_proxy must be configured",
+	}, {
+		filename: "socks5_proxy_address.bad.yml",
+		errMsg:   `invalid socks5_proxy address "bastion.example.org"`,
+	}, {
+		filename: "scrape_header_timeout.bad.yml",
+		errMsg:   `scrape header timeout 10s of scrape config "prometheus" exceeds its scrape timeout 5s`,
+	}, {
+		filename: "http_method.bad.yml",
+		errMsg:   `unsupported http_method "PUT", expected GET or POST`,
+	}, {
+		filename: "request_body.bad.yml",
+		errMsg:   "request_body requires http_method POST",
+	}, {
+		filename: "scrape_duration_decay.bad.yml",
+		errMsg:   "scrape_duration_decay must be between 0 and 1, got 1.5",
+	}, {
+		filename: "compress_request_body.bad.yml",
+		errMsg:   "compress_request_body requires request_body to be set",
+	}, {
+		filename: "series_limit.bad.yml",
+		errMsg:   "series_limit must not be negative, got -1",
+	}, {
+		filename: "dns_server.bad.yml",
+		errMsg:   `invalid dns_server address "10.0.0.53"`,
+	}, {
+		filename: "value_transform.bad.yml",
+		errMsg:   "value transform configuration requires a metric name regular expression",
+	}, {
+		filename: "value_filter.bad.yml",
+		errMsg:   "value filter min 10 is greater than max 0",
+	}, {
+		filename: "non_finite_value_action.bad.yml",
+		errMsg:   `unknown non-finite value action "zero"`,
+	}, {
+		filename: "body_size_limit.bad.yml",
+		errMsg:   "body_size_limit must not be negative, got -1",
+	}, {
+		filename: "initial_scrape_delay.bad.yml",
+		errMsg:   `initial scrape delay 2m0s of scrape config "prometheus" exceeds its scrape interval 1m0s`,
+	}, {
+		filename: "honor_label_names.bad.yml",
+		errMsg:   "honor_label_names has no effect if honor_labels is set",
+	}, {
+		filename: "honor_labels_keep_job.bad.yml",
+		errMsg:   "honor_labels_keep_job has no effect if honor_labels is not set",
+	}, {
+		filename: "accept_status_codes.bad.yml",
+		errMsg:   "invalid HTTP status code 2000 in accept_status_codes",
+	}, {
+		filename: "gzip_size_threshold.bad.yml",
+		errMsg:   "gzip_size_threshold must not be negative, got -1",
+	}, {
+		filename: "parallel_parse_workers.bad.yml",
+		errMsg:   "parallel_parse_workers must not be negative, got -2",
+	}, {
+		filename: "health_check_path.bad.yml",
+		errMsg:   `health_check_path "healthz" must start with a slash`,
+	}, {
+		filename: "sample_rate_burst.bad.yml",
+		errMsg:   "sample_rate_burst requires sample_rate_limit to be set",
+	}, {
+		filename: "scrape_log_size.bad.yml",
+		errMsg:   "scrape_log_size must not be negative, got -1",
+	}, {
+		filename: "scrape_dump_files.bad.yml",
+		errMsg:   "scrape_dump_files must not be negative, got -1",
+	}, {
+		filename: "require_metadata.bad.yml",
+		errMsg:   `unknown missing metadata action "warn"`,
 	},
 }
 
@@ -299,3 +452,48 @@ func TestEmptyGlobalBlock(t *testing.T) {
 		t.Fatalf("want %v, got %v", exp, c)
 	}
 }
+
+func TestCACertsChecked(t *testing.T) {
+	// Absolute paths are checked when the scrape config is parsed.
+	var c ScrapeConfig
+	err := yaml.Unmarshal([]byte("job_name: prometheus\nca_cert: /nonexistent/ca.cer\n"), &c)
+	if err == nil || !strings.Contains(err.Error(), "unable to read CA cert") {
+		t.Errorf("Expected error reading the CA cert, got %v", err)
+	}
+
+	// Relative paths are checked against the working directory by Load.
+	cfg := `
+scrape_configs:
+- job_name: prometheus
+  ca_certs:
+  - missing_ca_file
+`
+	if _, err := Load(cfg); err == nil || !strings.Contains(err.Error(), "unable to read CA cert") {
+		t.Errorf("Expected error reading the CA cert, got %v", err)
+	}
+}
+
+func TestAllowFastScrapes(t *testing.T) {
+	cfg := `
+global:
+  scrape_interval: 0s
+  min_scrape_interval: 1s
+scrape_configs:
+- job_name: prometheus
+  allow_fast_scrapes: true
+`
+	if _, err := Load(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing config with fast scrapes: %s", err)
+	}
+
+	// There is no minimum scrape interval by default.
+	cfg = `
+global:
+  scrape_interval: 0s
+scrape_configs:
+- job_name: prometheus
+`
+	if _, err := Load(cfg); err != nil {
+		t.Fatalf("Unexpected error parsing config without minimum scrape interval: %s", err)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"sync"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/metric"
+)
+
+// matcherAppender is a SampleAppender that drops all samples matching all of
+// its label matchers and appends the remaining ones to the wrapped
+// SampleAppender.
+type matcherAppender struct {
+	storage.SampleAppender
+
+	matchers metric.LabelMatchers
+}
+
+// Append implements storage.SampleAppender.
+func (app matcherAppender) Append(s *clientmodel.Sample) {
+	for _, m := range app.matchers {
+		if !m.Match(s.Metric[m.Name]) {
+			app.SampleAppender.Append(s)
+			return
+		}
+	}
+}
+
+// SampleBatch holds the samples of a scrape in parallel slices. The sample at
+// index i consists of Metrics[i], Timestamps[i], and Values[i].
+type SampleBatch struct {
+	Metrics    []clientmodel.Metric
+	Timestamps []clientmodel.Timestamp
+	Values     []clientmodel.SampleValue
+}
+
+// Len returns the number of samples in the batch.
+func (b *SampleBatch) Len() int {
+	return len(b.Metrics)
+}
+
+// A BatchAppender receives all samples of a scrape as a single batch.
+type BatchAppender interface {
+	// AppendBatch appends the samples of the batch. The batch is only valid
+	// until the method returns and must not be retained.
+	AppendBatch(*SampleBatch)
+}
+
+// BatchingAppender is a SampleAppender that accumulates appended samples
+// and hands them to a BatchAppender on Flush. The slices of the batch are
+// reused between flushes to reduce allocations. It is safe for concurrent use.
+//
+// A BatchingAppender may be shared by the scrapers of many targets. Each
+// scrape accumulates its samples in a batch of its own, which is handed off
+// after all samples of the scrape have been appended. This also applies to
+// BatchingAppenders that are part of a storage.Fanout.
+type BatchingAppender struct {
+	app BatchAppender
+
+	mtx   sync.Mutex
+	batch SampleBatch
+
+	// Per-scrape appenders, kept to reuse the slices of their batches.
+	scrapes sync.Pool
+}
+
+// NewBatchingAppender returns a BatchingAppender that flushes to app.
+func NewBatchingAppender(app BatchAppender) *BatchingAppender {
+	return &BatchingAppender{app: app}
+}
+
+// Append implements storage.SampleAppender.
+func (a *BatchingAppender) Append(s *clientmodel.Sample) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	a.batch.Metrics = append(a.batch.Metrics, s.Metric)
+	a.batch.Timestamps = append(a.batch.Timestamps, s.Timestamp)
+	a.batch.Values = append(a.batch.Values, s.Value)
+}
+
+// Flush hands the accumulated samples to the BatchAppender and resets the
+// batch. Nothing is handed off if the batch is empty.
+func (a *BatchingAppender) Flush() {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.batch.Len() == 0 {
+		return
+	}
+	a.app.AppendBatch(&a.batch)
+
+	// Drop the references to the metrics so they can be garbage collected.
+	for i := range a.batch.Metrics {
+		a.batch.Metrics[i] = nil
+	}
+	a.batch.Metrics = a.batch.Metrics[:0]
+	a.batch.Timestamps = a.batch.Timestamps[:0]
+	a.batch.Values = a.batch.Values[:0]
+}
+
+// startScrape returns a BatchingAppender accumulating the samples of a
+// single scrape. It must be passed to endScrape once the scrape is complete.
+func (a *BatchingAppender) startScrape() *BatchingAppender {
+	if sa, ok := a.scrapes.Get().(*BatchingAppender); ok {
+		return sa
+	}
+	return NewBatchingAppender(a.app)
+}
+
+// endScrape hands off the batch of a scrape started with startScrape.
+func (a *BatchingAppender) endScrape(sa *BatchingAppender) {
+	sa.Flush()
+	a.scrapes.Put(sa)
+}
+
+// startBatchedScrape replaces all BatchingAppenders that app consists of with
+// ones accumulating the samples of a single scrape. The returned function
+// hands off their batches and must be called once the scrape is complete.
+func startBatchedScrape(app storage.SampleAppender) (storage.SampleAppender, func()) {
+	switch a := app.(type) {
+	case *BatchingAppender:
+		sa := a.startScrape()
+		return sa, func() { a.endScrape(sa) }
+	case storage.Fanout:
+		var (
+			fanout storage.Fanout
+			ends   []func()
+		)
+		for _, app := range a {
+			app, end := startBatchedScrape(app)
+			fanout = append(fanout, app)
+			if end != nil {
+				ends = append(ends, end)
+			}
+		}
+		if ends == nil {
+			return a, nil
+		}
+		return fanout, func() {
+			for _, end := range ends {
+				end()
+			}
+		}
+	}
+	return app, nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/metric"
+)
+
+func TestMatcherAppender(t *testing.T) {
+	samples := clientmodel.Samples{
+		{Metric: clientmodel.Metric{clientmodel.MetricNameLabel: "foo", "a": "1"}},
+		{Metric: clientmodel.Metric{clientmodel.MetricNameLabel: "foo", "a": "2"}},
+		{Metric: clientmodel.Metric{clientmodel.MetricNameLabel: "bar", "a": "1"}},
+		{Metric: clientmodel.Metric{clientmodel.MetricNameLabel: "bar"}},
+	}
+
+	mustNewLabelMatcher := func(mt metric.MatchType, name clientmodel.LabelName, val clientmodel.LabelValue) *metric.LabelMatcher {
+		m, err := metric.NewLabelMatcher(mt, name, val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	tests := []struct {
+		matchers metric.LabelMatchers
+		// Indexes of the samples that are expected to be appended.
+		expected []int
+	}{
+		{
+			matchers: metric.LabelMatchers{
+				mustNewLabelMatcher(metric.Equal, clientmodel.MetricNameLabel, "foo"),
+			},
+			expected: []int{2, 3},
+		},
+		{
+			matchers: metric.LabelMatchers{
+				mustNewLabelMatcher(metric.Equal, clientmodel.MetricNameLabel, "foo"),
+				mustNewLabelMatcher(metric.Equal, "a", "1"),
+			},
+			expected: []int{1, 2, 3},
+		},
+		{
+			matchers: metric.LabelMatchers{
+				mustNewLabelMatcher(metric.NotEqual, "a", "1"),
+			},
+			expected: []int{0, 2},
+		},
+		{
+			matchers: metric.LabelMatchers{
+				mustNewLabelMatcher(metric.RegexMatch, clientmodel.MetricNameLabel, "^b"),
+				mustNewLabelMatcher(metric.RegexNoMatch, "a", "^$"),
+			},
+			expected: []int{0, 1, 3},
+		},
+		{
+			matchers: metric.LabelMatchers{},
+			expected: []int{},
+		},
+	}
+
+	for i, test := range tests {
+		result := &collectResultAppender{}
+		app := matcherAppender{SampleAppender: result, matchers: test.matchers}
+		for _, s := range samples {
+			app.Append(s)
+		}
+
+		expected := clientmodel.Samples{}
+		for _, j := range test.expected {
+			expected = append(expected, samples[j])
+		}
+		got := result.result
+		if got == nil {
+			got = clientmodel.Samples{}
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("%d. expected samples %v, got %v", i, expected, got)
+		}
+	}
+}
+
+type batchCollector struct {
+	mtx     sync.Mutex
+	batches []SampleBatch
+}
+
+func (c *batchCollector) AppendBatch(b *SampleBatch) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.batches = append(c.batches, SampleBatch{
+		Metrics:    append([]clientmodel.Metric(nil), b.Metrics...),
+		Timestamps: append([]clientmodel.Timestamp(nil), b.Timestamps...),
+		Values:     append([]clientmodel.SampleValue(nil), b.Values...),
+	})
+}
+
+func TestBatchingAppender(t *testing.T) {
+	collector := &batchCollector{}
+	app := NewBatchingAppender(collector)
+
+	samples := clientmodel.Samples{
+		{Metric: clientmodel.Metric{clientmodel.MetricNameLabel: "foo"}, Timestamp: 1, Value: 1},
+		{Metric: clientmodel.Metric{clientmodel.MetricNameLabel: "bar"}, Timestamp: 2, Value: 2},
+	}
+	for _, s := range samples {
+		app.Append(s)
+	}
+	if len(collector.batches) != 0 {
+		t.Fatalf("Expected no batch before flushing, got %d", len(collector.batches))
+	}
+
+	app.Flush()
+	// Flushing an empty batch does not hand it off.
+	app.Flush()
+
+	expected := []SampleBatch{{
+		Metrics:    []clientmodel.Metric{samples[0].Metric, samples[1].Metric},
+		Timestamps: []clientmodel.Timestamp{1, 2},
+		Values:     []clientmodel.SampleValue{1, 2},
+	}}
+	if !reflect.DeepEqual(collector.batches, expected) {
+		t.Errorf("Expected batches %v, got %v", expected, collector.batches)
+	}
+}
+
+func TestStartBatchedScrape(t *testing.T) {
+	collector := &batchCollector{}
+	shared := NewBatchingAppender(collector)
+	other := &collectResultAppender{}
+	s := &clientmodel.Sample{Metric: clientmodel.Metric{clientmodel.MetricNameLabel: "foo"}}
+
+	app, end := startBatchedScrape(storage.Fanout{shared, other})
+	app.Append(s)
+	// Samples appended to the shared appender itself are not part of the
+	// scrape's batch.
+	shared.Append(s)
+	end()
+
+	if len(collector.batches) != 1 || collector.batches[0].Len() != 1 {
+		t.Fatalf("Expected a single batch with one sample, got %v", collector.batches)
+	}
+	if len(other.result) != 1 {
+		t.Errorf("Expected one sample appended to the other appender, got %d", len(other.result))
+	}
+
+	if _, end := startBatchedScrape(other); end != nil {
+		t.Error("Expected no batches for appender without batching appenders")
+	}
+}
+
+type nopBatchAppender struct{}
+
+func (nopBatchAppender) AppendBatch(*SampleBatch) {}
+
+func TestBatchingAppenderAllocations(t *testing.T) {
+	app := NewBatchingAppender(nopBatchAppender{})
+	s := &clientmodel.Sample{Metric: clientmodel.Metric{clientmodel.MetricNameLabel: "foo"}}
+
+	appendBatch := func() {
+		for i := 0; i < 100; i++ {
+			app.Append(s)
+		}
+		app.Flush()
+	}
+	// Grow the batch to its final size.
+	appendBatch()
+
+	if allocs := testing.AllocsPerRun(100, appendBatch); allocs != 0 {
+		t.Errorf("Expected no allocations for a reused batch, got %v", allocs)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/text"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var errSamplesNotRetained = errors.New("samples of the last scrape are not retained")
+
+// LastScrapeExposition returns the samples of the last successful scrape in
+// the text exposition format. The samples are the ones passed on to storage,
+// i.e. with the target labels attached and after relabeling. Metrics are of
+// unknown type unless the target exposed metadata declaring them as counter
+// or gauge. Samples must be retained via RetainSamples.
+func (t *Target) LastScrapeExposition() ([]byte, error) {
+	t.RLock()
+	if !t.retainSamples {
+		t.RUnlock()
+		return nil, errSamplesNotRetained
+	}
+	samples := t.lastSamples
+	metadata := t.metadata
+	t.RUnlock()
+
+	var buf bytes.Buffer
+	for _, mf := range metricFamilies(samples, metadata) {
+		if _, err := text.MetricFamilyToText(&buf, mf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// metricFamilies groups the samples into metric families sorted by name. The
+// metrics of each family are sorted by their labels.
+func metricFamilies(samples clientmodel.Samples, metadata map[string]MetricMetadata) []*dto.MetricFamily {
+	byName := map[string]clientmodel.Samples{}
+	for _, s := range samples {
+		name := string(s.Metric[clientmodel.MetricNameLabel])
+		byName[name] = append(byName[name], s)
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mfs := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		md := metadata[name]
+		mf := &dto.MetricFamily{
+			Name: proto.String(name),
+			Type: dto.MetricType_UNTYPED.Enum(),
+		}
+		switch md.Type {
+		case "counter":
+			mf.Type = dto.MetricType_COUNTER.Enum()
+		case "gauge":
+			mf.Type = dto.MetricType_GAUGE.Enum()
+		}
+		if md.Help != "" {
+			mf.Help = proto.String(md.Help)
+		}
+
+		ss := byName[name]
+		sort.Sort(samplesByMetric(ss))
+		for _, s := range ss {
+			m := &dto.Metric{}
+			lns := make(clientmodel.LabelNames, 0, len(s.Metric))
+			for ln := range s.Metric {
+				if ln != clientmodel.MetricNameLabel {
+					lns = append(lns, ln)
+				}
+			}
+			sort.Sort(lns)
+			for _, ln := range lns {
+				m.Label = append(m.Label, &dto.LabelPair{
+					Name:  proto.String(string(ln)),
+					Value: proto.String(string(s.Metric[ln])),
+				})
+			}
+			v := proto.Float64(float64(s.Value))
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				m.Counter = &dto.Counter{Value: v}
+			case dto.MetricType_GAUGE:
+				m.Gauge = &dto.Gauge{Value: v}
+			default:
+				m.Untyped = &dto.Untyped{Value: v}
+			}
+			mf.Metric = append(mf.Metric, m)
+		}
+		mfs = append(mfs, mf)
+	}
+	return mfs
+}
+
+// samplesByMetric sorts samples by the string representation of their
+// metric.
+type samplesByMetric clientmodel.Samples
+
+func (s samplesByMetric) Len() int           { return len(s) }
+func (s samplesByMetric) Less(i, j int) bool { return s[i].Metric.String() < s[j].Metric.String() }
+func (s samplesByMetric) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,124 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/text"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestTargetLastScrapeExposition(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte(`# HELP requests_total The total number of requests.
+# TYPE requests_total counter
+requests_total{code="200"} 1027
+requests_total{code="500"} 3
+# TYPE temperature gauge
+temperature 21.5
+plain_metric 1
+`))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{clientmodel.JobLabel: "test"})
+	if _, err := testTarget.LastScrapeExposition(); err != errSamplesNotRetained {
+		t.Fatalf("Expected error %q, got %v", errSamplesNotRetained, err)
+	}
+	testTarget.RetainSamples(true)
+
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+	b, err := testTarget.LastScrapeExposition()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parser text.Parser
+	mfs, err := parser.TextToMetricFamilies(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("Error parsing exposition %q: %s", b, err)
+	}
+
+	expectedTypes := map[string]dto.MetricType{
+		"requests_total": dto.MetricType_COUNTER,
+		"temperature":    dto.MetricType_GAUGE,
+		"plain_metric":   dto.MetricType_UNTYPED,
+	}
+	if len(mfs) != len(expectedTypes) {
+		t.Fatalf("Expected %d metric families, got %d: %q", len(expectedTypes), len(mfs), b)
+	}
+	for name, typ := range expectedTypes {
+		if mf, ok := mfs[name]; !ok || mf.GetType() != typ {
+			t.Errorf("Expected metric family %s of type %v in %q", name, typ, b)
+		}
+	}
+	if got := mfs["requests_total"].GetHelp(); got != "The total number of requests." {
+		t.Errorf("Unexpected help text %q", got)
+	}
+
+	// All scraped samples survive the round trip. The synthetic samples are
+	// not part of the exposition.
+	values := map[clientmodel.Fingerprint]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			metric := clientmodel.Metric{clientmodel.MetricNameLabel: clientmodel.LabelValue(mf.GetName())}
+			for _, lp := range m.Label {
+				metric[clientmodel.LabelName(lp.GetName())] = clientmodel.LabelValue(lp.GetValue())
+			}
+			var v float64
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				v = m.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				v = m.GetGauge().GetValue()
+			default:
+				v = m.GetUntyped().GetValue()
+			}
+			values[metric.Fingerprint()] = v
+		}
+	}
+	var scraped int
+	for _, s := range appender.result {
+		if s.Metric[clientmodel.MetricNameLabel] == scrapeHealthMetricName {
+			break
+		}
+		scraped++
+		v, ok := values[s.Metric.Fingerprint()]
+		if !ok {
+			t.Errorf("Missing sample %s in exposition %q", s.Metric, b)
+			continue
+		}
+		if v != float64(s.Value) {
+			t.Errorf("Expected value %v for %s, got %v", s.Value, s.Metric, v)
+		}
+	}
+	if scraped != len(values) {
+		t.Errorf("Expected %d samples in exposition, got %d", scraped, len(values))
+	}
+}
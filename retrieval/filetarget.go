@@ -0,0 +1,70 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"net/http"
+	"os"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/config"
+)
+
+// NewFileTarget creates a target that reads its exposition payload in the
+// text format from the file at the given path instead of scraping an HTTP
+// endpoint. The payload is processed like a scraped response, including
+// relabeling and the recording of the synthetic metrics, which allows to
+// test scrape configs offline. The file is read again on every scrape.
+func NewFileTarget(path string, cfg *config.ScrapeConfig, baseLabels clientmodel.LabelSet) *Target {
+	t := newTarget(baseLabels)
+	t.filePath = path
+	// Updating file targets does not fail as they need neither a scheme nor
+	// an HTTP client.
+	t.Update(cfg, baseLabels, nil)
+	return t
+}
+
+// newFileClient returns an HTTP client answering all requests with the
+// content of the file at the given path.
+func newFileClient(path string) *http.Client {
+	return &http.Client{Transport: fileRoundTripper(path)}
+}
+
+// fileRoundTripper is an http.RoundTripper responding with the content of the
+// file at the path it holds.
+type fileRoundTripper string
+
+// RoundTrip implements http.RoundTripper.
+func (rt fileRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	f, err := os.Open(string(rt))
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", `text/plain; version=0.0.4`)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		Header:        header,
+		Body:          f,
+		ContentLength: -1,
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/config"
+)
+
+func TestFileTarget(t *testing.T) {
+	cfg := &config.ScrapeConfig{
+		ScrapeInterval: config.Duration(time.Second),
+		ScrapeTimeout:  config.Duration(time.Second),
+		MetricRelabelConfigs: []*config.RelabelConfig{
+			{
+				SourceLabels: clientmodel.LabelNames{clientmodel.MetricNameLabel},
+				Regex:        &config.Regexp{*regexp.MustCompile("debug_.*")},
+				Action:       config.RelabelDrop,
+			},
+			{
+				SourceLabels: clientmodel.LabelNames{"code"},
+				Regex:        &config.Regexp{*regexp.MustCompile("(.)..")},
+				TargetLabel:  "class",
+				Replacement:  "${1}xx",
+				Action:       config.RelabelReplace,
+			},
+		},
+	}
+	testTarget := NewFileTarget("testdata/replay.prom", cfg, clientmodel.LabelSet{
+		clientmodel.JobLabel:     "replay",
+		clientmodel.AddressLabel: "replay.prom",
+	})
+
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[clientmodel.Fingerprint]clientmodel.SampleValue{}
+	for _, s := range appender.result {
+		if s.Metric[clientmodel.MetricNameLabel] == "debug_info" {
+			t.Errorf("Expected debug_info to be dropped, got %s", s.Metric)
+		}
+		values[s.Metric.Fingerprint()] = s.Value
+	}
+	expected := []*clientmodel.Sample{
+		{
+			Metric: clientmodel.Metric{
+				clientmodel.MetricNameLabel: "http_requests_total",
+				clientmodel.JobLabel:        "replay",
+				clientmodel.InstanceLabel:   "replay.prom",
+				"method":                    "get",
+				"code":                      "200",
+				"class":                     "2xx",
+			},
+			Value: 1027,
+		},
+		{
+			Metric: clientmodel.Metric{
+				clientmodel.MetricNameLabel: "http_requests_total",
+				clientmodel.JobLabel:        "replay",
+				clientmodel.InstanceLabel:   "replay.prom",
+				"method":                    "post",
+				"code":                      "500",
+				"class":                     "5xx",
+			},
+			Value: 3,
+		},
+		{
+			Metric: clientmodel.Metric{
+				clientmodel.MetricNameLabel: scrapeHealthMetricName,
+				clientmodel.JobLabel:        "replay",
+				clientmodel.InstanceLabel:   "replay.prom",
+			},
+			Value: 1,
+		},
+	}
+	for _, s := range expected {
+		v, ok := values[s.Metric.Fingerprint()]
+		if !ok {
+			t.Errorf("Expected sample %s", s.Metric)
+			continue
+		}
+		if v != s.Value {
+			t.Errorf("Expected value %v for %s, got %v", s.Value, s.Metric, v)
+		}
+	}
+
+	// A missing file fails the scrape.
+	testTarget = NewFileTarget("testdata/missing.prom", cfg, clientmodel.LabelSet{})
+	if err := testTarget.scrape(&collectResultAppender{}); err == nil {
+		t.Fatal("Expected error for missing file")
+	}
+	if testTarget.status.Health() != HealthBad {
+		t.Errorf("Expected health %v for missing file, got %v", HealthBad, testTarget.status.Health())
+	}
+}
@@ -14,6 +14,7 @@
 package retrieval
 
 import (
+	"sync/atomic"
 	"time"
 
 	clientmodel "github.com/prometheus/client_golang/model"
@@ -33,6 +34,20 @@ func (a slowAppender) Append(*clientmodel.Sample) {
 	return
 }
 
+// countingAppender only counts the appended samples.
+type countingAppender struct {
+	count uint64
+}
+
+func (a *countingAppender) Append(*clientmodel.Sample) {
+	atomic.AddUint64(&a.count, 1)
+}
+
+// Count returns the number of appended samples.
+func (a *countingAppender) Count() uint64 {
+	return atomic.LoadUint64(&a.count)
+}
+
 type collectResultAppender struct {
 	result clientmodel.Samples
 }
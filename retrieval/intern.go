@@ -0,0 +1,88 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"hash/maphash"
+	"sync"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+// maxInternedStrings is the number of interned strings beyond which the pool
+// of the interner is cleared. It bounds the memory held by label values that
+// are not exposed anymore.
+const maxInternedStrings = 1 << 20
+
+// labelInterner is shared by all targets interning their labels.
+var labelInterner = newStringInterner(maxInternedStrings)
+
+// internShards is the number of shards of an interner. Strings are assigned
+// to shards by their hash, so that concurrent scrapes rarely wait for the
+// same lock.
+const internShards = 64
+
+// stringInterner maps strings to a canonical copy, so that equal label names
+// and values of scraped samples share their backing storage rather than each
+// sample holding its own copy. It is safe for concurrent use.
+type stringInterner struct {
+	seed   maphash.Seed
+	shards [internShards]internShard
+}
+
+// internShard holds the canonical copies of the strings of one shard.
+type internShard struct {
+	mtx  sync.Mutex
+	pool map[string]string
+	max  int
+}
+
+// newStringInterner returns an interner whose shards are cleared once they
+// hold more than their part of max strings.
+func newStringInterner(max int) *stringInterner {
+	i := &stringInterner{seed: maphash.MakeSeed()}
+	shardMax := (max + internShards - 1) / internShards
+	for j := range i.shards {
+		i.shards[j] = internShard{
+			pool: map[string]string{},
+			max:  shardMax,
+		}
+	}
+	return i
+}
+
+// internMetric replaces the label names and values of the metric with their
+// canonical copies.
+func (i *stringInterner) internMetric(m clientmodel.Metric) {
+	for ln, lv := range m {
+		// Assigning to an existing key replaces the stored key as well.
+		m[clientmodel.LabelName(i.intern(string(ln)))] = clientmodel.LabelValue(i.intern(string(lv)))
+	}
+}
+
+// intern returns the canonical copy of the string.
+func (i *stringInterner) intern(s string) string {
+	sh := &i.shards[maphash.String(i.seed, s)%internShards]
+	sh.mtx.Lock()
+	defer sh.mtx.Unlock()
+
+	if c, ok := sh.pool[s]; ok {
+		return c
+	}
+	if len(sh.pool) >= sh.max {
+		sh.pool = map[string]string{}
+	}
+	sh.pool[s] = s
+	return s
+}
@@ -0,0 +1,126 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"fmt"
+	"hash/maphash"
+	"strings"
+	"testing"
+	"unsafe"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+func TestStringInternerSharesStrings(t *testing.T) {
+	interner := newStringInterner(maxInternedStrings)
+	// Build equal strings with distinct backing storage.
+	newMetric := func() clientmodel.Metric {
+		return clientmodel.Metric{
+			clientmodel.LabelName(strings.Repeat("a", 3)): clientmodel.LabelValue(strings.Repeat("b", 3)),
+		}
+	}
+	m1, m2 := newMetric(), newMetric()
+	interner.internMetric(m1)
+	interner.internMetric(m2)
+
+	for ln1, lv1 := range m1 {
+		for ln2, lv2 := range m2 {
+			if unsafe.StringData(string(ln1)) != unsafe.StringData(string(ln2)) {
+				t.Errorf("Expected label name %q to share its storage", ln1)
+			}
+			if unsafe.StringData(string(lv1)) != unsafe.StringData(string(lv2)) {
+				t.Errorf("Expected label value %q to share its storage", lv1)
+			}
+		}
+	}
+}
+
+func TestStringInternerReusesStrings(t *testing.T) {
+	// The pool is large enough to never be cleared during the test.
+	interner := newStringInterner(maxInternedStrings)
+	// Build equal metrics with distinct backing storage.
+	newMetric := func() clientmodel.Metric {
+		return clientmodel.Metric{
+			clientmodel.LabelName(strings.Repeat("_", 2) + "name__"): clientmodel.LabelValue(strings.Repeat("test_", 1) + "metric"),
+			clientmodel.LabelName(strings.Repeat("o", 2) + "f"):      clientmodel.LabelValue(strings.Repeat("a", 2) + "b"),
+		}
+	}
+	interned := newMetric()
+	interner.internMetric(interned)
+	storage := map[*byte]bool{}
+	for ln, lv := range interned {
+		storage[unsafe.StringData(string(ln))] = true
+		storage[unsafe.StringData(string(lv))] = true
+	}
+
+	// Interning equal metrics again returns the strings interned first.
+	for i := 0; i < 100; i++ {
+		m := newMetric()
+		interner.internMetric(m)
+		for ln, lv := range m {
+			if !storage[unsafe.StringData(string(ln))] {
+				t.Fatalf("Expected label name %q to be the interned one", ln)
+			}
+			if !storage[unsafe.StringData(string(lv))] {
+				t.Fatalf("Expected label value %q to be the interned one", lv)
+			}
+		}
+	}
+}
+
+func TestStringInternerClearsPool(t *testing.T) {
+	interner := newStringInterner(2 * internShards)
+	// Find three strings of the same shard.
+	var (
+		strs  []string
+		shard *internShard
+	)
+	for i := 0; len(strs) < 3; i++ {
+		s := fmt.Sprint(i)
+		sh := &interner.shards[maphash.String(interner.seed, s)%internShards]
+		if shard == nil {
+			shard = sh
+		}
+		if sh == shard {
+			strs = append(strs, s)
+		}
+	}
+	for _, s := range strs {
+		interner.intern(s)
+	}
+	if len(shard.pool) != 1 {
+		t.Errorf("Expected the shard to be cleared when full, got %d strings", len(shard.pool))
+	}
+}
+
+func BenchmarkStringInternerParallel(b *testing.B) {
+	interner := newStringInterner(maxInternedStrings)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		// Each scraper interns the labels of its own metrics.
+		metrics := make([]clientmodel.Metric, 100)
+		for i := range metrics {
+			metrics[i] = clientmodel.Metric{
+				clientmodel.MetricNameLabel: clientmodel.LabelValue(fmt.Sprintf("test_metric_%d", i)),
+				clientmodel.JobLabel:        "test_job",
+				"foo":                       "bar",
+			}
+		}
+		for i := 0; pb.Next(); i++ {
+			interner.internMetric(metrics[i%len(metrics)])
+		}
+	})
+}
@@ -0,0 +1,65 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"sort"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+// normalizeLabelName returns the label name with all characters not allowed
+// in label names replaced by underscores. A leading digit is replaced as
+// well.
+func normalizeLabelName(ln clientmodel.LabelName) clientmodel.LabelName {
+	b := []byte(ln)
+	for i, c := range b {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9' && i > 0) {
+			continue
+		}
+		b[i] = '_'
+	}
+	return clientmodel.LabelName(b)
+}
+
+// normalizeLabelNames replaces the invalid label names of the metric by their
+// normalized form. If a normalized name is already taken, the label is
+// dropped. Labels with valid names take precedence, otherwise the label with
+// the name sorting first is kept. It returns the number of dropped labels.
+// Multi-byte characters are replaced by one underscore per byte.
+func normalizeLabelNames(m clientmodel.Metric) int {
+	var invalid clientmodel.LabelNames
+	for ln := range m {
+		if !clientmodel.LabelNameRE.MatchString(string(ln)) {
+			invalid = append(invalid, ln)
+		}
+	}
+	if len(invalid) == 0 {
+		return 0
+	}
+	sort.Sort(invalid)
+
+	collisions := 0
+	for _, ln := range invalid {
+		lv := m[ln]
+		delete(m, ln)
+		normalized := normalizeLabelName(ln)
+		if _, ok := m[normalized]; ok {
+			collisions++
+			continue
+		}
+		m[normalized] = lv
+	}
+	return collisions
+}
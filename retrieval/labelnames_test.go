@@ -0,0 +1,122 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/matttproud/golang_protobuf_extensions/pbutil"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestNormalizeLabelNames(t *testing.T) {
+	scenarios := []struct {
+		in         clientmodel.Metric
+		out        clientmodel.Metric
+		collisions int
+	}{
+		{
+			in:  clientmodel.Metric{"__name__": "m", "valid_name": "a"},
+			out: clientmodel.Metric{"__name__": "m", "valid_name": "a"},
+		},
+		{
+			in:  clientmodel.Metric{"__name__": "m", "http.method": "GET", "0zone": "eu", "ü": "x"},
+			out: clientmodel.Metric{"__name__": "m", "http_method": "GET", "_zone": "eu", "__": "x"},
+		},
+		{
+			// The valid name takes precedence.
+			in:         clientmodel.Metric{"__name__": "m", "http-method": "GET", "http_method": "POST"},
+			out:        clientmodel.Metric{"__name__": "m", "http_method": "POST"},
+			collisions: 1,
+		},
+		{
+			// Otherwise the first name in sort order is kept.
+			in:         clientmodel.Metric{"__name__": "m", "a.b": "1", "a-b": "2", "a/b": "3"},
+			out:        clientmodel.Metric{"__name__": "m", "a_b": "2"},
+			collisions: 2,
+		},
+	}
+	for i, s := range scenarios {
+		if n := normalizeLabelNames(s.in); n != s.collisions {
+			t.Errorf("%d. Expected %d collisions, got %d", i, s.collisions, n)
+		}
+		if !reflect.DeepEqual(s.in, s.out) {
+			t.Errorf("%d. Expected %s, got %s", i, s.out, s.in)
+		}
+	}
+}
+
+func TestTargetScrapeNormalizeLabelNames(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited`)
+				mf := &dto.MetricFamily{
+					Name: proto.String("test_metric"),
+					Type: dto.MetricType_UNTYPED.Enum(),
+					Metric: []*dto.Metric{
+						{
+							Label: []*dto.LabelPair{
+								{Name: proto.String("http.method"), Value: proto.String("GET")},
+							},
+							Untyped: &dto.Untyped{Value: proto.Float64(1)},
+						},
+						{
+							Label: []*dto.LabelPair{
+								{Name: proto.String("http.method"), Value: proto.String("GET")},
+								{Name: proto.String("http_method"), Value: proto.String("POST")},
+							},
+							Untyped: &dto.Untyped{Value: proto.Float64(2)},
+						},
+					},
+				}
+				if _, err := pbutil.WriteDelimited(w, mf); err != nil {
+					t.Error(err)
+				}
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.normalizeLabelNames = true
+	app := &collectResultAppender{}
+	if err := testTarget.scrape(app); err != nil {
+		t.Fatal(err)
+	}
+
+	methods := map[clientmodel.SampleValue]clientmodel.LabelValue{}
+	for _, s := range app.result {
+		if s.Metric[clientmodel.MetricNameLabel] != "test_metric" {
+			continue
+		}
+		if _, ok := s.Metric["http.method"]; ok {
+			t.Errorf("Expected label name of %s to be normalized", s.Metric)
+		}
+		methods[s.Value] = s.Metric["http_method"]
+	}
+	if expected := map[clientmodel.SampleValue]clientmodel.LabelValue{1: "GET", 2: "POST"}; !reflect.DeepEqual(methods, expected) {
+		t.Errorf("Expected http_method labels %v, got %v", expected, methods)
+	}
+	if n := testTarget.status.LabelNameCollisions(); n != 1 {
+		t.Errorf("Expected 1 label name collision, got %d", n)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MetricMetadata is the metadata a target exposes about a metric.
+type MetricMetadata struct {
+	Type string
+	Help string
+	Unit string
+}
+
+var helpUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+
+// metadataReader wraps the reader of a response in the text exposition format
+// and collects the metadata of the TYPE, HELP and UNIT comments read through
+// it. Only comment lines are buffered.
+type metadataReader struct {
+	r        io.Reader
+	metadata map[string]MetricMetadata
+
+	// The current line read so far if it is a comment line.
+	line []byte
+	// Whether the current line is known not to be a comment line.
+	skip bool
+}
+
+func (m *metadataReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	b := p[:n]
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		chunk := b
+		if i >= 0 {
+			chunk = b[:i]
+		}
+		if !m.skip {
+			if len(m.line) == 0 && len(chunk) > 0 && chunk[0] != '#' {
+				m.skip = true
+			} else {
+				m.line = append(m.line, chunk...)
+			}
+		}
+		if i < 0 {
+			break
+		}
+		m.endLine()
+		b = b[i+1:]
+	}
+	if err == io.EOF {
+		m.endLine()
+	}
+	return n, err
+}
+
+// endLine records the metadata of the current line and resets it.
+func (m *metadataReader) endLine() {
+	if !m.skip && len(m.line) > 0 {
+		m.parseComment(string(m.line))
+	}
+	m.line = m.line[:0]
+	m.skip = false
+}
+
+// parseComment records the metadata of a comment line. Other comments are
+// ignored.
+func (m *metadataReader) parseComment(line string) {
+	fields := strings.Fields(line[1:])
+	if len(fields) < 2 {
+		return
+	}
+	keyword, name := fields[0], fields[1]
+	// The text following the metric name keeps its inner whitespace.
+	text := strings.TrimSpace(line[1:])
+	text = strings.TrimSpace(text[len(keyword):])
+	text = strings.TrimSpace(text[len(name):])
+
+	md := m.metadata[name]
+	switch keyword {
+	case "TYPE":
+		md.Type = strings.ToLower(text)
+	case "HELP":
+		md.Help = helpUnescaper.Replace(text)
+	case "UNIT":
+		md.Unit = text
+	default:
+		return
+	}
+	m.metadata[name] = md
+}
+
+// metricFamilySuffixes are the suffixes of the samples of a metric family
+// whose name differs from the family name, e.g. of histograms.
+var metricFamilySuffixes = []string{"_total", "_created", "_bucket", "_count", "_sum", "_gcount", "_gsum", "_info"}
+
+// typeCheckingReader wraps the reader of a response in the text exposition
+// format and checks that each sample line belongs to a metric family declared
+// by a preceding TYPE comment. Sample lines failing the check are dropped and
+// counted if drop is set. Otherwise they are passed through and the first one
+// is reported as missingErr. Read errors do not surface reliably through the
+// text parser, which treats any error at the start of a line as the end of
+// the input.
+type typeCheckingReader struct {
+	r          *bufio.Reader
+	drop       bool
+	typed      map[string]struct{}
+	dropped    int
+	missingErr error
+
+	// The rest of the current line not read yet.
+	line []byte
+	err  error
+}
+
+func newTypeCheckingReader(r io.Reader, drop bool) *typeCheckingReader {
+	return &typeCheckingReader{
+		r:     bufio.NewReader(r),
+		drop:  drop,
+		typed: map[string]struct{}{},
+	}
+}
+
+func (c *typeCheckingReader) Read(p []byte) (int, error) {
+	for len(c.line) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		line, err := c.r.ReadBytes('\n')
+		if err != nil {
+			c.err = err
+		}
+		name, ok := c.check(line)
+		switch {
+		case ok:
+			c.line = line
+		case c.drop:
+			c.dropped++
+		default:
+			if c.missingErr == nil {
+				c.missingErr = fmt.Errorf("metric %s has no TYPE metadata", name)
+			}
+			c.line = line
+		}
+	}
+	n := copy(p, c.line)
+	c.line = c.line[n:]
+	return n, nil
+}
+
+// check records the family of a TYPE comment line and reports whether the
+// line is a comment or a sample of a declared family. It returns the metric
+// name of sample lines.
+func (c *typeCheckingReader) check(line []byte) (string, bool) {
+	s := strings.TrimLeft(string(line), " \t")
+	if strings.TrimSpace(s) == "" {
+		return "", true
+	}
+	if s[0] == '#' {
+		if fields := strings.Fields(s[1:]); len(fields) >= 2 && fields[0] == "TYPE" {
+			c.typed[fields[1]] = struct{}{}
+		}
+		return "", true
+	}
+	name := s
+	if i := strings.IndexAny(s, "{ \t\n"); i >= 0 {
+		name = s[:i]
+	}
+	if _, ok := c.typed[name]; ok {
+		return name, true
+	}
+	for _, suffix := range metricFamilySuffixes {
+		if _, ok := c.typed[strings.TrimSuffix(name, suffix)]; ok && strings.HasSuffix(name, suffix) {
+			return name, true
+		}
+	}
+	return name, false
+}
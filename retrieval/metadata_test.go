@@ -0,0 +1,186 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/config"
+)
+
+func TestMetadataReader(t *testing.T) {
+	const payload = `# HELP http_requests_total The total number of   HTTP requests.
+# TYPE http_requests_total COUNTER
+http_requests_total{code="200"} 1027
+# A comment that is not metadata.
+# HELP escaped A help text with a \\ backslash and a\nnew line.
+escaped 1
+  # TYPE indented gauge
+# UNIT request_duration seconds
+# TYPE request_duration histogram
+request_duration_bucket{le="+Inf"} 3
+# HELP unterminated The last line ends without a new line.`
+
+	expected := map[string]MetricMetadata{
+		"http_requests_total": {Type: "counter", Help: "The total number of   HTTP requests."},
+		"escaped":             {Help: "A help text with a \\ backslash and a\nnew line."},
+		"request_duration":    {Type: "histogram", Unit: "seconds"},
+		"unterminated":        {Help: "The last line ends without a new line."},
+	}
+
+	// Reading byte by byte splits all lines across reads.
+	for _, r := range []func(string) *metadataReader{
+		func(s string) *metadataReader {
+			return &metadataReader{r: strings.NewReader(s), metadata: map[string]MetricMetadata{}}
+		},
+		func(s string) *metadataReader {
+			return &metadataReader{r: iotest.OneByteReader(strings.NewReader(s)), metadata: map[string]MetricMetadata{}}
+		},
+	} {
+		mr := r(payload)
+		b, err := ioutil.ReadAll(mr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != payload {
+			t.Fatalf("Expected the payload to be passed through unchanged, got %q", b)
+		}
+		if !reflect.DeepEqual(mr.metadata, expected) {
+			t.Errorf("Expected metadata %v, got %v", expected, mr.metadata)
+		}
+	}
+}
+
+func TestTypeCheckingReader(t *testing.T) {
+	const payload = `# TYPE http_requests_total counter
+http_requests_total{code="200"} 1027
+# TYPE request_duration histogram
+request_duration_bucket{le="+Inf"} 3
+request_duration_sum 1.5
+request_duration_count 3
+untyped_metric{code="200"} 1
+  # TYPE indented gauge
+  indented 1
+untyped_last 1`
+
+	c := newTypeCheckingReader(iotest.OneByteReader(strings.NewReader(payload)), true)
+	b, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# TYPE http_requests_total counter
+http_requests_total{code="200"} 1027
+# TYPE request_duration histogram
+request_duration_bucket{le="+Inf"} 3
+request_duration_sum 1.5
+request_duration_count 3
+  # TYPE indented gauge
+  indented 1
+`
+	if string(b) != expected {
+		t.Errorf("Expected output %q, got %q", expected, b)
+	}
+	if c.dropped != 2 {
+		t.Errorf("Expected 2 dropped samples, got %d", c.dropped)
+	}
+
+	c = newTypeCheckingReader(strings.NewReader(payload), false)
+	b, err = ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != payload {
+		t.Errorf("Expected output %q, got %q", payload, b)
+	}
+	if c.missingErr == nil || !strings.Contains(c.missingErr.Error(), "untyped_metric") {
+		t.Errorf("Expected an error for the untyped metric, got %v", c.missingErr)
+	}
+}
+
+func TestTargetScrapeRequireMetadata(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("# TYPE typed_metric gauge\ntyped_metric 1\nuntyped_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	scenarios := []struct {
+		action   config.MissingMetadataAction
+		fails    bool
+		expected []clientmodel.LabelValue
+		dropped  uint64
+	}{
+		{
+			action:   "",
+			expected: []clientmodel.LabelValue{"typed_metric", "untyped_metric"},
+		}, {
+			action: config.MissingMetadataError,
+			fails:  true,
+		}, {
+			action:   config.MissingMetadataDrop,
+			expected: []clientmodel.LabelValue{"typed_metric"},
+			dropped:  1,
+		},
+	}
+	for i, s := range scenarios {
+		testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+		testTarget.requireMetadata = s.action
+		app := &collectResultAppender{}
+		err := testTarget.scrape(app)
+		if s.fails {
+			if err == nil {
+				t.Errorf("%d. Expected the scrape to fail", i)
+			}
+			for _, sample := range app.result {
+				if name := sample.Metric[clientmodel.MetricNameLabel]; name == "typed_metric" {
+					t.Errorf("%d. Expected no scraped samples appended, got %s", i, sample.Metric)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d. Unexpected error: %s", i, err)
+		}
+
+		got := map[clientmodel.LabelValue]bool{}
+		for _, sample := range app.result {
+			got[sample.Metric[clientmodel.MetricNameLabel]] = true
+		}
+		for _, name := range []clientmodel.LabelValue{"typed_metric", "untyped_metric"} {
+			want := false
+			for _, e := range s.expected {
+				want = want || e == name
+			}
+			if got[name] != want {
+				t.Errorf("%d. Expected sample of %s appended: %t, got %t", i, name, want, got[name])
+			}
+		}
+		if n := testTarget.status.MissingMetadataSamples(); n != s.dropped {
+			t.Errorf("%d. Expected %d dropped samples, got %d", i, s.dropped, n)
+		}
+	}
+}
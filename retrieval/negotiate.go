@@ -0,0 +1,95 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"sync"
+
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/util/httputil"
+)
+
+// negotiateSources holds the Kerberos token sources shared by the targets of
+// a scrape config, so that the user principal logs in once per job rather
+// than once per target.
+type negotiateSources struct {
+	mtx     sync.Mutex
+	sources map[*config.ScrapeConfig]sharedNegotiateSource
+}
+
+type sharedNegotiateSource struct {
+	keytabFile, krb5ConfigFile string
+	username, realm            string
+	source                     *httputil.KerberosTokenSource
+}
+
+var jobNegotiateSources = &negotiateSources{
+	sources: map[*config.ScrapeConfig]sharedNegotiateSource{},
+}
+
+// get returns the Kerberos token source of the scrape config, which must
+// configure Negotiate authentication. A new one is created if there is none
+// yet or the credentials of the config changed.
+func (ns *negotiateSources) get(cfg *config.ScrapeConfig) *httputil.KerberosTokenSource {
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+	a := cfg.NegotiateAuth
+	s, ok := ns.sources[cfg]
+	if ok && s.keytabFile == a.KeytabFile && s.krb5ConfigFile == a.Krb5ConfigFile && s.username == a.Username && s.realm == a.Realm {
+		return s.source
+	}
+	if ok {
+		s.source.Close()
+	}
+	s = sharedNegotiateSource{
+		keytabFile:     a.KeytabFile,
+		krb5ConfigFile: a.Krb5ConfigFile,
+		username:       a.Username,
+		realm:          a.Realm,
+		source:         httputil.NewKerberosTokenSource(a.KeytabFile, a.Krb5ConfigFile, a.Username, a.Realm),
+	}
+	ns.sources[cfg] = s
+	return s.source
+}
+
+// retain closes and removes the token sources of all scrape configs but the
+// given ones.
+func (ns *negotiateSources) retain(cfgs []*config.ScrapeConfig) {
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+	keep := make(map[*config.ScrapeConfig]bool, len(cfgs))
+	for _, cfg := range cfgs {
+		keep[cfg] = true
+	}
+	for cfg, s := range ns.sources {
+		if !keep[cfg] {
+			s.source.Close()
+			delete(ns.sources, cfg)
+		}
+	}
+}
+
+// negotiateAuthProvider returns the provider authenticating the scrapes of a
+// target with the given host with the Negotiate scheme, or nil if the scrape
+// config does not configure it.
+func negotiateAuthProvider(cfg *config.ScrapeConfig, host string) httputil.AuthProvider {
+	if cfg.NegotiateAuth == nil {
+		return nil
+	}
+	spn := cfg.NegotiateAuth.SPN
+	if spn == "" {
+		spn = "HTTP/" + host
+	}
+	return httputil.NewNegotiateAuthProvider(jobNegotiateSources.get(cfg), spn)
+}
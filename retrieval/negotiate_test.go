@@ -0,0 +1,337 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/util/testutil"
+)
+
+const testRealm = "EXAMPLE.ORG"
+
+// testKDC is a Kerberos KDC issuing tickets to any client that can decrypt
+// them. It does not check pre-authentication or authenticators.
+type testKDC struct {
+	listener net.Listener
+	// The keys of all principals.
+	keytab *keytab.Keytab
+	user   types.PrincipalName
+
+	mtx sync.Mutex
+	// The session key of the last ticket granting ticket.
+	tgtKey types.EncryptionKey
+}
+
+func newTestKDC(t *testing.T, principals map[string]string) *testKDC {
+	kt := keytab.New()
+	for name, password := range principals {
+		if err := kt.AddEntry(name, testRealm, password, time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdc := &testKDC{listener: l, keytab: kt}
+	go kdc.serve()
+	return kdc
+}
+
+func (kdc *testKDC) serve() {
+	for {
+		conn, err := kdc.listener.Accept()
+		if err != nil {
+			return
+		}
+		go kdc.handle(conn)
+	}
+}
+
+// handle answers a request sent over TCP, which is prefixed by its length.
+func (kdc *testKDC) handle(conn net.Conn) {
+	defer conn.Close()
+	var size uint32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return
+	}
+	req := make([]byte, size)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	resp, err := kdc.reply(req)
+	if err != nil {
+		panic(err)
+	}
+	binary.Write(conn, binary.BigEndian, uint32(len(resp)))
+	conn.Write(resp)
+}
+
+func (kdc *testKDC) reply(req []byte) ([]byte, error) {
+	var (
+		now   = time.Now().UTC().Truncate(time.Second)
+		end   = now.Add(time.Hour)
+		flags = asn1.BitString{Bytes: make([]byte, 4), BitLength: 32}
+	)
+	var asReq messages.ASReq
+	if err := asReq.Unmarshal(req); err == nil {
+		body := asReq.ReqBody
+		tkt, key, err := messages.NewTicket(body.CName, testRealm, body.SName, testRealm, flags, kdc.keytab, etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, end, end)
+		if err != nil {
+			return nil, err
+		}
+		kdc.mtx.Lock()
+		kdc.tgtKey = key
+		kdc.mtx.Unlock()
+		clientKey, _, err := kdc.keytab.GetEncryptionKey(body.CName, testRealm, 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+		if err != nil {
+			return nil, err
+		}
+		encPart, err := kdc.encPart(key, body.Nonce, body.SName, flags, now, end, clientKey, keyusage.AS_REP_ENCPART)
+		if err != nil {
+			return nil, err
+		}
+		rep := messages.ASRep{KDCRepFields: messages.KDCRepFields{
+			PVNO:    5,
+			MsgType: msgtype.KRB_AS_REP,
+			CRealm:  testRealm,
+			CName:   body.CName,
+			Ticket:  tkt,
+			EncPart: encPart,
+		}}
+		return rep.Marshal()
+	}
+
+	var tgsReq messages.TGSReq
+	if err := tgsReq.Unmarshal(req); err != nil {
+		return nil, err
+	}
+	body := tgsReq.ReqBody
+	tkt, key, err := messages.NewTicket(kdc.user, testRealm, body.SName, testRealm, flags, kdc.keytab, etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, end, end)
+	if err != nil {
+		return nil, err
+	}
+	kdc.mtx.Lock()
+	tgtKey := kdc.tgtKey
+	kdc.mtx.Unlock()
+	encPart, err := kdc.encPart(key, body.Nonce, body.SName, flags, now, end, tgtKey, keyusage.TGS_REP_ENCPART_SESSION_KEY)
+	if err != nil {
+		return nil, err
+	}
+	rep := messages.TGSRep{KDCRepFields: messages.KDCRepFields{
+		PVNO:    5,
+		MsgType: msgtype.KRB_TGS_REP,
+		CRealm:  testRealm,
+		CName:   body.CName,
+		Ticket:  tkt,
+		EncPart: encPart,
+	}}
+	return rep.Marshal()
+}
+
+// encPart returns the encrypted part of a reply handing out the session key.
+func (kdc *testKDC) encPart(key types.EncryptionKey, nonce int, sname types.PrincipalName, flags asn1.BitString, start, end time.Time, replyKey types.EncryptionKey, usage uint32) (types.EncryptedData, error) {
+	part := messages.EncKDCRepPart{
+		Key:       key,
+		LastReqs:  []messages.LastReq{},
+		Nonce:     nonce,
+		Flags:     flags,
+		AuthTime:  start,
+		StartTime: start,
+		EndTime:   end,
+		RenewTill: end,
+		SRealm:    testRealm,
+		SName:     sname,
+	}
+	b, err := part.Marshal()
+	if err != nil {
+		return types.EncryptedData{}, err
+	}
+	return crypto.GetEncryptedData(b, replyKey, usage, 1)
+}
+
+// writeKeytab writes a keytab with the key of the principal to the file.
+func writeKeytab(t *testing.T, file, principal, password string) {
+	kt := keytab.New()
+	if err := kt.AddEntry(principal, testRealm, password, time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatal(err)
+	}
+	b, err := kt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTargetScrapeNegotiateAuthKerberos(t *testing.T) {
+	const spn = "HTTP/127.0.0.1"
+	kdc := newTestKDC(t, map[string]string{
+		"prometheus":          "user-password",
+		"krbtgt/" + testRealm: "kdc-password",
+		spn:                   "service-password",
+	})
+	defer kdc.listener.Close()
+	kdc.user = types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "prometheus")
+
+	dir := testutil.NewTemporaryDirectory("test_negotiate_auth", t)
+	defer dir.Close()
+	keytabFile := filepath.Join(dir.Path(), "prometheus.keytab")
+	writeKeytab(t, keytabFile, "prometheus", "user-password")
+	krb5ConfigFile := filepath.Join(dir.Path(), "krb5.conf")
+	krb5Config := fmt.Sprintf(`[libdefaults]
+  default_realm = %s
+  udp_preference_limit = 1
+  default_tkt_enctypes = aes256-cts-hmac-sha1-96
+  default_tgs_enctypes = aes256-cts-hmac-sha1-96
+  permitted_enctypes = aes256-cts-hmac-sha1-96
+
+[realms]
+  %s = {
+    kdc = %s
+  }
+`, testRealm, testRealm, kdc.listener.Addr())
+	if err := ioutil.WriteFile(krb5ConfigFile, []byte(krb5Config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The exporter only accepts requests with a valid service ticket.
+	serviceKeytab := keytab.New()
+	if err := serviceKeytab.AddEntry(spn, testRealm, "service-password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mtx      sync.Mutex
+		requests int
+	)
+	server := httptest.NewServer(spnego.SPNEGOKRB5Authenticate(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+		serviceKeytab,
+	))
+	defer server.Close()
+	countRequests := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mtx.Lock()
+			requests++
+			mtx.Unlock()
+			h.ServeHTTP(w, r)
+		})
+	}
+	server.Config.Handler = countRequests(server.Config.Handler)
+
+	cfg := &config.ScrapeConfig{
+		JobName:        "test_job",
+		ScrapeInterval: config.Duration(time.Second),
+		ScrapeTimeout:  config.Duration(5 * time.Second),
+		MetricsPath:    "/metrics",
+		NegotiateAuth: &config.NegotiateAuth{
+			KeytabFile:     keytabFile,
+			Krb5ConfigFile: krb5ConfigFile,
+			Username:       "prometheus",
+			Realm:          testRealm,
+		},
+	}
+	defer jobNegotiateSources.retain(nil)
+	testTarget, err := NewTarget(cfg, clientmodel.LabelSet{
+		clientmodel.SchemeLabel:  "http",
+		clientmodel.AddressLabel: clientmodel.LabelValue(strings.TrimPrefix(server.URL, "http://")),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The service ticket is obtained once and reused by later scrapes.
+	for i := 0; i < 2; i++ {
+		appender := &collectResultAppender{}
+		if err := testTarget.scrape(appender); err != nil {
+			t.Fatal(err)
+		}
+		if len(appender.result) == 0 || appender.result[0].Metric[clientmodel.MetricNameLabel] != "test_metric" {
+			t.Fatalf("Expected scraped sample, got %v", appender.result)
+		}
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if requests != 2 {
+		t.Errorf("Expected every scrape to be authenticated by its first request, got %d requests", requests)
+	}
+}
+
+func TestTargetScrapeNegotiateAuthMissingKeytab(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	cfg := &config.ScrapeConfig{
+		JobName:        "test_job",
+		ScrapeInterval: config.Duration(time.Second),
+		ScrapeTimeout:  config.Duration(time.Second),
+		MetricsPath:    "/metrics",
+		NegotiateAuth: &config.NegotiateAuth{
+			KeytabFile:     "testdata/missing.keytab",
+			Krb5ConfigFile: "testdata/missing.conf",
+			Username:       "prometheus",
+			Realm:          testRealm,
+		},
+	}
+	defer jobNegotiateSources.retain(nil)
+	testTarget, err := NewTarget(cfg, clientmodel.LabelSet{
+		clientmodel.SchemeLabel:  "http",
+		clientmodel.AddressLabel: clientmodel.LabelValue(strings.TrimPrefix(server.URL, "http://")),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testTarget.scrape(nopAppender{}); err == nil || !strings.Contains(err.Error(), "error reading keytab file testdata/missing.keytab") {
+		t.Errorf("Expected the scrape to fail reading the keytab, got %v", err)
+	}
+}
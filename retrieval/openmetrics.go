@@ -0,0 +1,203 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// openMetricsMediaType is the media type of the OpenMetrics text format.
+const openMetricsMediaType = "application/openmetrics-text"
+
+// maxOpenMetricsLineSize is the maximum length of a line of an OpenMetrics
+// payload.
+const maxOpenMetricsLineSize = 1 << 20
+
+// openMetricsTypes maps the OpenMetrics metric types to the types of the
+// classic text format. Types missing have no equivalent and their metrics
+// are converted without a type.
+var openMetricsTypes = map[string]string{
+	"counter":   "counter",
+	"gauge":     "gauge",
+	"histogram": "histogram",
+	"summary":   "summary",
+	"unknown":   "untyped",
+}
+
+// openMetricsCreatedTypes are the types of which metric families may expose a
+// _created sample holding their creation time.
+var openMetricsCreatedTypes = map[string]bool{
+	"counter":        true,
+	"histogram":      true,
+	"gaugehistogram": true,
+	"summary":        true,
+}
+
+// convertOpenMetrics reads a payload in the OpenMetrics text format and
+// returns it converted to the classic text format. Counter families are
+// renamed to the name of their _total samples, _created samples and
+// exemplars are dropped, and timestamps are converted from seconds to
+// milliseconds. Everything after the # EOF line is ignored.
+func convertOpenMetrics(r io.Reader) (io.Reader, error) {
+	var (
+		out     bytes.Buffer
+		scanner = bufio.NewScanner(r)
+		// The types of all families seen so far and the name of the current
+		// family.
+		types  = map[string]string{}
+		family string
+		// A HELP line waiting for the type of its family to be known.
+		pendingHelp, pendingHelpText string
+	)
+	scanner.Buffer(make([]byte, 0, 4096), maxOpenMetricsLineSize)
+
+	familyName := func(name string) string {
+		if types[name] == "counter" {
+			return name + "_total"
+		}
+		return name
+	}
+	flushHelp := func() {
+		if pendingHelp != "" {
+			fmt.Fprintf(&out, "# HELP %s %s\n", familyName(pendingHelp), pendingHelpText)
+			pendingHelp = ""
+		}
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			parts := strings.SplitN(line, " ", 4)
+			if len(parts) == 2 && parts[1] == "EOF" {
+				break
+			}
+			if len(parts) < 3 {
+				continue
+			}
+			name := parts[2]
+			var text string
+			if len(parts) == 4 {
+				text = parts[3]
+			}
+			switch parts[1] {
+			case "TYPE":
+				if pendingHelp != name {
+					flushHelp()
+				}
+				types[name] = text
+				family = name
+				if typ, ok := openMetricsTypes[text]; ok {
+					fmt.Fprintf(&out, "# TYPE %s %s\n", familyName(name), typ)
+				}
+				flushHelp()
+			case "HELP":
+				flushHelp()
+				family = name
+				// HELP may precede TYPE.
+				pendingHelp, pendingHelpText = name, text
+				if _, ok := types[name]; ok {
+					flushHelp()
+				}
+			}
+			// Other comments, e.g. UNIT lines, have no equivalent.
+			continue
+		}
+		flushHelp()
+
+		sample, err := convertOpenMetricsSample(line, family, types[family])
+		if err != nil {
+			return nil, err
+		}
+		if sample != "" {
+			out.WriteString(sample)
+			out.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flushHelp()
+	return &out, nil
+}
+
+// convertOpenMetricsSample converts a sample line of the family with the
+// given name and type. It returns an empty line for samples without an
+// equivalent.
+func convertOpenMetricsSample(line, family, typ string) (string, error) {
+	end := strings.IndexAny(line, "{ ")
+	if end < 0 {
+		return "", fmt.Errorf("invalid OpenMetrics sample line %q", line)
+	}
+	name := line[:end]
+	if line[end] == '{' {
+		if end = labelsEnd(line, end); end < 0 {
+			return "", fmt.Errorf("invalid OpenMetrics sample line %q", line)
+		}
+	}
+	if openMetricsCreatedTypes[typ] && name == family+"_created" {
+		return "", nil
+	}
+
+	rest := line[end:]
+	// Drop the exemplar.
+	if i := strings.Index(rest, " # "); i >= 0 {
+		rest = rest[:i]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return "", fmt.Errorf("invalid OpenMetrics sample line %q", line)
+	}
+	converted := line[:end] + " " + fields[0]
+	if len(fields) == 2 {
+		ts, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid timestamp in OpenMetrics sample line %q: %s", line, err)
+		}
+		converted += " " + strconv.FormatInt(int64(math.Floor(ts*1000+0.5)), 10)
+	}
+	return converted, nil
+}
+
+// labelsEnd returns the index after the labels of a sample line starting at
+// the given index, i.e. after the first closing brace outside of a quoted
+// label value. It returns -1 if the labels are not closed.
+func labelsEnd(line string, start int) int {
+	inQuotes, escaped := false, false
+	for i := start + 1; i < len(line); i++ {
+		if escaped {
+			escaped = false
+			continue
+		}
+		switch line[i] {
+		case '\\':
+			escaped = true
+		case '"':
+			inQuotes = !inQuotes
+		case '}':
+			if !inQuotes {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
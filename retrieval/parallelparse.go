@@ -0,0 +1,113 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/prometheus/client_golang/extraction"
+)
+
+// processParallel reads a payload of the text format and processes it in at
+// most the given number of chunks concurrently. Samples are ingested in no
+// particular order. The first error of any chunk is returned.
+func processParallel(r io.Reader, processor extraction.Processor, ingester extraction.Ingester, o *extraction.ProcessOptions, workers int) error {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	chunks := splitTextFamilies(buf, workers)
+	if len(chunks) == 1 {
+		return processor.ProcessSingle(bytes.NewReader(chunks[0]), ingester, o)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(chunks))
+	)
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk []byte) {
+			defer wg.Done()
+			errs[i] = processor.ProcessSingle(bytes.NewReader(chunk), ingester, o)
+		}(i, chunk)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	helpPrefix = []byte("# HELP ")
+	typePrefix = []byte("# TYPE ")
+)
+
+// splitTextFamilies splits a payload of the text format into at most n chunks
+// of roughly equal size. Chunks only end in front of a HELP or TYPE line
+// starting a new metric family, so no family is split across chunks. A TYPE
+// line directly following a HELP line belongs to the same family. Payloads
+// without such lines are not split.
+func splitTextFamilies(buf []byte, n int) [][]byte {
+	if n < 2 {
+		return [][]byte{buf}
+	}
+	var (
+		chunks [][]byte
+		size   = len(buf)/n + 1
+		start  = 0
+	)
+	for len(chunks) < n-1 {
+		end := familyStart(buf, start+size)
+		if end < 0 {
+			break
+		}
+		chunks = append(chunks, buf[start:end])
+		start = end
+	}
+	return append(chunks, buf[start:])
+}
+
+// familyStart returns the index of the first line at or after the given
+// index that starts a new metric family, or -1 if there is none.
+func familyStart(buf []byte, from int) int {
+	for from < len(buf) {
+		i := bytes.IndexByte(buf[from:], '\n')
+		if i < 0 {
+			return -1
+		}
+		lineStart := from + i + 1
+		line := buf[lineStart:]
+		if bytes.HasPrefix(line, helpPrefix) {
+			return lineStart
+		}
+		if bytes.HasPrefix(line, typePrefix) && !bytes.HasPrefix(buf[lastLineStart(buf, lineStart):], helpPrefix) {
+			return lineStart
+		}
+		from = lineStart
+	}
+	return -1
+}
+
+// lastLineStart returns the index at which the line before the line starting
+// at the given index starts.
+func lastLineStart(buf []byte, lineStart int) int {
+	return bytes.LastIndexByte(buf[:lineStart-1], '\n') + 1
+}
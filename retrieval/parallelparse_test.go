@@ -0,0 +1,121 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+// textPayload returns a payload of the text format with the given number of
+// families of histograms and gauges with the given number of series each.
+func textPayload(families, series int) string {
+	var buf bytes.Buffer
+	for f := 0; f < families; f++ {
+		if f%2 == 0 {
+			fmt.Fprintf(&buf, "# HELP histogram_%d A histogram.\n# TYPE histogram_%d histogram\n", f, f)
+			for s := 0; s < series; s++ {
+				fmt.Fprintf(&buf, "histogram_%d_bucket{i=\"%d\",le=\"1\"} %d\n", f, s, s)
+				fmt.Fprintf(&buf, "histogram_%d_bucket{i=\"%d\",le=\"+Inf\"} %d\n", f, s, 2*s)
+				fmt.Fprintf(&buf, "histogram_%d_sum{i=\"%d\"} %d\n", f, s, 3*s)
+				fmt.Fprintf(&buf, "histogram_%d_count{i=\"%d\"} %d\n", f, s, 2*s)
+			}
+			continue
+		}
+		fmt.Fprintf(&buf, "# TYPE gauge_%d gauge\n", f)
+		for s := 0; s < series; s++ {
+			fmt.Fprintf(&buf, "gauge_%d{i=\"%d\"} %d\n", f, s, s)
+		}
+	}
+	return buf.String()
+}
+
+func TestSplitTextFamilies(t *testing.T) {
+	payload := "# HELP a A.\n# TYPE a counter\na 1\n# TYPE b gauge\nb 1\nc 1\n# HELP d D.\nd 1\n"
+	for _, n := range []int{1, 2, 3, 4, 10} {
+		chunks := splitTextFamilies([]byte(payload), n)
+		if len(chunks) > n {
+			t.Errorf("%d: Expected at most %d chunks, got %d", n, n, len(chunks))
+		}
+		if joined := string(bytes.Join(chunks, nil)); joined != payload {
+			t.Errorf("%d: Expected chunks to make up the payload, got %q", n, joined)
+		}
+		for _, chunk := range chunks[1:] {
+			if !bytes.HasPrefix(chunk, helpPrefix) && !bytes.HasPrefix(chunk, typePrefix) {
+				t.Errorf("%d: Expected chunk to start with a new family, got %q", n, chunk)
+			}
+			if bytes.HasPrefix(chunk, []byte("# TYPE a ")) {
+				t.Errorf("%d: Expected TYPE line to stay with its HELP line", n)
+			}
+		}
+	}
+
+	if chunks := splitTextFamilies([]byte("a 1\nb 1\nc 1\n"), 4); len(chunks) != 1 {
+		t.Errorf("Expected payload without families not to be split, got %q", chunks)
+	}
+}
+
+func TestTargetScrapeParallelParse(t *testing.T) {
+	payload := textPayload(20, 50)
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+				w.Write([]byte(payload))
+			},
+		),
+	)
+	defer server.Close()
+
+	// scrapedSamples returns the scraped samples without their timestamps,
+	// except the synthetic ones, which differ between scrapes.
+	scrapedSamples := func(threshold int64, workers int) []string {
+		testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+		testTarget.parallelParseThreshold = threshold
+		testTarget.parallelParseWorkers = workers
+		app := &collectResultAppender{}
+		if err := testTarget.scrape(app); err != nil {
+			t.Fatal(err)
+		}
+		var samples []string
+		for _, s := range app.result {
+			if !strings.HasPrefix(string(s.Metric[clientmodel.MetricNameLabel]), "scrape_") && s.Metric[clientmodel.MetricNameLabel] != "up" {
+				samples = append(samples, fmt.Sprintf("%s %v", s.Metric, s.Value))
+			}
+		}
+		sort.Strings(samples)
+		return samples
+	}
+
+	expected := scrapedSamples(0, 0)
+	if len(expected) != 10*50*4+10*50 {
+		t.Fatalf("Expected %d samples, got %d", 10*50*4+10*50, len(expected))
+	}
+	for _, workers := range []int{2, 3, 8} {
+		if got := scrapedSamples(1, workers); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%d workers: Expected the samples of the sequential parse, got %d samples", workers, len(got))
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/storage"
+)
+
+// sampleRateLimiter is a token bucket limiting the rate at which the samples
+// of a target are appended. The bucket holds up to burst tokens and is
+// refilled at rate tokens per second. It is kept across scrapes and is not
+// safe for concurrent use.
+type sampleRateLimiter struct {
+	rate  float64
+	burst float64
+	// The tokens available at the time of the last refill.
+	tokens float64
+	last   time.Time
+	// The total number of samples dropped because they could not be
+	// appended until the scrape deadline.
+	throttled uint64
+}
+
+func newSampleRateLimiter(rate float64, burst int) *sampleRateLimiter {
+	return &sampleRateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// refill adds the tokens accumulated since the last refill.
+func (l *sampleRateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+// wait takes a token from the bucket, blocking until one is available. It
+// returns false without blocking if no token becomes available before the
+// deadline.
+func (l *sampleRateLimiter) wait(deadline time.Time) bool {
+	now := time.Now()
+	l.refill(now)
+	if l.tokens < 1 {
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		if now.Add(delay).After(deadline) {
+			l.throttled++
+			return false
+		}
+		time.Sleep(delay)
+		l.refill(time.Now())
+	}
+	l.tokens--
+	return true
+}
+
+// rateLimitedAppender is a SampleAppender that buffers the samples of a
+// scrape and appends them to the wrapped SampleAppender at the rate allowed by
+// its limiter once flushed. Pacing the appends while the response is ingested
+// would block the ingestion. Samples that cannot be appended until the
+// deadline are dropped.
+type rateLimitedAppender struct {
+	storage.SampleAppender
+
+	limiter  *sampleRateLimiter
+	deadline time.Time
+	pending  clientmodel.Samples
+}
+
+// Append implements storage.SampleAppender.
+func (app *rateLimitedAppender) Append(s *clientmodel.Sample) {
+	app.pending = append(app.pending, s)
+}
+
+// flush appends the buffered samples, blocking until they are appended or
+// dropped.
+func (app *rateLimitedAppender) flush() {
+	for _, s := range app.pending {
+		if app.limiter.wait(app.deadline) {
+			app.SampleAppender.Append(s)
+		}
+	}
+	app.pending = nil
+}
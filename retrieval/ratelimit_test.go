@@ -0,0 +1,124 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+func TestTargetScrapeSampleRateLimit(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				for i := 0; i < 50; i++ {
+					fmt.Fprintf(w, "test_metric{i=\"%d\"} 1\n", i)
+				}
+			},
+		),
+	)
+	defer server.Close()
+
+	// scrape returns the number of appended scraped samples and the value of
+	// the synthetic throttled samples counter.
+	scrape := func(testTarget *Target) (int, clientmodel.SampleValue) {
+		app := &collectResultAppender{}
+		if err := testTarget.scrape(app); err != nil {
+			t.Fatal(err)
+		}
+		var (
+			appended  int
+			throttled clientmodel.SampleValue
+		)
+		for _, s := range app.result {
+			switch s.Metric[clientmodel.MetricNameLabel] {
+			case "test_metric":
+				appended++
+			case scrapeSamplesThrottledMetricName:
+				throttled = s.Value
+			}
+		}
+		return appended, throttled
+	}
+
+	// The samples exceeding the burst are appended within the deadline at
+	// the limited rate.
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.sampleRateLimiter = newSampleRateLimiter(200, 10)
+	start := time.Now()
+	appended, throttled := scrape(testTarget)
+	if took := time.Since(start); took < 150*time.Millisecond {
+		t.Errorf("Expected appending to be throttled, scrape took %s", took)
+	}
+	if appended != 50 || throttled != 0 {
+		t.Errorf("Expected all 50 samples to be appended, got %d appended and %v throttled", appended, throttled)
+	}
+
+	// The samples that cannot be appended until the deadline are dropped.
+	testTarget = newTestTarget(server.URL, 100*time.Millisecond, clientmodel.LabelSet{})
+	testTarget.sampleRateLimiter = newSampleRateLimiter(100, 10)
+	appended, throttled = scrape(testTarget)
+	if appended == 50 || throttled == 0 {
+		t.Errorf("Expected samples to be throttled, got %d appended and %v throttled", appended, throttled)
+	}
+	if appended+int(throttled) != 50 {
+		t.Errorf("Expected appended and throttled samples to add up to 50, got %d and %v", appended, throttled)
+	}
+}
+
+func TestTargetScrapeSampleRateLimitManyFamilies(t *testing.T) {
+	// The first family takes longer to append at the limited rate than the
+	// ingestion waits for the channel, which is full with the other
+	// families by then.
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				for i := 0; i < 400; i++ {
+					fmt.Fprintf(w, "first_metric{i=\"%d\"} 1\n", i)
+				}
+				for i := 0; i < 2*ingestedSamplesCap; i++ {
+					fmt.Fprintf(w, "test_metric_%d 1\n", i)
+				}
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 2*time.Second, clientmodel.LabelSet{})
+	testTarget.sampleRateLimiter = newSampleRateLimiter(1000, 10)
+	app := &collectResultAppender{}
+	if err := testTarget.scrape(app); err != nil {
+		t.Fatal(err)
+	}
+	if testTarget.sampleRateLimiter.throttled != 0 {
+		t.Errorf("Expected no throttled samples, got %d", testTarget.sampleRateLimiter.throttled)
+	}
+	scraped := 0
+	for _, s := range app.result {
+		if name := string(s.Metric[clientmodel.MetricNameLabel]); strings.HasPrefix(name, "first_metric") || strings.HasPrefix(name, "test_metric") {
+			scraped++
+		}
+	}
+	if expected := 400 + 2*ingestedSamplesCap; scraped != expected {
+		t.Errorf("Expected %d scraped samples, got %d", expected, scraped)
+	}
+}
@@ -3,7 +3,10 @@ package retrieval
 import (
 	"crypto/md5"
 	"fmt"
+	"os"
+	"regexp"
 	"strings"
+	"sync"
 
 	clientmodel "github.com/prometheus/client_golang/model"
 
@@ -31,27 +34,38 @@ func Relabel(labels clientmodel.LabelSet, cfgs ...*config.RelabelConfig) (client
 }
 
 func relabel(labels clientmodel.LabelSet, cfg *config.RelabelConfig) (clientmodel.LabelSet, error) {
-	values := make([]string, 0, len(cfg.SourceLabels))
+	values := make([]string, 0, len(cfg.SourceLabels)+len(cfg.SourceEnv))
 	for _, ln := range cfg.SourceLabels {
 		values = append(values, string(labels[ln]))
 	}
+	for _, name := range cfg.SourceEnv {
+		values = append(values, os.Getenv(name))
+	}
 	val := strings.Join(values, cfg.Separator)
 
+	var re *regexp.Regexp
+	if cfg.Regex != nil {
+		re = &cfg.Regex.Regexp
+		if cfg.FullMatch {
+			re = anchoredRegexp(re)
+		}
+	}
+
 	switch cfg.Action {
 	case config.RelabelDrop:
-		if cfg.Regex.MatchString(val) {
+		if re.MatchString(val) {
 			return nil, nil
 		}
 	case config.RelabelKeep:
-		if !cfg.Regex.MatchString(val) {
+		if !re.MatchString(val) {
 			return nil, nil
 		}
 	case config.RelabelReplace:
 		// If there is no match no replacement must take place.
-		if !cfg.Regex.MatchString(val) {
+		if !re.MatchString(val) {
 			break
 		}
-		res := cfg.Regex.ReplaceAllString(val, cfg.Replacement)
+		res := re.ReplaceAllString(val, cfg.Replacement)
 		if res == "" {
 			delete(labels, cfg.TargetLabel)
 		} else {
@@ -66,6 +80,27 @@ func relabel(labels clientmodel.LabelSet, cfg *config.RelabelConfig) (clientmode
 	return labels, nil
 }
 
+var (
+	anchoredMtx     sync.Mutex
+	anchoredRegexps = map[string]*regexp.Regexp{}
+)
+
+// anchoredRegexp returns a regular expression that only matches if re matches
+// the entire input. The compiled expressions are cached.
+func anchoredRegexp(re *regexp.Regexp) *regexp.Regexp {
+	anchoredMtx.Lock()
+	defer anchoredMtx.Unlock()
+
+	expr := re.String()
+	if a, ok := anchoredRegexps[expr]; ok {
+		return a
+	}
+	// The expression already compiled, so the anchored one does as well.
+	a := regexp.MustCompile("^(?:" + expr + ")$")
+	anchoredRegexps[expr] = a
+	return a
+}
+
 // sum64 sums the md5 hash to an uint64.
 func sum64(hash [md5.Size]byte) uint64 {
 	var s uint64
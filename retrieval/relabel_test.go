@@ -1,6 +1,7 @@
 package retrieval
 
 import (
+	"os"
 	"reflect"
 	"regexp"
 	"testing"
@@ -173,6 +174,126 @@ func TestRelabel(t *testing.T) {
 				"d": "976",
 			},
 		},
+		{
+			// The default separator is ambiguous if label values contain it.
+			input: clientmodel.LabelSet{
+				"a": "foo;bar",
+				"b": "baz",
+			},
+			relabel: []*config.RelabelConfig{
+				{
+					SourceLabels: clientmodel.LabelNames{"a", "b"},
+					Regex:        &config.Regexp{*regexp.MustCompile("^([^;]*);(.*)$")},
+					TargetLabel:  clientmodel.LabelName("c"),
+					Separator:    ";",
+					Replacement:  "${1}",
+					Action:       config.RelabelReplace,
+				},
+			},
+			output: clientmodel.LabelSet{
+				"a": "foo;bar",
+				"b": "baz",
+				"c": "foo",
+			},
+		},
+		{
+			input: clientmodel.LabelSet{
+				"a": "foo;bar",
+				"b": "baz",
+			},
+			relabel: []*config.RelabelConfig{
+				{
+					SourceLabels: clientmodel.LabelNames{"a", "b"},
+					Regex:        &config.Regexp{*regexp.MustCompile("^([^|]*)\\|(.*)$")},
+					TargetLabel:  clientmodel.LabelName("c"),
+					Separator:    "|",
+					Replacement:  "${1}",
+					Action:       config.RelabelReplace,
+				},
+			},
+			output: clientmodel.LabelSet{
+				"a": "foo;bar",
+				"b": "baz",
+				"c": "foo;bar",
+			},
+		},
+		{
+			input: clientmodel.LabelSet{
+				"a": "foo;bar",
+				"b": "baz",
+			},
+			relabel: []*config.RelabelConfig{
+				{
+					SourceLabels: clientmodel.LabelNames{"a", "b"},
+					Regex:        &config.Regexp{*regexp.MustCompile("^foo;bar\\|baz$")},
+					Separator:    "|",
+					Action:       config.RelabelKeep,
+				},
+			},
+			output: clientmodel.LabelSet{
+				"a": "foo;bar",
+				"b": "baz",
+			},
+		},
+		{
+			// By default the regex may match any substring.
+			input: clientmodel.LabelSet{
+				"a": "foo",
+			},
+			relabel: []*config.RelabelConfig{
+				{
+					SourceLabels: clientmodel.LabelNames{"a"},
+					Regex:        &config.Regexp{*regexp.MustCompile("o")},
+					Action:       config.RelabelKeep,
+				},
+			},
+			output: clientmodel.LabelSet{
+				"a": "foo",
+			},
+		},
+		{
+			input: clientmodel.LabelSet{
+				"a": "foo",
+			},
+			relabel: []*config.RelabelConfig{
+				{
+					SourceLabels: clientmodel.LabelNames{"a"},
+					Regex:        &config.Regexp{*regexp.MustCompile("o")},
+					FullMatch:    true,
+					Action:       config.RelabelKeep,
+				},
+			},
+			output: nil,
+		},
+		{
+			input: clientmodel.LabelSet{
+				"a": "foo",
+				"b": "bar",
+			},
+			relabel: []*config.RelabelConfig{
+				{
+					SourceLabels: clientmodel.LabelNames{"a"},
+					Regex:        &config.Regexp{*regexp.MustCompile("o|fo(o)")},
+					TargetLabel:  clientmodel.LabelName("c"),
+					FullMatch:    true,
+					Replacement:  "${1}",
+					Action:       config.RelabelReplace,
+				},
+				{
+					SourceLabels: clientmodel.LabelNames{"b"},
+					Regex:        &config.Regexp{*regexp.MustCompile("ba")},
+					TargetLabel:  clientmodel.LabelName("d"),
+					FullMatch:    true,
+					Replacement:  "no",
+					Action:       config.RelabelReplace,
+				},
+			},
+			output: clientmodel.LabelSet{
+				"a": "foo",
+				"b": "bar",
+				"c": "o",
+			},
+		},
 	}
 
 	for i, test := range tests {
@@ -186,3 +307,45 @@ func TestRelabel(t *testing.T) {
 		}
 	}
 }
+
+func TestRelabelSourceEnv(t *testing.T) {
+	os.Setenv("PROMETHEUS_TEST_DC", "eu-west")
+	defer os.Unsetenv("PROMETHEUS_TEST_DC")
+	os.Unsetenv("PROMETHEUS_TEST_UNSET")
+
+	input := clientmodel.LabelSet{
+		"a": "foo",
+	}
+	cfgs := []*config.RelabelConfig{
+		{
+			SourceEnv:   []string{"PROMETHEUS_TEST_DC"},
+			Regex:       &config.Regexp{*regexp.MustCompile("(.*)")},
+			TargetLabel: clientmodel.LabelName("datacenter"),
+			Separator:   ";",
+			Replacement: "${1}",
+			Action:      config.RelabelReplace,
+		},
+		{
+			SourceLabels: clientmodel.LabelNames{"a"},
+			SourceEnv:    []string{"PROMETHEUS_TEST_UNSET", "PROMETHEUS_TEST_DC"},
+			Regex:        &config.Regexp{*regexp.MustCompile("(.*)")},
+			TargetLabel:  clientmodel.LabelName("b"),
+			Separator:    ";",
+			Replacement:  "${1}",
+			Action:       config.RelabelReplace,
+		},
+	}
+	expected := clientmodel.LabelSet{
+		"a":          "foo",
+		"b":          "foo;;eu-west",
+		"datacenter": "eu-west",
+	}
+
+	res, err := Relabel(input, cfgs...)
+	if err != nil {
+		t.Fatalf("Error relabeling: %s", err)
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("Relabel output mismatch: expected %#v, got %#v", expected, res)
+	}
+}
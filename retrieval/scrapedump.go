@@ -0,0 +1,94 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+// defaultScrapeDumpFiles is the number of dump files kept per target if not
+// configured, i.e. the dumps of the latest and the previous scrape.
+const defaultScrapeDumpFiles = 2
+
+// dumpBuffer collects the response bodies of a scrape for writing them to a
+// dump file. Writes beyond the limit are discarded if it is positive.
+type dumpBuffer struct {
+	bytes.Buffer
+	limit int64
+}
+
+func (b *dumpBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.limit > 0 {
+		if rest := b.limit - int64(b.Len()); int64(n) > rest {
+			p = p[:rest]
+		}
+	}
+	b.Buffer.Write(p)
+	return n, nil
+}
+
+// scrapeDumpName returns the base name of the dump files of the target of
+// the given job with the given URL. It consists of the job, host and path, in
+// which characters other than letters, digits, dots and dashes are replaced by
+// underscores, and a hash of the job and the full URL. Targets that only
+// differ in their job, scheme or query thus get dump files of their own.
+func scrapeDumpName(job clientmodel.LabelValue, u *url.URL) string {
+	h := fnv.New64a()
+	h.Write([]byte(job))
+	h.Write([]byte{0})
+	h.Write([]byte(u.String()))
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, string(job)+"_"+u.Host+u.Path)
+	return fmt.Sprintf("%s_%016x.dump", name, h.Sum64())
+}
+
+// writeScrapeDump writes the body to the dump file with the given name in
+// dir. The dump files of earlier scrapes are rotated by appending .1, .2, …
+// to the name, and only the given number of files is kept. The body is
+// written to a temporary file first so that a crash while writing leaves the
+// previous dump intact.
+func writeScrapeDump(dir, name string, body []byte, files int) error {
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+	rotated := func(i int) string {
+		if i == 0 {
+			return path
+		}
+		return fmt.Sprintf("%s.%d", path, i)
+	}
+	for i := files - 1; i > 0; i-- {
+		if err := os.Rename(rotated(i-1), rotated(i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,154 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/util/testutil"
+)
+
+func TestTargetScrapeDump(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				fmt.Fprintf(w, "test_metric %d\n", atomic.AddInt32(&requests, 1))
+			},
+		),
+	)
+	defer server.Close()
+
+	dir := testutil.NewTemporaryDirectory("test_scrape_dump", t)
+	defer dir.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	if err := testTarget.enableScrapeDump(dir.Path()); err != nil {
+		t.Fatal(err)
+	}
+	dumpFile := filepath.Join(dir.Path(), scrapeDumpName(testTarget.BaseLabels()[clientmodel.JobLabel], testTarget.URL()))
+
+	for i := 1; i <= 3; i++ {
+		if err := testTarget.scrape(nopAppender{}); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(dumpFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("test_metric %d\n", i); string(b) != expected {
+			t.Errorf("%d. Expected dump %q, got %q", i, expected, b)
+		}
+	}
+
+	// The dump of the previous scrape is kept, older ones are removed.
+	b, err := ioutil.ReadFile(dumpFile + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "test_metric 2\n"; string(b) != expected {
+		t.Errorf("Expected rotated dump %q, got %q", expected, b)
+	}
+	if _, err := os.Stat(dumpFile + ".2"); !os.IsNotExist(err) {
+		t.Errorf("Expected only %d dump files to be kept", defaultScrapeDumpFiles)
+	}
+}
+
+func TestTargetScrapeDumpSizeLimit(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	dir := testutil.NewTemporaryDirectory("test_scrape_dump", t)
+	defer dir.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.scrapeDumpSizeLimit = 4
+	if err := testTarget.enableScrapeDump(dir.Path()); err != nil {
+		t.Fatal(err)
+	}
+	app := &collectResultAppender{}
+	if err := testTarget.scrape(app); err != nil {
+		t.Fatal(err)
+	}
+	// Only the dump is truncated, the scraped sample is still appended.
+	found := false
+	for _, s := range app.result {
+		if s.Metric[clientmodel.MetricNameLabel] == "test_metric" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the scraped sample to be appended")
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir.Path(), scrapeDumpName(testTarget.BaseLabels()[clientmodel.JobLabel], testTarget.URL())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "test"; string(b) != expected {
+		t.Errorf("Expected dump %q, got %q", expected, b)
+	}
+}
+
+func TestScrapeDumpName(t *testing.T) {
+	parse := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	// All targets scrape the same host and path.
+	targets := []struct {
+		job clientmodel.LabelValue
+		url *url.URL
+	}{
+		{"job1", parse("http://example.org:80/metrics")},
+		{"job2", parse("http://example.org:80/metrics")},
+		{"job1", parse("https://example.org:80/metrics")},
+		{"job1", parse("http://example.org:80/metrics?module=a")},
+		{"job1", parse("http://example.org:80/metrics?module=b")},
+	}
+	names := map[string]bool{}
+	for _, target := range targets {
+		name := scrapeDumpName(target.job, target.url)
+		if names[name] {
+			t.Errorf("Dump name %s of target %s of job %s is not unique", name, target.url, target.job)
+		}
+		names[name] = true
+	}
+	if name := scrapeDumpName("job1", parse("http://example.org:80/metrics")); !strings.HasPrefix(name, "job1_example.org_80_metrics_") {
+		t.Errorf("Unexpected dump name %s", name)
+	}
+}
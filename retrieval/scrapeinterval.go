@@ -0,0 +1,119 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultScrapeIntervalBuckets are the default buckets of the histogram of
+// the actual intervals between scrapes, in seconds.
+var DefaultScrapeIntervalBuckets = HistogramBuckets{1, 5, 10, 15, 20, 30, 60, 120, 300}
+
+var (
+	scrapeIntervalsMtx sync.RWMutex
+	scrapeIntervals    = newScrapeIntervalHistogram(DefaultScrapeIntervalBuckets)
+)
+
+// HistogramBuckets are the upper bounds of the buckets of a histogram. It
+// implements flag.Value, parsing a comma-separated list of increasing
+// numbers.
+type HistogramBuckets []float64
+
+// String implements flag.Value.
+func (b HistogramBuckets) String() string {
+	bounds := make([]string, 0, len(b))
+	for _, bound := range b {
+		bounds = append(bounds, strconv.FormatFloat(bound, 'g', -1, 64))
+	}
+	return strings.Join(bounds, ",")
+}
+
+// Set implements flag.Value.
+func (b *HistogramBuckets) Set(s string) error {
+	var buckets HistogramBuckets
+	for _, bound := range strings.Split(s, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(bound), 64)
+		if err != nil {
+			return fmt.Errorf("invalid histogram bucket %q: %s", bound, err)
+		}
+		buckets = append(buckets, f)
+	}
+	if err := buckets.validate(); err != nil {
+		return err
+	}
+	*b = buckets
+	return nil
+}
+
+// validate returns an error if the buckets are empty or not increasing.
+func (b HistogramBuckets) validate() error {
+	if len(b) == 0 {
+		return fmt.Errorf("no histogram buckets given")
+	}
+	for i := 1; i < len(b); i++ {
+		if b[i] <= b[i-1] {
+			return fmt.Errorf("histogram buckets must be increasing, got %v after %v", b[i], b[i-1])
+		}
+	}
+	return nil
+}
+
+func newScrapeIntervalHistogram(buckets HistogramBuckets) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "target_scrape_interval_seconds",
+			Help:      "Histogram of the actual intervals between the starts of successive scrapes of a target by job.",
+			Buckets:   buckets,
+		},
+		[]string{string(clientmodel.JobLabel)},
+	)
+}
+
+// SetScrapeIntervalBuckets replaces the histogram of the actual intervals
+// between scrapes by one with the given buckets. Observations made so far are
+// discarded. It is meant to be called once before targets are scraped.
+func SetScrapeIntervalBuckets(buckets HistogramBuckets) error {
+	if err := buckets.validate(); err != nil {
+		return err
+	}
+	h := newScrapeIntervalHistogram(buckets)
+
+	scrapeIntervalsMtx.Lock()
+	defer scrapeIntervalsMtx.Unlock()
+
+	prometheus.Unregister(scrapeIntervals)
+	if err := prometheus.Register(h); err != nil {
+		prometheus.MustRegister(scrapeIntervals)
+		return err
+	}
+	scrapeIntervals = h
+	return nil
+}
+
+// observeScrapeInterval records the interval between the starts of two
+// successive scrapes of a target of the given job.
+func observeScrapeInterval(job clientmodel.LabelValue, d time.Duration) {
+	scrapeIntervalsMtx.RLock()
+	defer scrapeIntervalsMtx.RUnlock()
+	scrapeIntervals.WithLabelValues(string(job)).Observe(d.Seconds())
+}
@@ -0,0 +1,110 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// scrapeIntervalBucketCounts returns the cumulative counts of the buckets of
+// the scrape interval histogram of the job.
+func scrapeIntervalBucketCounts(t *testing.T, job string) []uint64 {
+	scrapeIntervalsMtx.RLock()
+	defer scrapeIntervalsMtx.RUnlock()
+
+	m := &dto.Metric{}
+	if err := scrapeIntervals.WithLabelValues(job).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	var counts []uint64
+	for _, b := range m.GetHistogram().GetBucket() {
+		counts = append(counts, b.GetCumulativeCount())
+	}
+	return counts
+}
+
+func TestObserveScrapeInterval(t *testing.T) {
+	if err := SetScrapeIntervalBuckets(HistogramBuckets{1, 10, 60}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetScrapeIntervalBuckets(DefaultScrapeIntervalBuckets)
+
+	for _, d := range []time.Duration{500 * time.Millisecond, 10 * time.Second, 15 * time.Second, 2 * time.Minute} {
+		observeScrapeInterval("test_job", d)
+	}
+	observeScrapeInterval("other_job", time.Second)
+
+	expected := []uint64{1, 2, 3}
+	if got := scrapeIntervalBucketCounts(t, "test_job"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected bucket counts %v, got %v", expected, got)
+	}
+}
+
+func TestTargetRunScraperObservesScrapeIntervals(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	if err := SetScrapeIntervalBuckets(HistogramBuckets{0.005, 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetScrapeIntervalBuckets(DefaultScrapeIntervalBuckets)
+
+	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{clientmodel.JobLabel: "interval_job"})
+	testTarget.scrapeInterval = 20 * time.Millisecond
+	go testTarget.RunScraper(nopAppender{})
+	time.Sleep(200 * time.Millisecond)
+	testTarget.StopScraper()
+
+	// All intervals are about the scrape interval.
+	counts := scrapeIntervalBucketCounts(t, "interval_job")
+	if counts[0] != 0 {
+		t.Errorf("Expected no intervals below 5ms, got %d", counts[0])
+	}
+	if counts[1] == 0 {
+		t.Errorf("Expected intervals below 1s")
+	}
+}
+
+func TestHistogramBucketsSet(t *testing.T) {
+	var b HistogramBuckets
+	if err := b.Set("0.5, 1,10"); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (HistogramBuckets{0.5, 1, 10}); !reflect.DeepEqual(b, expected) {
+		t.Errorf("Expected buckets %v, got %v", expected, b)
+	}
+	if b.String() != "0.5,1,10" {
+		t.Errorf("Expected buckets %q, got %q", "0.5,1,10", b.String())
+	}
+
+	for _, s := range []string{"", "1,a", "10,1", "1,1"} {
+		if err := b.Set(s); err == nil {
+			t.Errorf("Expected error for buckets %q", s)
+		}
+	}
+}
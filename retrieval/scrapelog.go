@@ -0,0 +1,70 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"time"
+)
+
+// ScrapeOutcome describes the result of a single scrape of a target.
+type ScrapeOutcome struct {
+	// The time the scrape started.
+	Timestamp time.Time
+	Duration  time.Duration
+	Health    TargetHealth
+	// The error of the scrape. Nil if it succeeded.
+	Error error
+}
+
+// scrapeLog is a ring buffer holding the outcomes of the latest scrapes of a
+// target. It is not safe for concurrent use.
+type scrapeLog struct {
+	outcomes []ScrapeOutcome
+	// The index the next outcome is written to once the buffer is full.
+	next int
+}
+
+// setSize changes the number of outcomes held by the log. The latest
+// outcomes are kept if it shrinks. A size of zero disables the log.
+func (l *scrapeLog) setSize(size int) {
+	if size == cap(l.outcomes) {
+		return
+	}
+	outcomes := l.latest()
+	if len(outcomes) > size {
+		outcomes = outcomes[len(outcomes)-size:]
+	}
+	l.outcomes = append(make([]ScrapeOutcome, 0, size), outcomes...)
+	l.next = 0
+}
+
+// add records the outcome of a scrape, replacing the oldest one if the log is
+// full.
+func (l *scrapeLog) add(o ScrapeOutcome) {
+	switch {
+	case cap(l.outcomes) == 0:
+	case len(l.outcomes) < cap(l.outcomes):
+		l.outcomes = append(l.outcomes, o)
+	default:
+		l.outcomes[l.next] = o
+		l.next = (l.next + 1) % len(l.outcomes)
+	}
+}
+
+// latest returns a copy of the recorded outcomes, the oldest first.
+func (l *scrapeLog) latest() []ScrapeOutcome {
+	outcomes := make([]ScrapeOutcome, 0, len(l.outcomes))
+	outcomes = append(outcomes, l.outcomes[l.next:]...)
+	return append(outcomes, l.outcomes[:l.next]...)
+}
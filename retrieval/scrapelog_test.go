@@ -0,0 +1,83 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+func TestTargetStatusScrapeLog(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				// Every second scrape fails.
+				if atomic.AddInt32(&requests, 1)%2 == 0 {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.status.setScrapeLogSize(3)
+	var starts []time.Time
+	for i := 0; i < 5; i++ {
+		starts = append(starts, time.Now())
+		testTarget.scrape(nopAppender{})
+	}
+
+	// Only the last three scrapes are retained, the oldest first.
+	outcomes := testTarget.status.ScrapeLog()
+	if len(outcomes) != 3 {
+		t.Fatalf("Expected 3 scrape outcomes, got %d", len(outcomes))
+	}
+	for i, o := range outcomes {
+		scrape := i + 2
+		if o.Timestamp.Before(starts[scrape]) || (scrape+1 < len(starts) && !o.Timestamp.Before(starts[scrape+1])) {
+			t.Errorf("%d. Expected outcome of scrape %d, got one started at %s", i, scrape, o.Timestamp)
+		}
+		if o.Duration <= 0 {
+			t.Errorf("%d. Expected positive duration, got %s", i, o.Duration)
+		}
+		expected := HealthGood
+		if scrape%2 == 1 {
+			expected = HealthBad
+		}
+		if o.Health != expected || (o.Error != nil) != (expected == HealthBad) {
+			t.Errorf("%d. Expected health %v, got %v with error %v", i, expected, o.Health, o.Error)
+		}
+	}
+
+	// Shrinking the log keeps the latest outcomes.
+	testTarget.status.setScrapeLogSize(2)
+	if shrunk := testTarget.status.ScrapeLog(); len(shrunk) != 2 || shrunk[0] != outcomes[1] || shrunk[1] != outcomes[2] {
+		t.Errorf("Expected the latest two outcomes, got %v", shrunk)
+	}
+	testTarget.status.setScrapeLogSize(0)
+	testTarget.scrape(nopAppender{})
+	if disabled := testTarget.status.ScrapeLog(); len(disabled) != 0 {
+		t.Errorf("Expected no outcomes with a disabled log, got %v", disabled)
+	}
+}
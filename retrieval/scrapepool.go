@@ -0,0 +1,152 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"fmt"
+	"sync"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/storage"
+)
+
+// scrapePool owns the targets of a job and manages the lifecycle of their
+// scrapers. Targets are identified by their key.
+type scrapePool struct {
+	mtx            sync.RWMutex
+	sampleAppender storage.SampleAppender
+	targets        map[string]*Target
+}
+
+// scrapePoolStats holds aggregate statistics about the targets of a scrape
+// pool.
+type scrapePoolStats struct {
+	// The number of targets in the pool.
+	Targets int
+	// The number of targets by their health.
+	Health map[TargetHealth]int
+	// The number of paused targets.
+	Paused int
+}
+
+func newScrapePool(sampleAppender storage.SampleAppender) *scrapePool {
+	return &scrapePool{
+		sampleAppender: sampleAppender,
+		targets:        map[string]*Target{},
+	}
+}
+
+// Sync reconciles the pool with the given targets. Scrapers of targets that
+// are no longer present are stopped and scrapers of new targets are started.
+// A target scraping the same as one already in the pool, i.e. with the same
+// configuration hash and labels, is discarded and the scraper of the existing
+// target keeps running undisturbed. A changed target replaces the existing
+// one. Of several targets with the same key only the first one is kept. Sync returns the targets of the pool in the order they were given.
+func (sp *scrapePool) Sync(targets []*Target) []*Target {
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+
+	var (
+		wg      sync.WaitGroup
+		current = make(map[string]*Target, len(targets))
+		synced  = make([]*Target, 0, len(targets))
+	)
+	stop := func(t *Target) {
+		wg.Add(1)
+		go func() {
+			t.StopScraper()
+			wg.Done()
+		}()
+	}
+
+	for _, tnew := range targets {
+		id := targetKey(tnew)
+		if _, ok := current[id]; ok {
+			continue
+		}
+		if told, ok := sp.targets[id]; ok {
+			delete(sp.targets, id)
+			if sameScrape(told, tnew) {
+				current[id] = told
+				synced = append(synced, told)
+				continue
+			}
+			stop(told)
+		}
+		current[id] = tnew
+		synced = append(synced, tnew)
+		go tnew.RunScraper(sp.sampleAppender)
+	}
+	// All targets left over have disappeared.
+	for _, told := range sp.targets {
+		stop(told)
+	}
+	wg.Wait()
+
+	sp.targets = current
+	return synced
+}
+
+// sameScrape returns true iff both targets are scraped the same way as their
+// scrape configurations, URLs and labels are equal.
+func sameScrape(a, b *Target) bool {
+	if a == b {
+		return true
+	}
+	return a.ConfigHash() == b.ConfigHash() &&
+		clientmodel.Metric(a.BaseLabels()).Equal(clientmodel.Metric(b.BaseLabels()))
+}
+
+// targetKey returns the key identifying the target among the targets of a
+// source. Targets of the same host differing in their URL, e.g. in their
+// params or metrics path, or in their labels have different keys.
+func targetKey(t *Target) string {
+	return fmt.Sprintf("%s %s", t.URL(), clientmodel.Metric(t.fullLabels()).Fingerprint())
+}
+
+// Stop stops the scrapers of all targets in the pool and removes them.
+func (sp *scrapePool) Stop() {
+	sp.Sync(nil)
+}
+
+// Targets returns the targets currently in the pool.
+func (sp *scrapePool) Targets() []*Target {
+	sp.mtx.RLock()
+	defer sp.mtx.RUnlock()
+
+	targets := make([]*Target, 0, len(sp.targets))
+	for _, t := range sp.targets {
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// Stats returns aggregate statistics about the targets in the pool.
+func (sp *scrapePool) Stats() scrapePoolStats {
+	sp.mtx.RLock()
+	defer sp.mtx.RUnlock()
+
+	stats := scrapePoolStats{
+		Targets: len(sp.targets),
+		Health:  map[TargetHealth]int{},
+	}
+	for _, t := range sp.targets {
+		stats.Health[t.status.Health()]++
+		if t.Paused() {
+			stats.Paused++
+		}
+	}
+	return stats
+}
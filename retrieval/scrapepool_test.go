@@ -0,0 +1,117 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+func TestScrapePoolSync(t *testing.T) {
+	var servers []*httptest.Server
+	for i := 0; i < 3; i++ {
+		server := httptest.NewServer(
+			http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+					w.Write([]byte("test_metric 1\n"))
+				},
+			),
+		)
+		defer server.Close()
+		servers = append(servers, server)
+	}
+
+	newTarget := func(i int) *Target {
+		return newTestTarget(servers[i].URL, time.Second, clientmodel.LabelSet{})
+	}
+	waitScraped := func(target *Target) {
+		deadline := time.Now().Add(5 * time.Second)
+		for target.status.LastScrape().IsZero() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Target %s was not scraped", target)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	stopped := func(target *Target) bool {
+		select {
+		case <-target.scraperStopped:
+			return true
+		default:
+			return false
+		}
+	}
+
+	sp := newScrapePool(nopAppender{})
+	defer sp.Stop()
+
+	unchanged, removed := newTarget(0), newTarget(1)
+	sp.Sync([]*Target{unchanged, removed})
+	waitScraped(unchanged)
+	waitScraped(removed)
+
+	if stats := sp.Stats(); stats.Targets != 2 || stats.Health[HealthUnknown]+stats.Health[HealthGood] != 2 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+
+	equal, added := newTarget(0), newTarget(2)
+	sp.Sync([]*Target{equal, added})
+	waitScraped(added)
+
+	if !stopped(removed) {
+		t.Errorf("Expected scraper of removed target to be stopped")
+	}
+	if stopped(added) {
+		t.Errorf("Expected scraper of added target to be running")
+	}
+	if stopped(unchanged) {
+		t.Errorf("Expected scraper of unchanged target to keep running")
+	}
+	// The equal target is discarded in favor of the running one.
+	if !equal.status.LastScrape().IsZero() {
+		t.Errorf("Expected equal target to not be scraped")
+	}
+
+	targets := map[*Target]bool{}
+	for _, target := range sp.Targets() {
+		targets[target] = true
+	}
+	if len(targets) != 2 || !targets[unchanged] || !targets[added] {
+		t.Errorf("Expected pool to contain the unchanged and the added target, got %v", sp.Targets())
+	}
+
+	// A target with a different configuration replaces the running one.
+	changed := newTarget(2)
+	changed.configHash = added.ConfigHash() + 1
+	if synced := sp.Sync([]*Target{unchanged, changed}); len(synced) != 2 || synced[0] != unchanged || synced[1] != changed {
+		t.Fatalf("Expected the unchanged and the changed target, got %v", synced)
+	}
+	waitScraped(changed)
+	if !stopped(added) {
+		t.Errorf("Expected scraper of replaced target to be stopped")
+	}
+
+	sp.Stop()
+	if !stopped(unchanged) || !stopped(changed) {
+		t.Errorf("Expected all scrapers to be stopped")
+	}
+	if stats := sp.Stats(); stats.Targets != 0 {
+		t.Errorf("Expected no targets after stopping, got %d", stats.Targets)
+	}
+}
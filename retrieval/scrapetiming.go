@@ -0,0 +1,157 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// ScrapeTimings holds the durations of the phases of a scrape request. A
+// phase that did not happen, e.g. the TLS handshake of a plain HTTP request
+// or the DNS lookup of a reused connection, has a zero duration.
+type ScrapeTimings struct {
+	DNSLookup    time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	// The time from sending the request until the first byte of the
+	// response arrived.
+	FirstByte time.Duration
+}
+
+// scrapeTracer measures the phases of a request via an httptrace.ClientTrace.
+// The hooks may be called from other goroutines than the one sending the
+// request.
+type scrapeTracer struct {
+	mtx       sync.Mutex
+	start     time.Time
+	dnsStart  time.Time
+	connStart time.Time
+	tlsStart  time.Time
+	timings   ScrapeTimings
+	// The remote address of the connection the request was sent on.
+	remoteAddr string
+}
+
+// trace returns a copy of the request that reports its phases to the tracer.
+// The time to first byte is measured from the call of trace.
+func (st *scrapeTracer) trace(req *http.Request) *http.Request {
+	st.mtx.Lock()
+	st.start = time.Now()
+	st.mtx.Unlock()
+
+	ct := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			st.mtx.Lock()
+			defer st.mtx.Unlock()
+			st.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			st.mtx.Lock()
+			defer st.mtx.Unlock()
+			st.timings.DNSLookup = time.Since(st.dnsStart)
+		},
+		ConnectStart: func(_, _ string) {
+			st.mtx.Lock()
+			defer st.mtx.Unlock()
+			st.connStart = time.Now()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			st.mtx.Lock()
+			defer st.mtx.Unlock()
+			// Only the successful dial of parallel ones is relevant.
+			if err == nil {
+				st.timings.Connect = time.Since(st.connStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			st.mtx.Lock()
+			defer st.mtx.Unlock()
+			st.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			st.mtx.Lock()
+			defer st.mtx.Unlock()
+			st.timings.TLSHandshake = time.Since(st.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			st.mtx.Lock()
+			defer st.mtx.Unlock()
+			st.remoteAddr = info.Conn.RemoteAddr().String()
+		},
+		GotFirstResponseByte: func() {
+			st.mtx.Lock()
+			defer st.mtx.Unlock()
+			st.timings.FirstByte = time.Since(st.start)
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), ct))
+}
+
+// Timings returns the durations of the phases measured so far.
+func (st *scrapeTracer) Timings() ScrapeTimings {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	return st.timings
+}
+
+// RemoteAddr returns the remote address of the connection the request was sent
+// on. It is empty if no connection was obtained.
+func (st *scrapeTracer) RemoteAddr() string {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	return st.remoteAddr
+}
+
+// traceConnect returns a copy of the request that adds the time spent
+// resolving the host name and connecting to the connect time of the scrape.
+// Of parallel dials, the time until the first one succeeded counts.
+func (sc *scrapeContext) traceConnect(req *http.Request) *http.Request {
+	var connectStart time.Time
+	begin := func() {
+		sc.connectMtx.Lock()
+		defer sc.connectMtx.Unlock()
+		if connectStart.IsZero() {
+			connectStart = time.Now()
+		}
+	}
+	ct := &httptrace.ClientTrace{
+		DNSStart:     func(httptrace.DNSStartInfo) { begin() },
+		ConnectStart: func(_, _ string) { begin() },
+		ConnectDone: func(_, _ string, err error) {
+			sc.connectMtx.Lock()
+			defer sc.connectMtx.Unlock()
+			if err == nil && !connectStart.IsZero() {
+				sc.connectTime += time.Since(connectStart)
+				connectStart = time.Time{}
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), ct))
+}
+
+// elapsed returns the time since the start of the scrape that counts against
+// its deadline.
+func (sc *scrapeContext) elapsed() time.Duration {
+	elapsed := time.Since(sc.start)
+	if sc.deadlineExcludesConnect {
+		sc.connectMtx.Lock()
+		defer sc.connectMtx.Unlock()
+		elapsed -= sc.connectTime
+	}
+	return elapsed
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"sort"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/storage"
+)
+
+// seriesChanges holds the number of series that appeared and disappeared
+// between two successive scrapes of a target.
+type seriesChanges struct {
+	added, removed int
+}
+
+// seriesAppender is a SampleAppender that records the series of all samples
+// it passes on.
+type seriesAppender struct {
+	storage.SampleAppender
+	series map[clientmodel.Fingerprint]clientmodel.Metric
+}
+
+// Append implements storage.SampleAppender.
+func (a *seriesAppender) Append(s *clientmodel.Sample) {
+	a.series[s.Metric.Fingerprint()] = s.Metric
+	a.SampleAppender.Append(s)
+}
+
+// RetainSeries sets whether the series of the last successful scrape are
+// retained to determine the series that changed between successive scrapes.
+// While they are retained, the numbers of added and removed series are
+// recorded as synthetic metrics.
+func (t *Target) RetainSeries(retain bool) {
+	t.Lock()
+	defer t.Unlock()
+	t.retainSeries = retain
+	if !retain {
+		t.lastSeries = nil
+		t.addedSeries, t.removedSeries = nil, nil
+	}
+}
+
+// SeriesChanges returns the series that were added and removed by the last
+// successful scrape compared to the one before, sorted by their labels. All
+// series of the first scrape are added. Series must be retained via
+// RetainSeries.
+func (t *Target) SeriesChanges() (added, removed []clientmodel.Metric) {
+	t.RLock()
+	defer t.RUnlock()
+	return t.addedSeries, t.removedSeries
+}
+
+// updateSeries replaces the retained series by the given ones and returns the
+// changes. The caller must hold the lock of the target.
+func (t *Target) updateSeries(series map[clientmodel.Fingerprint]clientmodel.Metric) *seriesChanges {
+	t.addedSeries, t.removedSeries = diffSeries(t.lastSeries, series)
+	t.lastSeries = series
+	return &seriesChanges{
+		added:   len(t.addedSeries),
+		removed: len(t.removedSeries),
+	}
+}
+
+// diffSeries returns the series only in cur and the ones only in prev, both
+// sorted by their labels.
+func diffSeries(prev, cur map[clientmodel.Fingerprint]clientmodel.Metric) (added, removed []clientmodel.Metric) {
+	for fp, m := range cur {
+		if _, ok := prev[fp]; !ok {
+			added = append(added, m)
+		}
+	}
+	for fp, m := range prev {
+		if _, ok := cur[fp]; !ok {
+			removed = append(removed, m)
+		}
+	}
+	sort.Sort(metricsByLabels(added))
+	sort.Sort(metricsByLabels(removed))
+	return added, removed
+}
+
+// metricsByLabels implements sort.Interface to sort metrics by their string
+// representation.
+type metricsByLabels []clientmodel.Metric
+
+func (m metricsByLabels) Len() int           { return len(m) }
+func (m metricsByLabels) Less(i, j int) bool { return m[i].String() < m[j].String() }
+func (m metricsByLabels) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
@@ -0,0 +1,105 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+func TestTargetSeriesChanges(t *testing.T) {
+	body := "a 1\nb 1\n"
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte(body))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.RetainSeries(true)
+	instance := clientmodel.LabelValue(testTarget.InstanceIdentifier())
+	series := func(name clientmodel.LabelValue) clientmodel.Metric {
+		return clientmodel.Metric{clientmodel.MetricNameLabel: name, clientmodel.InstanceLabel: instance}
+	}
+	// synthetic returns the value of the synthetic metric of the given name.
+	synthetic := func(app *collectResultAppender, name clientmodel.LabelValue) clientmodel.SampleValue {
+		for _, s := range app.result {
+			if s.Metric[clientmodel.MetricNameLabel] == name {
+				return s.Value
+			}
+		}
+		t.Fatalf("Expected synthetic metric %s", name)
+		return 0
+	}
+
+	app := &collectResultAppender{}
+	if err := testTarget.scrape(app); err != nil {
+		t.Fatal(err)
+	}
+	added, removed := testTarget.SeriesChanges()
+	if !metricsEqual(added, []clientmodel.Metric{series("a"), series("b")}) || len(removed) != 0 {
+		t.Errorf("Expected all series to be added by the first scrape, got added %v and removed %v", added, removed)
+	}
+	if v := synthetic(app, scrapeSeriesAddedMetricName); v != 2 {
+		t.Errorf("Expected 2 added series, got %v", v)
+	}
+
+	body = "a 1\nc 1\n"
+	app = &collectResultAppender{}
+	if err := testTarget.scrape(app); err != nil {
+		t.Fatal(err)
+	}
+	added, removed = testTarget.SeriesChanges()
+	if !metricsEqual(added, []clientmodel.Metric{series("c")}) {
+		t.Errorf("Expected added series c, got %v", added)
+	}
+	if !metricsEqual(removed, []clientmodel.Metric{series("b")}) {
+		t.Errorf("Expected removed series b, got %v", removed)
+	}
+	if v := synthetic(app, scrapeSeriesAddedMetricName); v != 1 {
+		t.Errorf("Expected 1 added series, got %v", v)
+	}
+	if v := synthetic(app, scrapeSeriesRemovedMetricName); v != 1 {
+		t.Errorf("Expected 1 removed series, got %v", v)
+	}
+
+	// Failed scrapes do not change the retained series.
+	server.Close()
+	if err := testTarget.scrape(nopAppender{}); err == nil {
+		t.Fatal("Expected scrape to fail")
+	}
+	if added, _ = testTarget.SeriesChanges(); !metricsEqual(added, []clientmodel.Metric{series("c")}) {
+		t.Errorf("Expected added series c after failed scrape, got %v", added)
+	}
+}
+
+func metricsEqual(a, b []clientmodel.Metric) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
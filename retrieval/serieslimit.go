@@ -0,0 +1,78 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"container/list"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+)
+
+// seriesLimiter caps the number of distinct series of a target across
+// scrapes. It keeps the fingerprints of the known series in least recently
+// scraped order. Once the cap is reached, a new series only replaces the least
+// recently scraped one if that one was part of neither the current nor the
+// previous scrape. As samples are not ingested in a stable order, a series of
+// the previous scrape may still follow in the current one. Otherwise the new
+// series is dropped, so that established series of the target keep being
+// ingested. It is not safe for concurrent use.
+type seriesLimiter struct {
+	limit   int
+	lru     *list.List
+	entries map[clientmodel.Fingerprint]*list.Element
+	// The number of the current scrape.
+	scrape uint64
+	// The total number of dropped samples of new series.
+	capped uint64
+}
+
+type seriesLimiterEntry struct {
+	fp clientmodel.Fingerprint
+	// The number of the last scrape the series was part of.
+	scrape uint64
+}
+
+func newSeriesLimiter(limit int) *seriesLimiter {
+	return &seriesLimiter{
+		limit:   limit,
+		lru:     list.New(),
+		entries: map[clientmodel.Fingerprint]*list.Element{},
+	}
+}
+
+// startScrape must be called before the samples of a scrape are admitted.
+func (l *seriesLimiter) startScrape() {
+	l.scrape++
+}
+
+// admit returns true iff a sample of the series with the given fingerprint
+// may be ingested.
+func (l *seriesLimiter) admit(fp clientmodel.Fingerprint) bool {
+	if e, ok := l.entries[fp]; ok {
+		e.Value.(*seriesLimiterEntry).scrape = l.scrape
+		l.lru.MoveToFront(e)
+		return true
+	}
+	if l.lru.Len() >= l.limit {
+		oldest := l.lru.Back()
+		if oldest == nil || oldest.Value.(*seriesLimiterEntry).scrape+1 >= l.scrape {
+			l.capped++
+			return false
+		}
+		delete(l.entries, oldest.Value.(*seriesLimiterEntry).fp)
+		l.lru.Remove(oldest)
+	}
+	l.entries[fp] = l.lru.PushFront(&seriesLimiterEntry{fp: fp, scrape: l.scrape})
+	return true
+}
@@ -14,14 +14,27 @@
 package retrieval
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/ioutil"
+	"math"
 	"math/rand"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -29,11 +42,15 @@ import (
 	"github.com/prometheus/client_golang/extraction"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/log"
+	"golang.org/x/crypto/pkcs12"
+	"gopkg.in/yaml.v2"
 
 	clientmodel "github.com/prometheus/client_golang/model"
 
 	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/metric"
 	"github.com/prometheus/prometheus/util/httputil"
 )
 
@@ -44,16 +61,81 @@ const (
 	// ScrapeTimeMetricName is the metric name for the synthetic scrape duration
 	// variable.
 	scrapeDurationMetricName clientmodel.LabelValue = "scrape_duration_seconds"
+	// ScrapeBodySizeMetricName is the metric name for the synthetic variable
+	// holding the number of bytes read from the scrape response body.
+	scrapeBodySizeMetricName clientmodel.LabelValue = "scrape_body_size_bytes"
+	// ScrapeTimeoutMetricName is the metric name for the synthetic variable
+	// holding the configured scrape timeout.
+	scrapeTimeoutMetricName clientmodel.LabelValue = "scrape_timeout_seconds"
+	// ScrapeTLSCertNotAfterMetricName is the metric name for the synthetic
+	// variable holding the expiry of the target's TLS certificate.
+	scrapeTLSCertNotAfterMetricName clientmodel.LabelValue = "scrape_tls_cert_not_after"
+	// ScrapeLastSuccessMetricName is the metric name for the synthetic
+	// variable holding the time of the target's last successful scrape.
+	scrapeLastSuccessMetricName clientmodel.LabelValue = "scrape_last_success_timestamp_seconds"
+	// ScrapeSeriesCappedMetricName is the metric name for the synthetic
+	// variable counting the samples dropped by the series limit.
+	scrapeSeriesCappedMetricName clientmodel.LabelValue = "scrape_series_capped"
+	// ScrapeSamplesThrottledMetricName is the metric name for the synthetic
+	// variable counting the samples dropped by the sample rate limit.
+	scrapeSamplesThrottledMetricName clientmodel.LabelValue = "scrape_samples_throttled"
+	// ScrapeLabelCardinalityMetricName is the metric name for the synthetic
+	// variable holding the number of distinct label names of the scraped
+	// samples.
+	scrapeLabelCardinalityMetricName clientmodel.LabelValue = "scrape_label_cardinality"
+	// ScrapeDNSLookupMetricName, ScrapeConnectMetricName,
+	// ScrapeTLSHandshakeMetricName and ScrapeFirstByteMetricName are the
+	// metric names for the synthetic variables holding the durations of the
+	// phases of the scrape request.
+	scrapeDNSLookupMetricName    clientmodel.LabelValue = "scrape_dns_lookup_duration_seconds"
+	scrapeConnectMetricName      clientmodel.LabelValue = "scrape_connect_duration_seconds"
+	scrapeTLSHandshakeMetricName clientmodel.LabelValue = "scrape_tls_handshake_duration_seconds"
+	scrapeFirstByteMetricName    clientmodel.LabelValue = "scrape_first_byte_duration_seconds"
+	// ScrapeFormatInfoMetricName is the metric name for the synthetic info
+	// variable whose format label holds the exposition format served by the
+	// target.
+	scrapeFormatInfoMetricName clientmodel.LabelValue = "scrape_format_info"
+	// ScrapeSeriesAddedMetricName and ScrapeSeriesRemovedMetricName are the
+	// metric names for the synthetic variables holding the number of series
+	// that appeared and disappeared since the last successful scrape.
+	scrapeSeriesAddedMetricName   clientmodel.LabelValue = "scrape_series_added"
+	scrapeSeriesRemovedMetricName clientmodel.LabelValue = "scrape_series_removed"
+	// The label of the format info metric holding the format.
+	scrapeFormatLabel clientmodel.LabelName = "format"
 	// Capacity of the channel to buffer samples during ingestion.
 	ingestedSamplesCap = 256
+	// Average backoff before retrying a scrape that failed to resolve the
+	// target's host.
+	dnsRetryBackoff = 100 * time.Millisecond
+	// Weight of the latest scrape duration in the moving average of scrape
+	// durations if none is configured.
+	defaultScrapeDurationDecay = 0.2
+	// Number of TLS sessions cached per scrape config if no size is
+	// configured.
+	defaultTLSSessionCacheSize = 256
 
 	// Constants for instrumentation.
 	namespace = "prometheus"
 	interval  = "interval"
+	labelName = "label"
 )
 
 var (
 	errIngestChannelFull = errors.New("ingestion channel full")
+	errScrapeAborted     = errors.New("scrape aborted")
+	// errUnsupportedContentType is returned if a target responds with a
+	// content type that is not one of the supported exposition formats, e.g.
+	// an HTML login page.
+	errUnsupportedContentType = errors.New("unsupported content type, expected a metrics exposition format")
+	// errScrapeDeadlineExceeded is returned if a response is not fully read
+	// and parsed within the scrape timeout.
+	errScrapeDeadlineExceeded = errors.New("scrape deadline exceeded while processing the response")
+	// errBodySizeLimitExceeded is returned if the response bodies of a scrape
+	// exceed the configured body size limit.
+	errBodySizeLimitExceeded = errors.New("body size limit exceeded")
+	// errEmptyScrape is returned if empty scrapes are configured to fail and
+	// the responses of a scrape contain no samples.
+	errEmptyScrape = errors.New("scrape returned no samples")
 
 	targetIntervalLength = prometheus.NewSummaryVec(
 		prometheus.SummaryOpts{
@@ -64,10 +146,28 @@ var (
 		},
 		[]string{interval},
 	)
+	skippedScrapes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "target_skipped_scrapes_total",
+			Help:      "Total number of scrapes that were skipped because the concurrent scrape limit of their job was exceeded until the scrape deadline.",
+		},
+	)
+	exportedLabelCollisions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "scrape_exported_label_collisions_total",
+			Help:      "Total number of scraped labels that collided with a target label and were stored with the exported prefix.",
+		},
+		[]string{labelName},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(targetIntervalLength)
+	prometheus.MustRegister(exportedLabelCollisions)
+	prometheus.MustRegister(skippedScrapes)
+	prometheus.MustRegister(scrapeIntervals)
 }
 
 // TargetHealth describes the health state of a target.
@@ -81,6 +181,8 @@ func (t TargetHealth) String() string {
 		return "healthy"
 	case HealthBad:
 		return "unhealthy"
+	case HealthPartial:
+		return "partially healthy"
 	}
 	panic("unknown state")
 }
@@ -92,13 +194,70 @@ const (
 	HealthGood
 	// Unhealthy is the state of a Target that was scraped unsuccessfully.
 	HealthBad
+	// Partially healthy is the state of a Target with multiple metrics paths
+	// of which only some were scraped successfully.
+	HealthPartial
+)
+
+// ScrapeFormat is the exposition format of a scrape response.
+type ScrapeFormat string
+
+// The exposition formats that can be scraped.
+const (
+	ScrapeFormatText     ScrapeFormat = "text"
+	ScrapeFormatProtobuf ScrapeFormat = "protobuf"
+	ScrapeFormatJSON     ScrapeFormat = "json"
+	// OpenMetrics payloads are converted to the text format before parsing.
+	ScrapeFormatOpenMetrics ScrapeFormat = "openmetrics"
 )
 
+// scrapeFormatOf returns the exposition format decoded by the processor.
+func scrapeFormatOf(p extraction.Processor) ScrapeFormat {
+	switch p {
+	case extraction.Processor004:
+		return ScrapeFormatText
+	case extraction.MetricFamilyProcessor:
+		return ScrapeFormatProtobuf
+	default:
+		return ScrapeFormatJSON
+	}
+}
+
 // TargetStatus contains information about the current status of a scrape target.
 type TargetStatus struct {
-	lastError  error
-	lastScrape time.Time
-	health     TargetHealth
+	lastError       error
+	lastScrape      time.Time
+	lastSuccess     time.Time
+	health          TargetHealth
+	peerCertExpiry  time.Time
+	rejectedSamples uint64
+	// The number of scraped samples with NaN or infinite values that were
+	// dropped or clamped.
+	nonFiniteSamples uint64
+	// The number of scraped samples dropped for not being on the metric name
+	// allowlist.
+	disallowedSamples uint64
+	// The number of scraped samples dropped for values out of the bounds of
+	// a value filter.
+	outOfRangeSamples uint64
+	// The number of scraped labels dropped because their normalized name
+	// was already taken.
+	labelNameCollisions uint64
+	// The number of scraped samples dropped because their metric family had
+	// no TYPE metadata.
+	missingMetadataSamples uint64
+	// The exponentially weighted moving average of the scrape durations.
+	avgScrapeDuration time.Duration
+	// The durations of the phases of the last scrape request.
+	scrapeTimings ScrapeTimings
+	// The number of samples exposed by the target in the last scrape.
+	lastScrapeSampleCount int
+	// The exposition format of the last response for the metrics path.
+	format ScrapeFormat
+	// The remote address the metrics path was last scraped from.
+	lastResolvedAddr string
+	// The outcomes of the latest scrapes.
+	scrapeLog scrapeLog
 
 	mu sync.RWMutex
 }
@@ -110,6 +269,28 @@ func (ts *TargetStatus) LastError() error {
 	return ts.lastError
 }
 
+// ScrapeLog returns the outcomes of the latest scrapes, the oldest first. The
+// number of outcomes retained is set by the scrape_log_size of the target's
+// scrape config. Scrapes interrupted by stopping the scraper are not
+// recorded.
+func (ts *TargetStatus) ScrapeLog() []ScrapeOutcome {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.scrapeLog.latest()
+}
+
+func (ts *TargetStatus) setScrapeLogSize(size int) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.scrapeLog.setSize(size)
+}
+
+func (ts *TargetStatus) addScrapeOutcome(o ScrapeOutcome) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.scrapeLog.add(o)
+}
+
 // LastScrape returns the time of the last scrape.
 func (ts *TargetStatus) LastScrape() time.Time {
 	ts.mu.RLock()
@@ -117,6 +298,14 @@ func (ts *TargetStatus) LastScrape() time.Time {
 	return ts.lastScrape
 }
 
+// LastSuccess returns the start time of the last successful scrape. It is the
+// zero time if the target was never scraped successfully.
+func (ts *TargetStatus) LastSuccess() time.Time {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.lastSuccess
+}
+
 // Health returns the last known health state of the target.
 func (ts *TargetStatus) Health() TargetHealth {
 	ts.mu.RLock()
@@ -124,21 +313,248 @@ func (ts *TargetStatus) Health() TargetHealth {
 	return ts.health
 }
 
+// TargetStatusSnapshot is a consistent copy of the fields of a TargetStatus.
+type TargetStatusSnapshot struct {
+	LastError              error
+	LastScrape             time.Time
+	LastSuccess            time.Time
+	Health                 TargetHealth
+	PeerCertExpiry         time.Time
+	RejectedSamples        uint64
+	NonFiniteSamples       uint64
+	DisallowedSamples      uint64
+	OutOfRangeSamples      uint64
+	LabelNameCollisions    uint64
+	MissingMetadataSamples uint64
+	AvgScrapeDuration      time.Duration
+	ScrapeTimings          ScrapeTimings
+	LastScrapeSampleCount  int
+	Format                 ScrapeFormat
+	LastResolvedAddr       string
+}
+
+// Snapshot returns a copy of all fields of the status taken at the same
+// point in time.
+func (ts *TargetStatus) Snapshot() TargetStatusSnapshot {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return TargetStatusSnapshot{
+		LastError:              ts.lastError,
+		LastScrape:             ts.lastScrape,
+		LastSuccess:            ts.lastSuccess,
+		Health:                 ts.health,
+		PeerCertExpiry:         ts.peerCertExpiry,
+		RejectedSamples:        ts.rejectedSamples,
+		NonFiniteSamples:       ts.nonFiniteSamples,
+		DisallowedSamples:      ts.disallowedSamples,
+		OutOfRangeSamples:      ts.outOfRangeSamples,
+		LabelNameCollisions:    ts.labelNameCollisions,
+		MissingMetadataSamples: ts.missingMetadataSamples,
+		AvgScrapeDuration:      ts.avgScrapeDuration,
+		ScrapeTimings:          ts.scrapeTimings,
+		LastScrapeSampleCount:  ts.lastScrapeSampleCount,
+		Format:                 ts.format,
+		LastResolvedAddr:       ts.lastResolvedAddr,
+	}
+}
+
+// AvgScrapeDuration returns the exponentially weighted moving average of the
+// durations of the target's scrapes. It is zero if the target was never
+// scraped.
+func (ts *TargetStatus) AvgScrapeDuration() time.Duration {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.avgScrapeDuration
+}
+
+// observeScrapeDuration updates the moving average of the scrape durations.
+// The given duration is weighted with decay. The first duration initializes
+// the average.
+func (ts *TargetStatus) observeScrapeDuration(d time.Duration, decay float64) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.avgScrapeDuration == 0 {
+		ts.avgScrapeDuration = d
+		return
+	}
+	ts.avgScrapeDuration = time.Duration(decay*float64(d) + (1-decay)*float64(ts.avgScrapeDuration))
+}
+
+// ScrapeTimings returns the durations of the phases of the request for the
+// metrics path in the last scrape.
+func (ts *TargetStatus) ScrapeTimings() ScrapeTimings {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.scrapeTimings
+}
+
+func (ts *TargetStatus) setScrapeTimings(st ScrapeTimings) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.scrapeTimings = st
+}
+
+// Format returns the exposition format the target served its metrics path in
+// the last time it was scraped. It is empty if no response was processed yet.
+func (ts *TargetStatus) Format() ScrapeFormat {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.format
+}
+
+func (ts *TargetStatus) setFormat(f ScrapeFormat) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.format = f
+}
+
+// LastScrapeSampleCount returns the number of samples exposed by the target
+// in the last scrape, before any of them were relabeled or dropped. The
+// synthetic samples recorded for the scrape are not included.
+func (ts *TargetStatus) LastScrapeSampleCount() int {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.lastScrapeSampleCount
+}
+
+func (ts *TargetStatus) setLastScrapeSampleCount(n int) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.lastScrapeSampleCount = n
+}
+
+// RejectedSamples returns the total number of scraped samples rejected for
+// having timestamps too far in the future.
+func (ts *TargetStatus) RejectedSamples() uint64 {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.rejectedSamples
+}
+
+// NonFiniteSamples returns the total number of scraped samples with NaN or
+// infinite values that were dropped or clamped.
+func (ts *TargetStatus) NonFiniteSamples() uint64 {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.nonFiniteSamples
+}
+
+// DisallowedSamples returns the total number of scraped samples dropped for
+// not being on the metric name allowlist.
+func (ts *TargetStatus) DisallowedSamples() uint64 {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.disallowedSamples
+}
+
+// OutOfRangeSamples returns the total number of scraped samples dropped
+// because their values were out of the bounds of a value filter.
+func (ts *TargetStatus) OutOfRangeSamples() uint64 {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.outOfRangeSamples
+}
+
+// LabelNameCollisions returns the total number of scraped labels dropped
+// because the normalized form of their name was already taken.
+func (ts *TargetStatus) LabelNameCollisions() uint64 {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.labelNameCollisions
+}
+
+// MissingMetadataSamples returns the total number of scraped samples dropped
+// because their metric family had no TYPE metadata.
+func (ts *TargetStatus) MissingMetadataSamples() uint64 {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.missingMetadataSamples
+}
+
+// sampleCounts are the numbers of samples and labels dropped or modified
+// during a scrape. They are counted per scrape and added to the status of the
+// target at once.
+type sampleCounts struct {
+	rejected            uint64
+	nonFinite           uint64
+	disallowed          uint64
+	outOfRange          uint64
+	labelNameCollisions uint64
+	missingMetadata     uint64
+}
+
+func (ts *TargetStatus) addSampleCounts(c sampleCounts) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.rejectedSamples += c.rejected
+	ts.nonFiniteSamples += c.nonFinite
+	ts.disallowedSamples += c.disallowed
+	ts.outOfRangeSamples += c.outOfRange
+	ts.labelNameCollisions += c.labelNameCollisions
+	ts.missingMetadataSamples += c.missingMetadata
+}
+
+// PeerCertExpiry returns the expiry of the leaf certificate presented by the
+// target in the last scrape over TLS. It is the zero time if the target was
+// never scraped over TLS.
+func (ts *TargetStatus) PeerCertExpiry() time.Time {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.peerCertExpiry
+}
+
+func (ts *TargetStatus) setPeerCertExpiry(t time.Time) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.peerCertExpiry = t
+}
+
+// LastResolvedAddr returns the remote address, i.e. the resolved IP and port,
+// of the connection the metrics path of the target was last scraped over.
+// If the target is scraped via a proxy, it is the address of the proxy. It is
+// empty if no connection was ever established.
+func (ts *TargetStatus) LastResolvedAddr() string {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.lastResolvedAddr
+}
+
+func (ts *TargetStatus) setLastResolvedAddr(addr string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.lastResolvedAddr = addr
+}
+
 func (ts *TargetStatus) setLastScrape(t time.Time) {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 	ts.lastScrape = t
 }
 
-func (ts *TargetStatus) setLastError(err error) {
+func (ts *TargetStatus) setLastSuccess(t time.Time) {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
+	ts.lastSuccess = t
+}
+
+// setLastError sets the error of the last scrape and updates the health
+// accordingly. It returns the health before and after the update.
+func (ts *TargetStatus) setLastError(err error) (oldHealth, newHealth TargetHealth) {
 	if err == nil {
-		ts.health = HealthGood
-	} else {
-		ts.health = HealthBad
+		return ts.setHealth(HealthGood, nil)
 	}
+	return ts.setHealth(HealthBad, err)
+}
+
+// setHealth sets the health and the error of the last scrape. It returns the
+// health before and after the update.
+func (ts *TargetStatus) setHealth(health TargetHealth, err error) (oldHealth, newHealth TargetHealth) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	oldHealth = ts.health
+	ts.health = health
 	ts.lastError = err
+	return oldHealth, ts.health
 }
 
 // Target refers to a singular HTTP or HTTPS endpoint.
@@ -149,33 +565,206 @@ type Target struct {
 	scraperStopping chan struct{}
 	// Closing scraperStopped signals that scraping has been stopped.
 	scraperStopped chan struct{}
-	// Channel to buffer ingested samples.
-	ingestedSamples chan clientmodel.Samples
 
 	// Mutex protects the members below.
 	sync.RWMutex
+	// Whether scraping is paused.
+	paused bool
 	// The HTTP client used to scrape the target's endpoint.
 	httpClient *http.Client
+	// The hash of the effective scrape configuration.
+	configHash uint64
+	// The file the exposition payload is read from instead of scraping the
+	// endpoint. Empty for targets scraped over HTTP. It is immutable.
+	filePath string
 	// url is the URL to be scraped. Its host is immutable.
 	url *url.URL
 	// Labels before any processing.
 	metaLabels clientmodel.LabelSet
+	// Labels after relabeling, including internal labels.
+	labels clientmodel.LabelSet
 	// Any base labels that are added to this target and its metrics.
 	baseLabels clientmodel.LabelSet
+	// Annotations of the target, which are not added to its metrics.
+	annotations map[string]string
 	// What is the deadline for the HTTP or HTTPS against this endpoint.
 	deadline time.Duration
+	// Whether resolving and connecting do not count against the deadline.
+	deadlineExcludesConnect bool
 	// The time between two scrapes.
 	scrapeInterval time.Duration
+	// The upper bound of the random delay before the first scrape. The scrape
+	// interval is used if zero.
+	initialScrapeDelay time.Duration
+	// Whether the scraper warms up the connection to the target before
+	// the initial delay.
+	warmup bool
+	// The source of the initial delay. The global source is used if nil.
+	initialDelayRand *rand.Rand
+	// The weight of the latest scrape duration in the moving average of
+	// scrape durations.
+	scrapeDurationDecay float64
 	// Whether the target's labels have precedence over the base labels
 	// assigned by the scraping instance.
 	honorLabels bool
+	// Whether the job label of the base labels has precedence even if
+	// honorLabels is set.
+	honorLabelsKeepJob bool
+	// The scraped labels honored although honorLabels is not set. Nil if
+	// there are none.
+	honorLabelNames map[clientmodel.LabelName]struct{}
+	// The labels added to scraped samples lacking them.
+	defaultLabels clientmodel.LabelSet
+	// What happens to scraped labels colliding with base labels if the
+	// base labels have precedence.
+	labelCollisionPolicy config.LabelCollisionPolicy
+	// Whether invalid characters in scraped label names are replaced.
+	normalizeLabelNames bool
+	// Whether the default instance label is only attached to the synthetic
+	// metrics and not to the scraped ones.
+	omitInstanceLabel bool
+	// The Accept header sent with scrape requests. The default header is used if empty.
+	acceptHeader string
+	// The HTTP method of scrape requests and the static body sent along with
+	// them. GET is used if the method is empty. The content encoding is set
+	// if the body is compressed.
+	method                 string
+	requestBody            string
+	requestBodyContentType string
+	requestBodyEncoding    string
+	// The response header from which the default timestamp of scraped samples
+	// is taken. The scrape time is used if empty.
+	timestampHeader string
+	// How far sample timestamps may be ahead of the current time and what
+	// happens to samples exceeding it.
+	timestampTolerance       time.Duration
+	timestampToleranceAction config.TimestampToleranceAction
+	// What happens to samples with NaN or infinite values.
+	nonFiniteValueAction config.NonFiniteValueAction
+	// What happens to samples of metric families without TYPE metadata.
+	// Samples do not require metadata if empty.
+	requireMetadata config.MissingMetadataAction
 	// Metric relabel configuration.
 	metricRelabelConfigs []*config.RelabelConfig
+	// Transformations of scraped sample values.
+	valueTransforms []valueTransform
+	// Bounds of scraped sample values.
+	valueFilters []valueFilter
+	// The names of scraped metrics must match it in their entirety. All
+	// metrics are kept if nil.
+	metricNameAllowlist *regexp.Regexp
+	// Whether the names of synthetic metrics get unit and counter suffixes.
+	syntheticNameSuffixes bool
+	// Whether the durations of the request phases are recorded as synthetic
+	// metrics.
+	scrapeTimingMetrics bool
+	// Whether the served exposition format is recorded as a synthetic metric.
+	scrapeFormatInfo bool
+	// Whether the number of distinct scraped label names is recorded as a
+	// synthetic metric.
+	labelCardinalityMetric bool
+	// Whether scrapes answered with a 5xx status are retried once.
+	retryServerErrors bool
+	// Whether scrapes without any samples fail.
+	failEmptyScrapes bool
+	// The status codes of successful responses. Only 200 is accepted if nil.
+	acceptStatusCodes map[int]struct{}
+	// Whether the labels of scraped samples are interned.
+	internLabels bool
+	// The tenant passed along with all samples to appenders accepting metadata.
+	tenantID string
+	// Scraped samples matching any of these sets of label matchers are dropped.
+	dropMatchers []metric.LabelMatchers
+	// How samples of the same series occurring more than once in a
+	// response are handled.
+	duplicateSampleHandling config.DuplicateSampleHandling
+	// Hook called whenever the health of the target changes.
+	healthChangeHook func(oldHealth, newHealth TargetHealth, t *Target)
+	// Called with each scraped sample before it is processed. Nil if not set.
+	sampleObserver SampleObserver
+	// Middlewares applied around each scrape of the scraper, the outermost
+	// one first.
+	scrapeMiddlewares []ScrapeMiddleware
+	// Further paths scraped in addition to the metrics path in each scrape
+	// cycle. Their samples are merged with the ones of the metrics path.
+	additionalPaths []string
+	// The path requested before each scrape. The metrics are only fetched
+	// if it returns a 2xx status. Not requested if empty.
+	healthCheckPath string
+	// The directory the response bodies of each scrape are written to. No
+	// bodies are written if empty.
+	scrapeDumpDir string
+	// The maximum number of bytes of the bodies written per scrape. Bodies
+	// are not truncated if zero.
+	scrapeDumpSizeLimit int64
+	// The number of dump files kept, including the one of the latest scrape.
+	scrapeDumpFiles int
+	// Semaphore limiting the concurrent scrapes of all targets of a job. It
+	// is shared between these targets. Scrapes are not limited if nil.
+	scrapeSemaphore chan struct{}
+	// Limits the number of distinct series of the target. Nil if series are
+	// not limited.
+	seriesLimiter *seriesLimiter
+	// Limits the rate at which samples of the target are appended. Nil if
+	// the rate is not limited.
+	sampleRateLimiter *sampleRateLimiter
+	// The maximum number of bytes read from the response bodies of a scrape.
+	// Not limited if zero.
+	bodySizeLimit int64
+	// The size the response bodies of the last successful scrape must exceed
+	// for compressed responses to be requested. Always requested if zero.
+	gzipSizeThreshold int64
+	// The number of bytes read from the response bodies of the last
+	// successful scrape.
+	lastResponseSize int64
+	// The size response bodies of the text format must exceed to be parsed
+	// concurrently. Never parsed concurrently if zero.
+	parallelParseThreshold int64
+	// The maximum number of chunks a body is parsed in concurrently.
+	parallelParseWorkers int
+	// Authenticates scrape requests in addition to the configured
+	// authentication. Nil if not set.
+	authProvider httputil.AuthProvider
+	// Authenticates scrape requests with the Negotiate scheme unless an
+	// authProvider is set. Nil if not configured.
+	negotiateAuth httputil.AuthProvider
+	// Whether the fingerprints of the samples of the last scrape are retained.
+	retainFingerprints bool
+	// The fingerprints of the samples of the last scrape.
+	lastFingerprints map[clientmodel.Fingerprint]struct{}
+	// Whether the samples of the last successful scrape are retained.
+	retainSamples bool
+	// The samples of the last successful scrape.
+	lastSamples clientmodel.Samples
+	// The metadata of the metrics exposed in the last successful scrape.
+	metadata map[string]MetricMetadata
+	// Whether the series of the last successful scrape are retained.
+	retainSeries bool
+	// The series of the last successful scrape.
+	lastSeries map[clientmodel.Fingerprint]clientmodel.Metric
+	// The series added and removed by the last successful scrape.
+	addedSeries, removedSeries []clientmodel.Metric
+
+	// The validators of the last successful response, sent along with the next
+	// scrape to allow targets to answer with 304 Not Modified.
+	lastETag         string
+	lastLastModified string
+}
+
+// NewTarget creates a reasonably configured target for querying. It fails if
+// the target cannot be configured, e.g. if it has no scheme.
+func NewTarget(cfg *config.ScrapeConfig, baseLabels, metaLabels clientmodel.LabelSet) (*Target, error) {
+	t := newTarget(baseLabels)
+	if err := t.Update(cfg, baseLabels, metaLabels); err != nil {
+		return nil, err
+	}
+	return t, nil
 }
 
-// NewTarget creates a reasonably configured target for querying.
-func NewTarget(cfg *config.ScrapeConfig, baseLabels, metaLabels clientmodel.LabelSet) *Target {
-	t := &Target{
+// newTarget returns a target for the given base labels that still has to be
+// configured by calling Update.
+func newTarget(baseLabels clientmodel.LabelSet) *Target {
+	return &Target{
 		url: &url.URL{
 			Scheme: string(baseLabels[clientmodel.SchemeLabel]),
 			Host:   string(baseLabels[clientmodel.AddressLabel]),
@@ -184,8 +773,14 @@ func NewTarget(cfg *config.ScrapeConfig, baseLabels, metaLabels clientmodel.Labe
 		scraperStopping: make(chan struct{}),
 		scraperStopped:  make(chan struct{}),
 	}
-	t.Update(cfg, baseLabels, metaLabels)
-	return t
+}
+
+// setScrapeSemaphore sets the semaphore that has to be acquired before each
+// scrape of the target.
+func (t *Target) setScrapeSemaphore(sem chan struct{}) {
+	t.Lock()
+	defer t.Unlock()
+	t.scrapeSemaphore = sem
 }
 
 // Status returns the status of the target.
@@ -193,26 +788,131 @@ func (t *Target) Status() *TargetStatus {
 	return t.status
 }
 
+// OnHealthChange registers a hook that is called whenever the health of the
+// target changes after a scrape. The hook is called without holding any locks
+// of the target. Passing nil removes a previously registered hook.
+func (t *Target) OnHealthChange(f func(oldHealth, newHealth TargetHealth, t *Target)) {
+	t.Lock()
+	defer t.Unlock()
+	t.healthChangeHook = f
+}
+
+// SampleObserver is called with each sample parsed from a scrape response.
+type SampleObserver func(*clientmodel.Sample)
+
+// SetSampleObserver registers an observer that is called with each sample
+// parsed from the target's scrape responses, before the target labels are
+// attached and before relabeling. The observer receives a copy of the sample,
+// so it cannot change the appended samples. It is called from the scraping
+// goroutine and should return quickly. Passing nil removes a previously
+// registered observer.
+func (t *Target) SetSampleObserver(o SampleObserver) {
+	t.Lock()
+	defer t.Unlock()
+	t.sampleObserver = o
+}
+
+// RetainFingerprints sets whether the fingerprints of the samples of the
+// last scrape are retained. This is meant for debugging series collisions
+// between targets.
+func (t *Target) RetainFingerprints(retain bool) {
+	t.Lock()
+	defer t.Unlock()
+	t.retainFingerprints = retain
+	if !retain {
+		t.lastFingerprints = nil
+	}
+}
+
+// LastFingerprints returns the fingerprints of the samples of the last scrape
+// if they are retained.
+func (t *Target) LastFingerprints() map[clientmodel.Fingerprint]struct{} {
+	t.RLock()
+	defer t.RUnlock()
+	fps := make(map[clientmodel.Fingerprint]struct{}, len(t.lastFingerprints))
+	for fp := range t.lastFingerprints {
+		fps[fp] = struct{}{}
+	}
+	return fps
+}
+
+// RetainSamples sets whether the samples of the last successful scrape are
+// retained. This is meant for debugging and costs as much memory as the
+// samples of a scrape.
+func (t *Target) RetainSamples(retain bool) {
+	t.Lock()
+	defer t.Unlock()
+	t.retainSamples = retain
+	if !retain {
+		t.lastSamples = nil
+	}
+}
+
+// FingerprintOverlaps returns the number of series fingerprints that were
+// produced by the last scrape of more than one of the given targets. Only
+// targets retaining their fingerprints are considered.
+func FingerprintOverlaps(targets []*Target) int {
+	counts := map[clientmodel.Fingerprint]int{}
+	for _, t := range targets {
+		for fp := range t.LastFingerprints() {
+			counts[fp]++
+		}
+	}
+	overlaps := 0
+	for _, c := range counts {
+		if c > 1 {
+			overlaps++
+		}
+	}
+	return overlaps
+}
+
 // Update overwrites settings in the target that are derived from the job config
-// it belongs to.
-func (t *Target) Update(cfg *config.ScrapeConfig, baseLabels, metaLabels clientmodel.LabelSet) {
+// it belongs to. If the target cannot be configured, e.g. because it has
+// neither a scheme label nor a configured scheme, an error is returned and the
+// target is left unchanged.
+func (t *Target) Update(cfg *config.ScrapeConfig, baseLabels, metaLabels clientmodel.LabelSet) error {
 	t.Lock()
 	defer t.Unlock()
 
-	httpClient, err := newHTTPClient(cfg)
-	if err != nil {
-		log.Errorf("cannot create HTTP client: %v", err)
-		return
+	// File targets are not requested over the network and need no scheme.
+	scheme := string(baseLabels[clientmodel.SchemeLabel])
+	if scheme == "" {
+		scheme = cfg.Scheme
+	}
+	if scheme == "" && t.filePath == "" {
+		return fmt.Errorf("target %s has neither a scheme label nor a configured scheme", t.url.Host)
+	}
+
+	if t.filePath != "" {
+		t.httpClient = newFileClient(t.filePath)
+	} else {
+		httpClient, err := newHTTPClient(cfg)
+		if err != nil {
+			return fmt.Errorf("cannot create HTTP client: %s", err)
+		}
+		t.httpClient = httpClient
 	}
-	t.httpClient = httpClient
 
-	t.url.Scheme = string(baseLabels[clientmodel.SchemeLabel])
+	t.url.Scheme = scheme
 	t.url.Path = string(baseLabels[clientmodel.MetricsPathLabel])
+	t.negotiateAuth = negotiateAuthProvider(cfg, t.url.Hostname())
 	params := url.Values{}
 	for k, v := range cfg.Params {
 		params[k] = make([]string, len(v))
 		copy(params[k], v)
 	}
+	for k, ln := range cfg.ParamLabels {
+		v, ok := baseLabels[ln]
+		if !ok {
+			continue
+		}
+		if len(params[k]) > 0 {
+			params[k][0] = string(v)
+		} else {
+			params[k] = []string{string(v)}
+		}
+	}
 	for k, v := range baseLabels {
 		if strings.HasPrefix(string(k), clientmodel.ParamLabelPrefix) {
 			if len(params[string(k[len(clientmodel.ParamLabelPrefix):])]) > 0 {
@@ -222,16 +922,118 @@ func (t *Target) Update(cfg *config.ScrapeConfig, baseLabels, metaLabels clientm
 			}
 		}
 	}
+	for k, vs := range params {
+		for i, v := range vs {
+			params[k][i] = expandLabels(v, baseLabels)
+		}
+	}
 	t.url.RawQuery = params.Encode()
-	if cfg.BasicAuth != nil {
-		t.url.User = url.UserPassword(cfg.BasicAuth.Username, cfg.BasicAuth.Password)
+
+	t.additionalPaths = append([]string(nil), cfg.AdditionalMetricsPaths...)
+	t.healthCheckPath = cfg.HealthCheckPath
+	// The known series are kept as long as the limit does not change.
+	if cfg.SeriesLimit == 0 {
+		t.seriesLimiter = nil
+	} else if t.seriesLimiter == nil || t.seriesLimiter.limit != cfg.SeriesLimit {
+		t.seriesLimiter = newSeriesLimiter(cfg.SeriesLimit)
+	}
+	burst := cfg.SampleRateBurst
+	if burst == 0 {
+		burst = int(math.Ceil(cfg.SampleRateLimit))
 	}
+	if cfg.SampleRateLimit == 0 {
+		t.sampleRateLimiter = nil
+	} else if l := t.sampleRateLimiter; l == nil || l.rate != cfg.SampleRateLimit || l.burst != float64(burst) {
+		t.sampleRateLimiter = newSampleRateLimiter(cfg.SampleRateLimit, burst)
+	}
+	t.bodySizeLimit = cfg.BodySizeLimit
+	t.gzipSizeThreshold = cfg.GzipSizeThreshold
+	t.parallelParseThreshold = cfg.ParallelParseThreshold
+	t.parallelParseWorkers = cfg.ParallelParseWorkers
+	if t.parallelParseWorkers == 0 {
+		t.parallelParseWorkers = runtime.GOMAXPROCS(0)
+	}
+	t.duplicateSampleHandling = cfg.DuplicateSampleHandling
 
 	t.scrapeInterval = time.Duration(cfg.ScrapeInterval)
+	t.initialScrapeDelay = time.Duration(cfg.InitialScrapeDelay)
+	t.warmup = cfg.Warmup
 	t.deadline = time.Duration(cfg.ScrapeTimeout)
+	t.deadlineExcludesConnect = cfg.DeadlineExcludesConnect
+	t.scrapeDurationDecay = cfg.ScrapeDurationDecay
+	if t.scrapeDurationDecay == 0 {
+		t.scrapeDurationDecay = defaultScrapeDurationDecay
+	}
+
+	t.annotations = make(map[string]string, len(cfg.Annotations))
+	for k, v := range cfg.Annotations {
+		t.annotations[k] = v
+	}
 
 	t.honorLabels = cfg.HonorLabels
+	t.honorLabelsKeepJob = cfg.HonorLabelsKeepJob
+	t.honorLabelNames = nil
+	if len(cfg.HonorLabelNames) > 0 {
+		t.honorLabelNames = make(map[clientmodel.LabelName]struct{}, len(cfg.HonorLabelNames))
+		for _, ln := range cfg.HonorLabelNames {
+			t.honorLabelNames[ln] = struct{}{}
+		}
+	}
+	t.defaultLabels = cfg.DefaultLabels
+	t.labelCollisionPolicy = cfg.LabelCollisionPolicy
+	t.status.setScrapeLogSize(cfg.ScrapeLogSize)
+	t.normalizeLabelNames = cfg.NormalizeLabelNames
+	t.omitInstanceLabel = cfg.OmitInstanceLabel
+	t.tenantID = cfg.TenantID
+	t.scrapeDumpDir = ""
+	t.scrapeDumpSizeLimit = cfg.ScrapeDumpSizeLimit
+	t.scrapeDumpFiles = cfg.ScrapeDumpFiles
+	if cfg.ScrapeDumpDir != "" {
+		if err := t.enableScrapeDump(cfg.ScrapeDumpDir); err != nil {
+			log.Errorf("Cannot enable scrape dumps of target %s: %s", t.url, err)
+		}
+	}
+	t.syntheticNameSuffixes = cfg.SyntheticNameSuffixes
+	t.scrapeTimingMetrics = cfg.ScrapeTimingMetrics
+	t.labelCardinalityMetric = cfg.LabelCardinalityMetric
+	t.scrapeFormatInfo = cfg.ScrapeFormatInfo
+	t.retryServerErrors = cfg.RetryServerErrors
+	t.failEmptyScrapes = cfg.FailEmptyScrapes
+	t.acceptStatusCodes = nil
+	if len(cfg.AcceptStatusCodes) > 0 {
+		t.acceptStatusCodes = make(map[int]struct{}, len(cfg.AcceptStatusCodes))
+		for _, code := range cfg.AcceptStatusCodes {
+			t.acceptStatusCodes[code] = struct{}{}
+		}
+	}
+	t.internLabels = cfg.InternLabels
+	t.acceptHeader = cfg.AcceptHeader
+	t.method = cfg.HTTPMethod
+	t.requestBody = cfg.RequestBody
+	t.requestBodyContentType = cfg.RequestBodyContentType
+	t.requestBodyEncoding = ""
+	if cfg.CompressRequestBody {
+		// The body is static, so it is compressed only once.
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		gw.Write([]byte(cfg.RequestBody))
+		gw.Close()
+		t.requestBody = buf.String()
+		t.requestBodyEncoding = "gzip"
+	}
+	t.timestampHeader = ""
+	if cfg.HonorTimestamps {
+		t.timestampHeader = cfg.TimestampHeader
+	}
+	t.timestampTolerance = time.Duration(cfg.TimestampTolerance)
+	t.timestampToleranceAction = cfg.TimestampToleranceAction
+	t.nonFiniteValueAction = cfg.NonFiniteValueAction
+	t.requireMetadata = cfg.RequireMetadata
 	t.metaLabels = metaLabels
+	t.labels = make(clientmodel.LabelSet, len(baseLabels))
+	for name, val := range baseLabels {
+		t.labels[name] = val
+	}
 	t.baseLabels = clientmodel.LabelSet{}
 	// All remaining internal labels will not be part of the label set.
 	for name, val := range baseLabels {
@@ -239,25 +1041,134 @@ func (t *Target) Update(cfg *config.ScrapeConfig, baseLabels, metaLabels clientm
 			t.baseLabels[name] = val
 		}
 	}
-	if _, ok := t.baseLabels[clientmodel.InstanceLabel]; !ok {
+	if _, ok := t.baseLabels[clientmodel.InstanceLabel]; !ok && !t.omitInstanceLabel {
 		t.baseLabels[clientmodel.InstanceLabel] = clientmodel.LabelValue(t.InstanceIdentifier())
 	}
 	t.metricRelabelConfigs = cfg.MetricRelabelConfigs
+	t.valueTransforms = newValueTransforms(cfg.ValueTransforms)
+	t.valueFilters = newValueFilters(cfg.ValueFilters)
+	t.metricNameAllowlist = newMetricNameAllowlist(cfg.MetricNameAllowlist)
+	t.dropMatchers = nil
+	for _, sel := range cfg.DropSampleSelectors {
+		// Loaded configs only contain valid selectors.
+		matchers, err := promql.ParseMetricSelector(sel)
+		if err != nil {
+			log.Errorf("Ignoring invalid drop sample selector %q: %s", sel, err)
+			continue
+		}
+		t.dropMatchers = append(t.dropMatchers, matchers)
+	}
+	t.configHash = scrapeConfigHash(t.url, cfg)
+	return nil
+}
+
+// newMetricNameAllowlist returns a regular expression matching the metric
+// names matched entirely by any of the given ones. It returns nil if there
+// are none.
+func newMetricNameAllowlist(res []*config.Regexp) *regexp.Regexp {
+	var exprs []string
+	for _, re := range res {
+		if re != nil {
+			exprs = append(exprs, "(?:"+re.String()+")")
+		}
+	}
+	if len(exprs) == 0 {
+		return nil
+	}
+	return anchoredRegexp(regexp.MustCompile(strings.Join(exprs, "|")))
+}
+
+var labelRefRE = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+
+// expandLabels replaces all ${label} references in s with the value of the
+// label in the given label set. References to labels that are not set expand
+// to the empty string.
+func expandLabels(s string, labels clientmodel.LabelSet) string {
+	return labelRefRE.ReplaceAllStringFunc(s, func(ref string) string {
+		ln := clientmodel.LabelName(labelRefRE.FindStringSubmatch(ref)[1])
+		lv, ok := labels[ln]
+		if !ok {
+			log.Warnf("Cannot expand reference to unknown label %q in %q", ln, s)
+		}
+		return string(lv)
+	})
+}
+
+// tokenSources holds the bearer token sources shared by the targets of each
+// scrape config so that a token is fetched once per job rather than once per
+// target.
+type tokenSources struct {
+	mtx     sync.Mutex
+	sources map[*config.ScrapeConfig]sharedTokenSource
+}
+
+type sharedTokenSource struct {
+	url    string
+	ttl    config.Duration
+	source httputil.TokenSource
+}
+
+var jobTokenSources = &tokenSources{
+	sources: map[*config.ScrapeConfig]sharedTokenSource{},
+}
+
+// get returns the token source of the scrape config. A new one is created if
+// there is none yet or the token settings of the config changed.
+func (ts *tokenSources) get(cfg *config.ScrapeConfig, newSource func() httputil.TokenSource) httputil.TokenSource {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	s, ok := ts.sources[cfg]
+	if !ok || s.url != cfg.BearerTokenURL || s.ttl != cfg.BearerTokenTTL {
+		s = sharedTokenSource{url: cfg.BearerTokenURL, ttl: cfg.BearerTokenTTL, source: newSource()}
+		ts.sources[cfg] = s
+	}
+	return s.source
+}
+
+// retain removes the token sources of all scrape configs but the given ones.
+func (ts *tokenSources) retain(cfgs []*config.ScrapeConfig) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	keep := make(map[*config.ScrapeConfig]bool, len(cfgs))
+	for _, cfg := range cfgs {
+		keep[cfg] = true
+	}
+	for cfg := range ts.sources {
+		if !keep[cfg] {
+			delete(ts.sources, cfg)
+		}
+	}
 }
 
 func newHTTPClient(cfg *config.ScrapeConfig) (*http.Client, error) {
 	tlsConfig := &tls.Config{}
 
-	// If a CA cert is provided then let's read it in so we can validate the
+	// If CA certs are provided then let's read them in so we can validate the
 	// scrape target's certificate properly.
+	var caCerts []string
 	if len(cfg.CACert) > 0 {
+		caCerts = append(caCerts, cfg.CACert)
+	}
+	caCerts = append(caCerts, cfg.CACerts...)
+
+	if len(caCerts) > 0 || cfg.UseSystemCertPool {
 		caCertPool := x509.NewCertPool()
-		// Load CA cert.
-		caCert, err := ioutil.ReadFile(cfg.CACert)
-		if err != nil {
-			return nil, fmt.Errorf("Unable to use specified CA cert %s: %s", cfg.CACert, err)
+		if cfg.UseSystemCertPool {
+			sysPool, err := x509.SystemCertPool()
+			if err != nil {
+				return nil, fmt.Errorf("Unable to load system cert pool: %s", err)
+			}
+			caCertPool = sysPool
+		}
+		for _, file := range caCerts {
+			caCert, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to use specified CA cert %s: %s", file, err)
+			}
+			if !caCertPool.AppendCertsFromPEM(caCert) {
+				return nil, fmt.Errorf("Unable to use specified CA cert %s: no certificates found", file)
+			}
 		}
-		caCertPool.AppendCertsFromPEM(caCert)
 		tlsConfig.RootCAs = caCertPool
 	}
 
@@ -268,73 +1179,192 @@ func newHTTPClient(cfg *config.ScrapeConfig) (*http.Client, error) {
 			return nil, fmt.Errorf("Unable to use specified client cert (%s) & key (%s): %s", cfg.ClientCert.Cert, cfg.ClientCert.Key, err)
 		}
 		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else if cfg.ClientCert != nil && len(cfg.ClientCert.CertPEM) > 0 && len(cfg.ClientCert.KeyPEM) > 0 {
+		cert, err := tls.X509KeyPair([]byte(cfg.ClientCert.CertPEM), []byte(cfg.ClientCert.KeyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to use specified inline client cert & key: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else if cfg.ClientCertP12 != nil {
+		cert, err := loadClientCertP12(cfg.ClientCertP12)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to use specified client cert bundle (%s): %s", cfg.ClientCertP12.File, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
 	tlsConfig.BuildNameToCertificate()
+	tlsConfig.ClientSessionCache = tlsSessionCache(cfg)
 
-	// Get a default roundtripper with the scrape timeout.
-	rt := httputil.NewDeadlineRoundTripper(time.Duration(cfg.ScrapeTimeout), cfg.ProxyURL.URL)
+	// Get a default roundtripper with the scrape timeouts.
+	proxyURL := cfg.ProxyURL.URL
+	// The transport dials the targets through SOCKS5 proxies itself.
+	if cfg.SOCKS5Proxy != nil {
+		proxyURL = cfg.SOCKS5Proxy.URL()
+	}
+	headerTimeout := cfg.ScrapeHeaderTimeout
+	if headerTimeout == 0 {
+		headerTimeout = cfg.ScrapeTimeout
+	}
+	var resolver *net.Resolver
+	if cfg.DNSServer != "" {
+		resolver = newDNSServerResolver(cfg.DNSServer)
+	}
+	var rt http.RoundTripper
+	if cfg.DeadlineExcludesConnect {
+		rt = httputil.NewConnectedDeadlineRoundTripper(time.Duration(cfg.ScrapeTimeout), time.Duration(headerTimeout), proxyURL, resolver)
+	} else {
+		rt = httputil.NewResolverDeadlineRoundTripper(time.Duration(cfg.ScrapeTimeout), time.Duration(headerTimeout), proxyURL, resolver)
+	}
 	tr := rt.(*http.Transport)
 	// Set the TLS config from above
 	tr.TLSClientConfig = tlsConfig
 	rt = tr
+	if cfg.EnableHTTP2 {
+		// Over TLS the protocol is negotiated via ALPN during the handshake.
+		// The protocols must be set before cloning, which initializes them.
+		tr.Protocols = &http.Protocols{}
+		tr.Protocols.SetHTTP1(true)
+		tr.Protocols.SetHTTP2(true)
+		// The scheme of a target may differ from the one of its config if
+		// it was relabeled, so the protocols are chosen per request.
+		h2c := tr.Clone()
+		// HTTP/2 over cleartext requires prior knowledge as there is no
+		// negotiation step.
+		h2c.Protocols = &http.Protocols{}
+		h2c.Protocols.SetUnencryptedHTTP2(true)
+		rt = schemeRoundTripper{"http": h2c, "https": tr}
+	}
 
-	// If a bearer token is provided, create a round tripper that will set the
-	// Authorization header correctly on each request.
+	// If basic auth credentials or a bearer token are provided, create a
+	// round tripper that will set the Authorization header correctly on each
+	// request.
 	bearerToken := cfg.BearerToken
 	if len(bearerToken) == 0 && len(cfg.BearerTokenFile) > 0 {
 		if b, err := ioutil.ReadFile(cfg.BearerTokenFile); err != nil {
 			return nil, fmt.Errorf("Unable to read bearer token file %s: %s", cfg.BearerTokenFile, err)
 		} else {
-			bearerToken = string(b)
+			// Token files usually end with a new line, which is not part
+			// of the token.
+			bearerToken = strings.TrimSpace(string(b))
 		}
 	}
-	if len(bearerToken) > 0 {
-		rt = httputil.NewBearerAuthRoundTripper(bearerToken, rt)
+	if cfg.BasicAuth != nil {
+		rt = httputil.NewAuthRoundTripper(httputil.NewBasicAuthProvider(cfg.BasicAuth.Username, cfg.BasicAuth.Password), rt)
+	} else if len(bearerToken) > 0 {
+		rt = httputil.NewAuthRoundTripper(httputil.NewBearerAuthProvider(bearerToken), rt)
+	} else if len(cfg.BearerTokenURL) > 0 {
+		// The token endpoint is requested with the same TLS and proxy settings
+		// as the targets.
+		ts := jobTokenSources.get(cfg, func() httputil.TokenSource {
+			return httputil.NewURLTokenSource(cfg.BearerTokenURL, time.Duration(cfg.BearerTokenTTL), httputil.NewClient(tr))
+		})
+		rt = httputil.NewAuthRoundTripper(httputil.NewTokenSourceAuthProvider(ts), rt)
 	}
 
 	// Return a new client with the configured round tripper.
 	return httputil.NewClient(rt), nil
 }
 
+// loadClientCertP12 decodes the client cert and key of a PKCS#12 bundle.
+func loadClientCertP12(c *config.ClientCertP12) (tls.Certificate, error) {
+	password := c.Password
+	if len(c.PasswordFile) > 0 {
+		b, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("unable to read password file %s: %s", c.PasswordFile, err)
+		}
+		// Files written by editors usually end with a newline.
+		password = strings.TrimSpace(string(b))
+	}
+	b, err := ioutil.ReadFile(c.File)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, cert, err := pkcs12.Decode(b, password)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}, nil
+}
+
 func (t *Target) String() string {
 	return t.url.Host
 }
 
+// sampleIngester is an extraction.Ingester buffering the ingested samples of
+// a single response in a channel. Each response has its own ingester so that
+// scrapes running outside of the scraper do not interfere with it.
+type sampleIngester struct {
+	samples chan clientmodel.Samples
+	// How long to wait for the channel to accept samples before failing.
+	timeout time.Duration
+}
+
 // Ingest implements an extraction.Ingester.
-func (t *Target) Ingest(s clientmodel.Samples) error {
-	t.RLock()
-	deadline := t.deadline
-	t.RUnlock()
-	// Since the regular case is that ingestedSamples is ready to receive,
-	// first try without setting a timeout so that we don't need to allocate
-	// a timer most of the time.
+func (i *sampleIngester) Ingest(s clientmodel.Samples) error {
+	// Since the regular case is that samples is ready to receive, first try
+	// without setting a timeout so that we don't need to allocate a timer
+	// most of the time.
 	select {
-	case t.ingestedSamples <- s:
+	case i.samples <- s:
 		return nil
 	default:
 		select {
-		case t.ingestedSamples <- s:
+		case i.samples <- s:
 			return nil
-		case <-time.After(deadline / 10):
+		case <-time.After(i.timeout):
 			return errIngestChannelFull
 		}
 	}
 }
 
-// Ensure that Target implements extraction.Ingester at compile time.
-var _ extraction.Ingester = (*Target)(nil)
+// Ensure that sampleIngester implements extraction.Ingester at compile time.
+var _ extraction.Ingester = (*sampleIngester)(nil)
 
 // RunScraper implements Target.
 func (t *Target) RunScraper(sampleAppender storage.SampleAppender) {
+	t.RunScraperMulti(sampleAppender)
+}
+
+// RunScraperMulti runs the scraper like RunScraper but appends every scraped
+// sample to all of the given sample appenders.
+func (t *Target) RunScraperMulti(sampleAppenders ...storage.SampleAppender) {
 	defer close(t.scraperStopped)
 
+	var sampleAppender storage.SampleAppender = storage.Fanout(sampleAppenders)
+	if len(sampleAppenders) == 1 {
+		sampleAppender = sampleAppenders[0]
+	}
+
 	t.RLock()
 	lastScrapeInterval := t.scrapeInterval
+	maxInitialDelay := t.initialScrapeDelay
+	warmup, deadline := t.warmup, t.deadline
+	randFloat := rand.Float64
+	if t.initialDelayRand != nil {
+		randFloat = t.initialDelayRand.Float64
+	}
 	t.RUnlock()
 
 	log.Debugf("Starting scraper for target %v...", t)
 
-	jitterTimer := time.NewTimer(time.Duration(float64(lastScrapeInterval) * rand.Float64()))
+	if warmup {
+		ctx, cancel := context.WithTimeout(context.Background(), deadline)
+		if err := t.Warmup(ctx); err != nil {
+			log.Warnf("Warmup of target %v failed: %s", t, err)
+		}
+		cancel()
+	}
+
+	// The first scrapes of all targets are spread over the initial delay.
+	if maxInitialDelay == 0 || maxInitialDelay > lastScrapeInterval {
+		maxInitialDelay = lastScrapeInterval
+	}
+	jitterTimer := time.NewTimer(time.Duration(float64(maxInitialDelay) * randFloat()))
 	select {
 	case <-jitterTimer.C:
 	case <-t.scraperStopping:
@@ -346,8 +1376,15 @@ func (t *Target) RunScraper(sampleAppender storage.SampleAppender) {
 	ticker := time.NewTicker(lastScrapeInterval)
 	defer ticker.Stop()
 
-	t.status.setLastScrape(time.Now())
-	t.scrape(sampleAppender)
+	// wasPaused is set if scrapes were skipped since the last scrape. The
+	// interval to the next scrape is not a regular scrape interval then.
+	wasPaused := false
+	if t.Paused() {
+		wasPaused = true
+	} else {
+		t.status.setLastScrape(time.Now())
+		t.limitedScrape(sampleAppender)
+	}
 
 	// Explanation of the contraption below:
 	//
@@ -365,12 +1402,17 @@ func (t *Target) RunScraper(sampleAppender storage.SampleAppender) {
 			case <-t.scraperStopping:
 				return
 			case <-ticker.C:
+				if t.Paused() {
+					wasPaused = true
+					continue
+				}
 				took := time.Since(t.status.LastScrape())
 				t.status.setLastScrape(time.Now())
 
 				intervalStr := lastScrapeInterval.String()
 
 				t.RLock()
+				job := t.baseLabels[clientmodel.JobLabel]
 				// On changed scrape interval the new interval becomes effective
 				// after the next scrape.
 				if lastScrapeInterval != t.scrapeInterval {
@@ -380,15 +1422,106 @@ func (t *Target) RunScraper(sampleAppender storage.SampleAppender) {
 				}
 				t.RUnlock()
 
-				targetIntervalLength.WithLabelValues(intervalStr).Observe(
-					float64(took) / float64(time.Second), // Sub-second precision.
-				)
-				t.scrape(sampleAppender)
+				if !wasPaused {
+					targetIntervalLength.WithLabelValues(intervalStr).Observe(
+						float64(took) / float64(time.Second), // Sub-second precision.
+					)
+					observeScrapeInterval(job, took)
+				}
+				wasPaused = false
+				t.limitedScrape(sampleAppender)
 			}
 		}
 	}
 }
 
+// limitedScrape scrapes the target after acquiring the scrape semaphore of its
+// job. If the semaphore cannot be acquired within the scrape deadline, the
+// scrape is skipped.
+func (t *Target) limitedScrape(sampleAppender storage.SampleAppender) {
+	t.RLock()
+	sem := t.scrapeSemaphore
+	deadline := t.deadline
+	t.RUnlock()
+
+	if sem != nil {
+		timer := time.NewTimer(deadline)
+		select {
+		case sem <- struct{}{}:
+			timer.Stop()
+			defer func() { <-sem }()
+		case <-timer.C:
+			log.Debugf("Skipping scrape of target %v, concurrent scrape limit exceeded", t)
+			skippedScrapes.Inc()
+			return
+		case <-t.scraperStopping:
+			timer.Stop()
+			return
+		}
+	}
+	t.RLock()
+	mws := t.scrapeMiddlewares
+	t.RUnlock()
+
+	var scrape ScrapeFunc = t.scrape
+	for i := len(mws) - 1; i >= 0; i-- {
+		scrape = mws[i](scrape)
+	}
+	scrape(sampleAppender)
+}
+
+// ScrapeFunc scrapes a target once, appends the scraped samples and returns
+// the error the scrape failed with, if any.
+type ScrapeFunc func(sampleAppender storage.SampleAppender) error
+
+// ScrapeMiddleware wraps a ScrapeFunc to add behavior around scrapes, for
+// example logging or instrumentation. A middleware may skip a scrape by not
+// calling the wrapped function.
+type ScrapeMiddleware func(next ScrapeFunc) ScrapeFunc
+
+// SetScrapeMiddlewares sets the middlewares applied around each scrape of the
+// target's scraper. The first middleware is the outermost one. The health of
+// the target is recorded by the innermost scrape, so scrapes skipped by a
+// middleware leave it unchanged. Calling it without middlewares removes all
+// of them.
+func (t *Target) SetScrapeMiddlewares(mws ...ScrapeMiddleware) {
+	t.Lock()
+	defer t.Unlock()
+	t.scrapeMiddlewares = append([]ScrapeMiddleware(nil), mws...)
+}
+
+// SetAuthProvider sets an AuthProvider that authenticates all scrape
+// requests of the target in place of the configured negotiate_auth. It is
+// applied after all other request headers are set. A nil provider removes it.
+func (t *Target) SetAuthProvider(p httputil.AuthProvider) {
+	t.Lock()
+	defer t.Unlock()
+	t.authProvider = p
+}
+
+// Pause suspends scraping of the target until Resume is called. In contrast
+// to StopScraper, the scraper keeps running and the status of the target
+// is retained. A scrape in progress is completed.
+func (t *Target) Pause() {
+	t.Lock()
+	defer t.Unlock()
+	t.paused = true
+}
+
+// Resume continues scraping of a paused target with the next scrape interval.
+func (t *Target) Resume() {
+	t.Lock()
+	defer t.Unlock()
+	t.paused = false
+}
+
+// Paused returns true iff scraping of the target is paused.
+func (t *Target) Paused() bool {
+	t.RLock()
+	defer t.RUnlock()
+	return t.paused
+}
+
 // StopScraper implements Target.
 func (t *Target) StopScraper() {
 	log.Debugf("Stopping scraper for target %v...", t)
@@ -399,78 +1532,853 @@ func (t *Target) StopScraper() {
 	log.Debugf("Scraper for target %v stopped.", t)
 }
 
+// StopScraperTimeout signals the scraper to stop like StopScraper but waits
+// at most for the given duration for it to stop, e.g. if it is stuck in a
+// hanging scrape. It returns an error if the scraper did not stop in time, in
+// which case it still stops once the current scrape has finished. Only one of
+// StopScraper and StopScraperTimeout may be called for a target.
+func (t *Target) StopScraperTimeout(d time.Duration) error {
+	log.Debugf("Stopping scraper for target %v...", t)
+
+	close(t.scraperStopping)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-t.scraperStopped:
+	case <-timer.C:
+		return fmt.Errorf("scraper for target %v did not stop within %v", t, d)
+	}
+
+	log.Debugf("Scraper for target %v stopped.", t)
+	return nil
+}
+
 const acceptHeader = `application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,text/plain;version=0.0.4;q=0.3,application/json;schema="prometheus/telemetry";version=0.0.2;q=0.2,*/*;q=0.1`
 
 func (t *Target) scrape(sampleAppender storage.SampleAppender) (err error) {
+	// The batches of batching appenders are handed off after all samples of
+	// the scrape, including the synthetic ones, have been appended.
+	sampleAppender, endBatches := startBatchedScrape(sampleAppender)
+	if endBatches != nil {
+		defer endBatches()
+	}
 	start := time.Now()
 	baseLabels := t.BaseLabels()
 
 	t.RLock()
 	var (
-		honorLabels          = t.honorLabels
-		httpClient           = t.httpClient
-		metricRelabelConfigs = t.metricRelabelConfigs
+		tenantID           = t.tenantID
+		nameSuffixes       = t.syntheticNameSuffixes
+		timingMetrics      = t.scrapeTimingMetrics
+		formatInfo         = t.scrapeFormatInfo
+		labelCardinality   = t.labelCardinalityMetric
+		deadline           = t.deadline
+		durationDecay      = t.scrapeDurationDecay
+		omitInstanceLabel  = t.omitInstanceLabel
+		seriesLimiter      = t.seriesLimiter
+		rateLimiter        = t.sampleRateLimiter
+		healthChangeHook   = t.healthChangeHook
+		retainFingerprints = t.retainFingerprints
+		retainSamples      = t.retainSamples
+		retainSeries       = t.retainSeries
+		failEmptyScrapes   = t.failEmptyScrapes
+		additionalPaths    = t.additionalPaths
+		healthCheckPath    = t.healthCheckPath
+		dropMatchers       = t.dropMatchers
+		dumpDir            = t.scrapeDumpDir
+		dumpFiles          = t.scrapeDumpFiles
 	)
+	sc := t.newScrapeContext(start, baseLabels)
+	if dumpDir != "" {
+		sc.dump = &dumpBuffer{limit: t.scrapeDumpSizeLimit}
+	}
 	t.RUnlock()
 
+	sc.metadata = map[string]MetricMetadata{}
+	if labelCardinality {
+		sc.labelNames = map[clientmodel.LabelName]struct{}{}
+	}
+	if seriesLimiter != nil {
+		seriesLimiter.startScrape()
+		sc.seriesLimiter = seriesLimiter
+	}
+	if retainFingerprints {
+		sc.fingerprints = map[clientmodel.Fingerprint]struct{}{}
+	}
+	// The synthetic metrics must identify the target even if the scraped
+	// metrics bring their own instance label.
+	healthLabels := baseLabels
+	if _, ok := baseLabels[clientmodel.InstanceLabel]; !ok && omitInstanceLabel {
+		healthLabels = make(clientmodel.LabelSet, len(baseLabels)+1)
+		for ln, lv := range baseLabels {
+			healthLabels[ln] = lv
+		}
+		healthLabels[clientmodel.InstanceLabel] = clientmodel.LabelValue(t.InstanceIdentifier())
+	}
+	if tenantID != "" {
+		sampleAppender = storage.WithMetadata(sampleAppender, &storage.SampleMetadata{TenantID: tenantID})
+	}
+
+	// aborted is set if the scrape was interrupted by stopping the scraper.
+	aborted := false
+	// partial is set if only some of the target's paths could be scraped.
+	partial := false
+
 	defer func() {
-		t.status.setLastError(err)
-		recordScrapeHealth(sampleAppender, clientmodel.TimestampFromTime(start), baseLabels, t.status.Health(), time.Since(start))
+		t.status.addSampleCounts(sc.counts)
+		// An interrupted scrape says nothing about the health of the target.
+		if aborted {
+			return
+		}
+		health := HealthGood
+		if partial {
+			health = HealthPartial
+		} else if err != nil {
+			health = HealthBad
+		}
+		duration := time.Since(start)
+		oldHealth, newHealth := t.status.setHealth(health, err)
+		if newHealth == HealthGood {
+			t.status.setLastSuccess(start)
+		}
+		t.status.observeScrapeDuration(duration, durationDecay)
+		t.status.setScrapeTimings(sc.timings)
+		t.status.setLastScrapeSampleCount(sc.samples)
+		t.status.addScrapeOutcome(ScrapeOutcome{
+			Timestamp: start,
+			Duration:  duration,
+			Health:    newHealth,
+			Error:     err,
+		})
+		report := scrapeReport{
+			timestamp:      clientmodel.TimestampFromTime(start),
+			baseLabels:     healthLabels,
+			health:         newHealth,
+			duration:       duration,
+			timeout:        deadline,
+			bodySize:       sc.body.n,
+			peerCertExpiry: sc.peerCertExpiry,
+			lastSuccess:    t.status.LastSuccess(),
+			labelNames:     sc.labelNames,
+			changes:        sc.seriesChanges,
+			nameSuffixes:   nameSuffixes,
+		}
+		if seriesLimiter != nil {
+			report.seriesCapped = seriesLimiter.capped
+		}
+		if rateLimiter != nil {
+			report.samplesThrottled = rateLimiter.throttled
+		}
+		if timingMetrics {
+			report.timings = &sc.timings
+		}
+		if formatInfo {
+			report.format = t.status.Format()
+		}
+		recordScrapeHealth(sampleAppender, report)
+		if healthChangeHook != nil && oldHealth != newHealth {
+			healthChangeHook(oldHealth, newHealth, t)
+		}
 	}()
 
-	req, err := http.NewRequest("GET", t.URL().String(), nil)
+	scrapeAppender := sampleAppender
+	if retainSamples {
+		sc.retained = &retainingAppender{SampleAppender: scrapeAppender}
+		scrapeAppender = sc.retained
+	}
+	if retainSeries {
+		sc.series = &seriesAppender{SampleAppender: scrapeAppender, series: map[clientmodel.Fingerprint]clientmodel.Metric{}}
+		scrapeAppender = sc.series
+	}
+	var limitedAppender *rateLimitedAppender
+	if rateLimiter != nil {
+		limitedAppender = &rateLimitedAppender{SampleAppender: scrapeAppender, limiter: rateLimiter, deadline: start.Add(deadline)}
+		scrapeAppender = limitedAppender
+	}
+	// Synthetic samples recorded for the scrape are never dropped as they
+	// bypass the scrape appender.
+	for _, matchers := range dropMatchers {
+		scrapeAppender = matcherAppender{SampleAppender: scrapeAppender, matchers: matchers}
+	}
+
+	u := t.URL()
+	if sc.dump != nil {
+		defer func() {
+			if err := writeScrapeDump(dumpDir, scrapeDumpName(baseLabels[clientmodel.JobLabel], u), sc.dump.Bytes(), dumpFiles); err != nil {
+				log.Warnf("Error writing scrape dump of target %s: %s", t, err)
+			}
+		}()
+	}
+	if healthCheckPath != "" {
+		hu := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: healthCheckPath}
+		if err := t.checkHealth(hu, sc); err != nil {
+			return fmt.Errorf("health check failed: %s", err)
+		}
+	}
+	// The first path is the primary one. It is scraped with conditional
+	// requests and errors on it are returned unchanged. Errors on additional
+	// paths are only returned if all earlier paths were scraped successfully.
+	paths := append([]string{u.Path}, additionalPaths...)
+	failed := 0
+	for i, path := range paths {
+		pu := &url.URL{}
+		*pu = *u
+		pu.Path = path
+
+		perr := t.scrapeURL(scrapeAppender, pu, i == 0, i == 0, sc)
+		// Rate limited samples are only appended once the response has been
+		// ingested.
+		if limitedAppender != nil {
+			limitedAppender.flush()
+		}
+		if perr == errScrapeAborted {
+			aborted = true
+			return perr
+		}
+		if perr == nil {
+			continue
+		}
+		failed++
+		if err == nil {
+			if i > 0 {
+				perr = fmt.Errorf("error scraping path %s: %s", path, perr)
+			}
+			err = perr
+		}
+	}
+	partial = failed > 0 && failed < len(paths)
+	// An unmodified response has no samples but those of the last scrape.
+	if err == nil && failEmptyScrapes && sc.samples == 0 && !sc.notModified {
+		err = errEmptyScrape
+	}
+
+	// Only remember the fingerprints and metadata if all paths were fully
+	// processed.
+	if err == nil {
+		t.Lock()
+		if sc.fingerprints != nil {
+			t.lastFingerprints = sc.fingerprints
+		}
+		// The samples of an unmodified response are those of the last scrape.
+		if sc.retained != nil && !sc.notModified {
+			t.lastSamples = sc.retained.samples
+		}
+		if sc.series != nil && t.retainSeries {
+			if sc.notModified {
+				sc.seriesChanges = &seriesChanges{}
+			} else {
+				sc.seriesChanges = t.updateSeries(sc.series.series)
+			}
+		}
+		t.metadata = sc.metadata
+		if !sc.notModified {
+			t.lastResponseSize = sc.body.n
+		}
+		t.Unlock()
+	}
+	return err
+}
+
+// scrapeContext holds the settings and state shared by the scrapes of all
+// paths of a target within one scrape cycle.
+type scrapeContext struct {
+	// The context of the scrape requests. Requests are not bound to a
+	// context if nil.
+	ctx                     context.Context
+	start                   time.Time
+	deadline                time.Duration
+	deadlineExcludesConnect bool
+	baseLabels              clientmodel.LabelSet
+	honorLabels             bool
+	honorLabelsKeepJob      bool
+	honorLabelNames         map[clientmodel.LabelName]struct{}
+	defaultLabels           clientmodel.LabelSet
+	accept                  string
+	method                  string
+	requestBody             string
+	requestBodyContentType  string
+	requestBodyEncoding     string
+	timestampHeader         string
+	httpClient              *http.Client
+	authProvider            httputil.AuthProvider
+	metricRelabelConfigs    []*config.RelabelConfig
+	valueTransforms         []valueTransform
+	valueFilters            []valueFilter
+	metricNameAllowlist     *regexp.Regexp
+	duplicateSampleHandling config.DuplicateSampleHandling
+	labelCollisionPolicy    config.LabelCollisionPolicy
+	normalizeLabelNames     bool
+	// How far sample timestamps may be ahead of the current time. Not
+	// checked if zero.
+	timestampTolerance       time.Duration
+	timestampToleranceAction config.TimestampToleranceAction
+	nonFiniteValueAction     config.NonFiniteValueAction
+	requireMetadata          config.MissingMetadataAction
+	sampleObserver           SampleObserver
+	retryServerErrors        bool
+	// The Accept-Encoding header of the requests. The transport requests and
+	// decompresses gzip-compressed responses if empty.
+	acceptEncoding    string
+	acceptStatusCodes map[int]struct{}
+	// The size bodies must exceed to be parsed concurrently by the given
+	// number of workers. Never parsed concurrently if zero.
+	parallelParseThreshold int64
+	parallelParseWorkers   int
+	// The size of the bodies of the last successful scrape.
+	lastResponseSize int64
+	// Interns the labels of scraped samples. Nil if they are not interned.
+	interner *stringInterner
+
+	// The fingerprints of all appended samples. Nil if they are not retained.
+	fingerprints map[clientmodel.Fingerprint]struct{}
+	// Counts the bytes read from all response bodies.
+	body *countingReader
+	// Collects the response bodies for the dump file. Nil if bodies are not
+	// dumped.
+	dump *dumpBuffer
+	// The time spent resolving and connecting for all requests. It is only
+	// measured if it is excluded from the deadline. Guarded by connectMtx as
+	// it is measured by request trace hooks.
+	connectTime time.Duration
+	connectMtx  sync.Mutex
+	// The distinct label names of the scraped samples, before they were
+	// merged with the target labels. The metric name is not included. Nil if
+	// they are not collected.
+	labelNames map[clientmodel.LabelName]struct{}
+	// The metadata collected from all response bodies. Nil if it is not
+	// collected.
+	metadata map[string]MetricMetadata
+	// Limits the series of the target. Nil if series are not limited.
+	seriesLimiter *seriesLimiter
+	// The expiry of the leaf certificate presented in the response of the
+	// metrics path. Zero if it was not scraped over TLS.
+	peerCertExpiry time.Time
+	// The durations of the phases of the request for the metrics path.
+	timings ScrapeTimings
+	// The number of samples read from all response bodies.
+	samples int
+	// The samples and labels dropped or modified in all response bodies.
+	counts sampleCounts
+	// Retains the appended samples. Nil if they are not retained.
+	retained *retainingAppender
+	// Whether the metrics path was answered with 304 Not Modified.
+	notModified bool
+	// Records the series of the appended samples. Nil if they are not
+	// retained.
+	series *seriesAppender
+	// The changes of the series compared to the last successful scrape. Nil
+	// if series are not retained or the scrape failed.
+	seriesChanges *seriesChanges
+}
+
+// acceptsStatus returns whether responses with the given status code are
+// successful.
+func (sc *scrapeContext) acceptsStatus(code int) bool {
+	if sc.acceptStatusCodes == nil {
+		return code == http.StatusOK
+	}
+	_, ok := sc.acceptStatusCodes[code]
+	return ok
+}
+
+// newScrapeContext returns a scrape context for a scrape started at the given
+// time. The caller must hold at least the read lock of the target.
+func (t *Target) newScrapeContext(start time.Time, baseLabels clientmodel.LabelSet) *scrapeContext {
+	authProvider := t.authProvider
+	if authProvider == nil {
+		authProvider = t.negotiateAuth
+	}
+	sc := &scrapeContext{
+		start:                    start,
+		deadline:                 t.deadline,
+		deadlineExcludesConnect:  t.deadlineExcludesConnect,
+		baseLabels:               baseLabels,
+		honorLabels:              t.honorLabels,
+		honorLabelsKeepJob:       t.honorLabelsKeepJob,
+		honorLabelNames:          t.honorLabelNames,
+		defaultLabels:            t.defaultLabels,
+		accept:                   t.acceptHeader,
+		method:                   t.method,
+		requestBody:              t.requestBody,
+		requestBodyContentType:   t.requestBodyContentType,
+		requestBodyEncoding:      t.requestBodyEncoding,
+		timestampHeader:          t.timestampHeader,
+		httpClient:               t.httpClient,
+		authProvider:             authProvider,
+		metricRelabelConfigs:     t.metricRelabelConfigs,
+		valueTransforms:          t.valueTransforms,
+		valueFilters:             t.valueFilters,
+		metricNameAllowlist:      t.metricNameAllowlist,
+		duplicateSampleHandling:  t.duplicateSampleHandling,
+		labelCollisionPolicy:     t.labelCollisionPolicy,
+		normalizeLabelNames:      t.normalizeLabelNames,
+		timestampTolerance:       t.timestampTolerance,
+		timestampToleranceAction: t.timestampToleranceAction,
+		nonFiniteValueAction:     t.nonFiniteValueAction,
+		requireMetadata:          t.requireMetadata,
+		sampleObserver:           t.sampleObserver,
+		retryServerErrors:        t.retryServerErrors,
+		acceptStatusCodes:        t.acceptStatusCodes,
+		parallelParseThreshold:   t.parallelParseThreshold,
+		parallelParseWorkers:     t.parallelParseWorkers,
+		lastResponseSize:         t.lastResponseSize,
+		body:                     &countingReader{limit: t.bodySizeLimit},
+	}
+	if sc.accept == "" {
+		sc.accept = acceptHeader
+	}
+	if sc.method == "" {
+		sc.method = "GET"
+	}
+	// Compressing small responses costs the target more than it saves.
+	if t.gzipSizeThreshold > 0 && t.lastResponseSize <= t.gzipSizeThreshold {
+		sc.acceptEncoding = "identity"
+	}
+	if t.internLabels {
+		sc.interner = labelInterner
+	}
+	return sc
+}
+
+// CheckReachable scrapes the target's metrics path once and returns the
+// error the scrape failed with, if any. The scraped samples are discarded and
+// the status of the target is not updated. It allows to detect misconfigured
+// targets right after their creation. It may be called while the target's
+// scraper is running.
+func (t *Target) CheckReachable(ctx context.Context) error {
+	baseLabels := t.BaseLabels()
+
+	t.RLock()
+	sc := t.newScrapeContext(time.Now(), baseLabels)
+	t.RUnlock()
+	sc.ctx = ctx
+
+	return t.scrapeURL(discardAppender{}, t.URL(), false, false, sc)
+}
+
+// enableScrapeDump makes the target write the raw response bodies of each
+// scrape to a file in dir for post-mortem analysis, creating dir if needed.
+// The caller must hold the write lock of the target.
+func (t *Target) enableScrapeDump(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	t.scrapeDumpDir = dir
+	if t.scrapeDumpFiles == 0 {
+		t.scrapeDumpFiles = defaultScrapeDumpFiles
+	}
+	return nil
+}
+
+// checkHealth requests the given health check URL. It returns an error if the
+// request fails or the response does not have a 2xx status.
+func (t *Target) checkHealth(u *url.URL, sc *scrapeContext) error {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if sc.authProvider != nil {
+		if err := sc.authProvider.ApplyAuth(req); err != nil {
+			return fmt.Errorf("error authenticating health check request: %s", err)
+		}
+	}
+	if sc.ctx != nil {
+		req = req.WithContext(sc.ctx)
+	}
+	resp, err := t.doWithDNSRetry(req, sc)
+	if err != nil {
+		return err
+	}
+	// Draining the body allows to reuse the connection for the scrape.
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("server returned HTTP status %s", resp.Status)
+	}
+	return nil
+}
+
+// Warmup connects to the target ahead of its first scrape so that an
+// unreachable target is noticed early. It sends a HEAD request for the
+// metrics path through the target's HTTP client and does not fetch any
+// metrics. Any response counts as success. As scrape connections are not kept
+// alive, only the TLS session is reused, which lets the first scrape resume it
+// instead of doing a full handshake if TLS sessions are cached.
+func (t *Target) Warmup(ctx context.Context) error {
+	baseLabels := t.BaseLabels()
+
+	t.RLock()
+	sc := t.newScrapeContext(time.Now(), baseLabels)
+	fileTarget := t.filePath != ""
+	t.RUnlock()
+	if fileTarget {
+		return nil
+	}
+
+	req, err := http.NewRequest("HEAD", t.URL().String(), nil)
+	if err != nil {
+		return err
+	}
+	if sc.authProvider != nil {
+		if err := sc.authProvider.ApplyAuth(req); err != nil {
+			return fmt.Errorf("error authenticating warmup request: %s", err)
+		}
+	}
+	resp, err := sc.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ScrapeSampleFraction scrapes the target's metrics path once and returns the
+// samples of the given fraction of the scraped series after metric
+// relabeling. It allows to inspect the series of very large targets. The
+// selection is deterministic: a series is selected if its fingerprint falls
+// into the lowest fraction of the fingerprint space, so repeated calls select
+// the same series. The samples are not appended to storage and the status of
+// the target is not updated. It may be called while the target's scraper is
+// running.
+func (t *Target) ScrapeSampleFraction(f float64) ([]*clientmodel.Sample, error) {
+	if f < 0 || f > 1 {
+		return nil, fmt.Errorf("sample fraction %v not between 0 and 1", f)
+	}
+	baseLabels := t.BaseLabels()
+
+	t.RLock()
+	sc := t.newScrapeContext(time.Now(), baseLabels)
+	t.RUnlock()
+
+	app := &fractionAppender{fraction: f}
+	if err := t.scrapeURL(app, t.URL(), false, false, sc); err != nil {
+		return nil, err
+	}
+	return app.samples, nil
+}
+
+// fractionAppender is a SampleAppender collecting the samples of a fraction
+// of all series.
+type fractionAppender struct {
+	fraction float64
+	samples  []*clientmodel.Sample
+}
+
+// Append implements storage.SampleAppender.
+func (a *fractionAppender) Append(s *clientmodel.Sample) {
+	if float64(s.Metric.Fingerprint()) < a.fraction*math.MaxUint64 {
+		a.samples = append(a.samples, s)
+	}
+}
+
+// retainingAppender is a SampleAppender that retains all samples it passes on.
+type retainingAppender struct {
+	storage.SampleAppender
+	samples clientmodel.Samples
+}
+
+// Append implements storage.SampleAppender.
+func (a *retainingAppender) Append(s *clientmodel.Sample) {
+	a.samples = append(a.samples, s)
+	a.SampleAppender.Append(s)
+}
+
+// discardAppender is a SampleAppender that discards all samples.
+type discardAppender struct{}
+
+// Append implements storage.SampleAppender.
+func (discardAppender) Append(*clientmodel.Sample) {}
+
+// scrapeURL scrapes a single URL of the target and appends the resulting
+// samples. If primary is true, the URL is the metrics path of a regular
+// scrape: the phases of the request, the format of the response and the
+// expiry of the peer certificate are reported in the status of the target,
+// and a 304 Not Modified response marks the whole scrape as unmodified. If
+// conditional is true, the validators of the last response are sent along
+// with the request and the validators of a fully processed response are
+// remembered. An unconditional request answered with 304 Not Modified fails
+// unless the status code is accepted.
+func (t *Target) scrapeURL(sampleAppender storage.SampleAppender, u *url.URL, primary, conditional bool, sc *scrapeContext) (err error) {
+	var reqBody io.Reader
+	if sc.requestBody != "" {
+		reqBody = strings.NewReader(sc.requestBody)
+	}
+	req, err := http.NewRequest(sc.method, u.String(), reqBody)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if sc.requestBodyContentType != "" {
+		req.Header.Set("Content-Type", sc.requestBodyContentType)
+	}
+	if sc.requestBodyEncoding != "" {
+		req.Header.Set("Content-Encoding", sc.requestBodyEncoding)
+	}
+	// A custom authentication takes precedence over the configured one, which
+	// is only applied if no Authorization header is set.
+	if sc.authProvider != nil {
+		if err := sc.authProvider.ApplyAuth(req); err != nil {
+			return fmt.Errorf("error authenticating scrape request: %s", err)
+		}
+	}
+	if sc.ctx != nil {
+		req = req.WithContext(sc.ctx)
+	}
+	req.Header.Add("Accept", sc.accept)
+	if sc.acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", sc.acceptEncoding)
 	}
-	req.Header.Add("Accept", acceptHeader)
 
-	resp, err := httpClient.Do(req)
+	if conditional {
+		t.RLock()
+		if t.lastETag != "" {
+			req.Header.Set("If-None-Match", t.lastETag)
+		}
+		if t.lastLastModified != "" {
+			req.Header.Set("If-Modified-Since", t.lastLastModified)
+		}
+		t.RUnlock()
+	}
+
+	var tracer *scrapeTracer
+	if primary {
+		tracer = &scrapeTracer{}
+		req = tracer.trace(req)
+	}
+	if sc.deadlineExcludesConnect {
+		req = sc.traceConnect(req)
+	}
+	resp, err := t.doWithAuthChallenge(req, sc)
+	// The phases are also of interest for requests that failed.
+	if tracer != nil {
+		sc.timings = tracer.Timings()
+		if addr := tracer.RemoteAddr(); addr != "" {
+			t.status.setLastResolvedAddr(addr)
+		}
+	}
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
+	if primary && resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		sc.peerCertExpiry = resp.TLS.PeerCertificates[0].NotAfter
+		t.status.setPeerCertExpiry(sc.peerCertExpiry)
+	}
+	// The exposed metrics did not change since the last scrape. The scrape
+	// counts as successful but there are no new samples to append. The
+	// metadata of the last scrape remains valid.
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		if primary {
+			sc.notModified = true
+		}
+		if sc.metadata != nil {
+			t.RLock()
+			for name, md := range t.metadata {
+				sc.metadata[name] = md
+			}
+			t.RUnlock()
+		}
+		return nil
+	}
+	if !sc.acceptsStatus(resp.StatusCode) {
 		return fmt.Errorf("server returned HTTP status %s", resp.Status)
 	}
+	// The target is up but has no metrics to expose.
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
 
-	processor, err := extraction.ProcessorForRequestHeader(resp.Header)
-	if err != nil {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err == nil {
+		if _, ok := supportedMediaTypes[mediaType]; !ok {
+			log.Debugf("Target %s returned unsupported content type %q", t, mediaType)
+			return errUnsupportedContentType
+		}
+	}
+
+	// OpenMetrics payloads are converted to the classic text format.
+	openMetrics := mediaType == openMetricsMediaType
+	var processor extraction.Processor
+	if openMetrics {
+		processor = extraction.Processor004
+	} else if processor, err = extraction.ProcessorForRequestHeader(resp.Header); err != nil {
 		return err
 	}
+	if primary {
+		format := scrapeFormatOf(processor)
+		if openMetrics {
+			format = ScrapeFormatOpenMetrics
+		}
+		t.status.setFormat(format)
+	}
+	// Bodies of unknown length, e.g. chunked ones, are only checked while
+	// they are read.
+	if sc.body.limit > 0 && resp.ContentLength > sc.body.limit-sc.body.n {
+		return errBodySizeLimitExceeded
+	}
 
-	t.ingestedSamples = make(chan clientmodel.Samples, ingestedSamplesCap)
+	ingester := &sampleIngester{
+		samples: make(chan clientmodel.Samples, ingestedSamplesCap),
+		timeout: sc.deadline / 10,
+	}
+	sc.body.r = resp.Body
+	if sc.dump != nil {
+		sc.body.r = io.TeeReader(resp.Body, sc.dump)
+	}
 
 	processOptions := &extraction.ProcessOptions{
-		Timestamp: clientmodel.TimestampFromTime(start),
+		Timestamp: clientmodel.TimestampFromTime(sc.start),
 	}
+	if h := resp.Header.Get(sc.timestampHeader); sc.timestampHeader != "" && h != "" {
+		if ts, err := parseTimestampHeader(h); err != nil {
+			log.Warnf("Ignoring invalid timestamp header %s of target %s: %s", sc.timestampHeader, t, err)
+		} else {
+			processOptions.Timestamp = ts
+		}
+	}
+	// Metadata is only collected from the text format. The extraction
+	// processors do not expose the metadata of the protobuf format.
+	var body io.Reader = sc.body
+	if sc.metadata != nil && processor == extraction.Processor004 {
+		body = &metadataReader{r: sc.body, metadata: sc.metadata}
+	}
+	var typeChecker *typeCheckingReader
+	if sc.requireMetadata != "" && processor == extraction.Processor004 {
+		typeChecker = newTypeCheckingReader(body, sc.requireMetadata == config.MissingMetadataDrop)
+		body = typeChecker
+	}
+	// The size of bodies of unknown length, e.g. compressed ones, is assumed
+	// to be the one of the last scrape.
+	size := resp.ContentLength
+	if size < 0 {
+		size = sc.lastResponseSize
+	}
+	parallel := processor == extraction.Processor004 && sc.parallelParseThreshold > 0 &&
+		sc.parallelParseWorkers > 1 && size > sc.parallelParseThreshold
 	go func() {
-		err = processor.ProcessSingle(resp.Body, t, processOptions)
-		close(t.ingestedSamples)
+		if openMetrics {
+			body, err = convertOpenMetrics(body)
+		}
+		if err == nil {
+			if parallel {
+				err = processParallel(body, processor, ingester, processOptions, sc.parallelParseWorkers)
+			} else {
+				err = processor.ProcessSingle(body, ingester, processOptions)
+			}
+		}
+		close(ingester.samples)
+	}()
+
+	// If the scraper is stopped during the scrape, closing the body makes the
+	// processing fail. All samples decoded until then are still appended below.
+	// The same applies if the response is not fully read and parsed until
+	// the scrape deadline, for example because the body trickles in slowly.
+	processed := make(chan struct{})
+	defer close(processed)
+	deadlineTimer := time.NewTimer(sc.deadline - sc.elapsed())
+	defer deadlineTimer.Stop()
+	go func() {
+		select {
+		case <-t.scraperStopping:
+			resp.Body.Close()
+		case <-deadlineTimer.C:
+			resp.Body.Close()
+		case <-processed:
+		}
 	}()
 
-	for samples := range t.ingestedSamples {
+	// If duplicate sample handling is configured, samples are deduplicated
+	// by their fingerprint before they are appended.
+	var (
+		deduped clientmodel.Samples
+		index   = map[clientmodel.Fingerprint]int{}
+		dupErr  error
+		// Set if a sample was dropped due to a label collision.
+		collisionErr error
+		// If a label collision or missing metadata fails the scrape, samples
+		// are buffered and only appended if the response was accepted.
+		buffered = sc.labelCollisionPolicy == config.LabelCollisionError || sc.requireMetadata == config.MissingMetadataError
+		pending  clientmodel.Samples
+	)
+	// Samples with timestamps further ahead are clamped or rejected.
+	maxTimestamp := clientmodel.Now().Add(sc.timestampTolerance)
+	for samples := range ingester.samples {
+		sc.samples += len(samples)
 		for _, s := range samples {
-			if honorLabels {
+			if sc.sampleObserver != nil {
+				sc.sampleObserver(&clientmodel.Sample{
+					Metric:    s.Metric.Clone(),
+					Value:     s.Value,
+					Timestamp: s.Timestamp,
+				})
+			}
+			if sc.normalizeLabelNames {
+				if n := normalizeLabelNames(s.Metric); n > 0 {
+					sc.counts.labelNameCollisions += uint64(n)
+				}
+			}
+			if sc.labelNames != nil {
+				for ln := range s.Metric {
+					if ln != clientmodel.MetricNameLabel {
+						sc.labelNames[ln] = struct{}{}
+					}
+				}
+			}
+			if sc.honorLabels {
 				// Merge the metric with the baseLabels for labels not already set in the
 				// metric. This also considers labels explicitly set to the empty string.
-				for ln, lv := range baseLabels {
+				for ln, lv := range sc.baseLabels {
 					if _, ok := s.Metric[ln]; !ok {
 						s.Metric[ln] = lv
 					}
 				}
+				// The job label may be kept as it identifies the scrape config.
+				if job, ok := sc.baseLabels[clientmodel.JobLabel]; ok && sc.honorLabelsKeepJob {
+					if v := s.Metric[clientmodel.JobLabel]; v != "" && v != job {
+						s.Metric[clientmodel.ExportedLabelPrefix+clientmodel.JobLabel] = v
+					}
+					s.Metric[clientmodel.JobLabel] = job
+				}
 			} else {
 				// Merge the ingested metric with the base label set. On a collision the
-				// value of the label is stored in a label prefixed with the exported prefix.
-				for ln, lv := range baseLabels {
+				// label collision policy decides what happens to the scraped value. By
+				// default it is stored in a label prefixed with the exported prefix.
+				// Labels listed to be honored are merged as with honorLabels.
+				collided := false
+				for ln, lv := range sc.baseLabels {
+					if _, honor := sc.honorLabelNames[ln]; honor {
+						if _, ok := s.Metric[ln]; !ok {
+							s.Metric[ln] = lv
+						}
+						continue
+					}
 					if v, ok := s.Metric[ln]; ok && v != "" {
-						s.Metric[clientmodel.ExportedLabelPrefix+ln] = v
+						switch sc.labelCollisionPolicy {
+						case config.LabelCollisionDrop:
+							// The scraped value is overwritten below.
+						case config.LabelCollisionError:
+							if collisionErr == nil {
+								collisionErr = fmt.Errorf("scraped label %q of metric %s collides with a target label", ln, s.Metric)
+							}
+							collided = true
+						default:
+							s.Metric[clientmodel.ExportedLabelPrefix+ln] = v
+							exportedLabelCollisions.WithLabelValues(string(ln)).Inc()
+						}
 					}
 					s.Metric[ln] = lv
 				}
+				if collided {
+					continue
+				}
+			}
+			for ln, lv := range sc.defaultLabels {
+				if _, ok := s.Metric[ln]; !ok {
+					s.Metric[ln] = lv
+				}
 			}
 			// Avoid the copy in Relabel if there are no configs.
-			if len(metricRelabelConfigs) > 0 {
-				labels, err := Relabel(clientmodel.LabelSet(s.Metric), metricRelabelConfigs...)
+			if len(sc.metricRelabelConfigs) > 0 {
+				labels, err := Relabel(clientmodel.LabelSet(s.Metric), sc.metricRelabelConfigs...)
 				if err != nil {
 					log.Errorf("Error while relabeling metric %s of instance %s: %s", s.Metric, req.URL, err)
 					continue
@@ -481,12 +2389,353 @@ func (t *Target) scrape(sampleAppender storage.SampleAppender) (err error) {
 				}
 				s.Metric = clientmodel.Metric(labels)
 			}
-			sampleAppender.Append(s)
+			if sc.metricNameAllowlist != nil && !sc.metricNameAllowlist.MatchString(string(s.Metric[clientmodel.MetricNameLabel])) {
+				sc.counts.disallowed++
+				continue
+			}
+			transformValue(s, sc.valueTransforms)
+			if !inRange(s, sc.valueFilters) {
+				sc.counts.outOfRange++
+				continue
+			}
+			if keep, handled := handleNonFinite(s, sc.nonFiniteValueAction); handled {
+				sc.counts.nonFinite++
+				if !keep {
+					continue
+				}
+			}
+			if sc.timestampTolerance > 0 && s.Timestamp.After(maxTimestamp) {
+				if sc.timestampToleranceAction == config.TimestampClamp {
+					s.Timestamp = maxTimestamp
+				} else {
+					sc.counts.rejected++
+					continue
+				}
+			}
+			if sc.interner != nil {
+				sc.interner.internMetric(s.Metric)
+			}
+			if sc.seriesLimiter != nil && !sc.seriesLimiter.admit(s.Metric.Fingerprint()) {
+				continue
+			}
+			if sc.duplicateSampleHandling == "" {
+				if buffered {
+					pending = append(pending, s)
+					continue
+				}
+				if sc.fingerprints != nil {
+					sc.fingerprints[s.Metric.Fingerprint()] = struct{}{}
+				}
+				sampleAppender.Append(s)
+				continue
+			}
+			fp := s.Metric.Fingerprint()
+			if i, ok := index[fp]; ok {
+				switch sc.duplicateSampleHandling {
+				case config.DuplicateSampleFirstWins:
+					// Keep the sample seen first.
+				case config.DuplicateSampleError:
+					if dupErr == nil {
+						dupErr = fmt.Errorf("duplicate sample for series %s", s.Metric)
+					}
+				default:
+					deduped[i] = s
+				}
+				continue
+			}
+			index[fp] = len(deduped)
+			deduped = append(deduped, s)
+		}
+	}
+	if typeChecker != nil {
+		sc.counts.missingMetadata += uint64(typeChecker.dropped)
+	}
+	// Samples buffered for deduplication are discarded if the deadline passed
+	// while the response was processed. Streamed samples have already been
+	// appended. A response processed just in time is not affected, even if
+	// the deadline passed right after. A full ingestion channel is reported
+	// as such as it is the more specific cause.
+	if !deadlineTimer.Stop() && err != errIngestChannelFull {
+		deduped = nil
+		err = errScrapeDeadlineExceeded
+	}
+	// The same applies to a body exceeding the size limit, whose parse error
+	// would hide the cause.
+	if sc.body.exceeded {
+		deduped = nil
+		err = errBodySizeLimitExceeded
+	}
+	// A response with duplicate samples is rejected as a whole.
+	if dupErr != nil {
+		deduped = nil
+		if err == nil {
+			err = dupErr
+		}
+	}
+	// A response with a label collision or missing metadata is rejected as a
+	// whole.
+	rejected := collisionErr != nil || (typeChecker != nil && typeChecker.missingErr != nil)
+	if collisionErr != nil && err == nil {
+		err = collisionErr
+	}
+	if typeChecker != nil && typeChecker.missingErr != nil && err == nil {
+		err = typeChecker.missingErr
+	}
+	if rejected {
+		deduped = nil
+		pending = nil
+	}
+	for _, s := range pending {
+		if sc.fingerprints != nil {
+			sc.fingerprints[s.Metric.Fingerprint()] = struct{}{}
+		}
+		sampleAppender.Append(s)
+	}
+	for _, s := range deduped {
+		sampleAppender.Append(s)
+	}
+	if sc.fingerprints != nil && dupErr == nil && !rejected {
+		for fp := range index {
+			sc.fingerprints[fp] = struct{}{}
 		}
 	}
+	if err != nil {
+		select {
+		case <-t.scraperStopping:
+			return errScrapeAborted
+		default:
+		}
+	}
+	// Only remember the validators if the response was fully processed.
+	// Otherwise a 304 response would hide the missing samples.
+	if err == nil && conditional {
+		t.Lock()
+		t.lastETag = resp.Header.Get("ETag")
+		t.lastLastModified = resp.Header.Get("Last-Modified")
+		t.Unlock()
+	}
 	return err
 }
 
+// doWithDNSRetry sends the request. If resolving the target's host fails, the
+// request is retried after a short jittered backoff as long as the scrape
+// deadline is not exceeded.
+func (t *Target) doWithDNSRetry(req *http.Request, sc *scrapeContext) (*http.Response, error) {
+	for {
+		resp, err := sc.httpClient.Do(req)
+		if err == nil || !isDNSError(err) {
+			return resp, err
+		}
+		backoff := time.Duration(float64(dnsRetryBackoff) * (0.5 + rand.Float64()))
+		if time.Since(sc.start)+backoff >= sc.deadline {
+			return nil, err
+		}
+		log.Debugf("Retrying scrape of target %v after DNS error: %s", t, err)
+		// The body of the failed request may have been consumed.
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-t.scraperStopping:
+			timer.Stop()
+			return nil, err
+		}
+	}
+}
+
+// doWithServerErrorRetry sends the request like doWithDNSRetry. If retries of
+// server errors are enabled and the target answers with a 5xx status, the
+// request is retried once after the delay requested by the Retry-After header
+// of the response, as long as the scrape deadline is not exceeded. Without a
+// valid Retry-After header the request is retried right away.
+func (t *Target) doWithServerErrorRetry(req *http.Request, sc *scrapeContext) (*http.Response, error) {
+	resp, err := t.doWithDNSRetry(req, sc)
+	if err != nil || !sc.retryServerErrors || resp.StatusCode < 500 || resp.StatusCode > 599 {
+		return resp, err
+	}
+	delay := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if sc.elapsed()+delay >= sc.deadline {
+		return resp, nil
+	}
+	// The body of the first request may have been consumed.
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		req.Body = body
+	}
+
+	timer := time.NewTimer(delay)
+	select {
+	case <-timer.C:
+	case <-t.scraperStopping:
+		timer.Stop()
+		return resp, nil
+	}
+	log.Debugf("Retrying scrape of target %v after HTTP status %s", t, resp.Status)
+	resp.Body.Close()
+	return t.doWithDNSRetry(req, sc)
+}
+
+// doWithAuthChallenge sends the request like doWithServerErrorRetry. If the
+// target answers with status 401 Unauthorized and the AuthProvider of the
+// scrape can answer the challenge of the response, the request is sent once
+// more with the new authentication.
+func (t *Target) doWithAuthChallenge(req *http.Request, sc *scrapeContext) (*http.Response, error) {
+	resp, err := t.doWithServerErrorRetry(req, sc)
+	cp, ok := sc.authProvider.(httputil.ChallengeAuthProvider)
+	if err != nil || !ok || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// The body of the first request may have been consumed.
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		req.Body = body
+	}
+	retry, err := cp.ApplyChallenge(req, resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("error answering authentication challenge: %s", err)
+	}
+	if !retry {
+		return resp, nil
+	}
+	resp.Body.Close()
+	return t.doWithServerErrorRetry(req, sc)
+}
+
+// retryAfter returns the delay requested by the value of a Retry-After
+// header, which is either a number of seconds or an HTTP date. It returns
+// zero for missing or invalid values and dates in the past.
+func retryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	date, err := http.ParseTime(value)
+	if err != nil || date.Before(now) {
+		return 0
+	}
+	return date.Sub(now)
+}
+
+// newDNSServerResolver returns a resolver sending all queries to the DNS
+// server with the given address.
+func newDNSServerResolver(server string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// isDNSError returns true iff the error was caused by a failure to resolve a
+// host name.
+func isDNSError(err error) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	if oerr, ok := err.(*net.OpError); ok {
+		err = oerr.Err
+	}
+	_, ok := err.(*net.DNSError)
+	return ok
+}
+
+// parseTimestampHeader parses a timestamp given either in seconds since the
+// epoch or in any of the formats allowed for HTTP dates.
+func parseTimestampHeader(h string) (clientmodel.Timestamp, error) {
+	if secs, err := strconv.ParseFloat(h, 64); err == nil {
+		return clientmodel.TimestampFromUnixNano(int64(secs * float64(time.Second))), nil
+	}
+	t, err := http.ParseTime(h)
+	if err != nil {
+		return 0, err
+	}
+	return clientmodel.TimestampFromTime(t), nil
+}
+
+// supportedMediaTypes are the media types of the exposition formats that can
+// be processed by a scrape.
+var supportedMediaTypes = map[string]struct{}{
+	"application/vnd.google.protobuf": {},
+	"text/plain":                      {},
+	"application/json":                {},
+	openMetricsMediaType:              {},
+}
+
+// schemeRoundTripper sends requests through the round tripper registered for
+// the scheme of their URL.
+type schemeRoundTripper map[string]http.RoundTripper
+
+func (rt schemeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	next, ok := rt[req.URL.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported protocol scheme %q", req.URL.Scheme)
+	}
+	return next.RoundTrip(req)
+}
+
+// countingReader wraps an io.Reader and counts the bytes read from it. If
+// limit is positive, reading fails once more than limit bytes were read.
+type countingReader struct {
+	r        io.Reader
+	n        int64
+	limit    int64
+	exceeded bool
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	if r.exceeded {
+		return 0, errBodySizeLimitExceeded
+	}
+	// Read at most one byte beyond the limit to detect exceeding it.
+	if r.limit > 0 && int64(len(p)) > r.limit-r.n+1 {
+		p = p[:r.limit-r.n+1]
+	}
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.limit > 0 && r.n > r.limit {
+		r.exceeded = true
+		return n, errBodySizeLimitExceeded
+	}
+	return n, err
+}
+
+// Metadata returns the metadata of the metrics exposed by the target by
+// metric name, as collected in the last successful scrape.
+func (t *Target) Metadata() map[string]MetricMetadata {
+	t.RLock()
+	defer t.RUnlock()
+	md := make(map[string]MetricMetadata, len(t.metadata))
+	for name, m := range t.metadata {
+		md[name] = m
+	}
+	return md
+}
+
 // URL returns a copy of the target's URL.
 func (t *Target) URL() *url.URL {
 	t.RLock()
@@ -501,7 +2750,154 @@ func (t *Target) InstanceIdentifier() string {
 	return t.url.Host
 }
 
+// Equal returns true iff both targets scrape the same URL with the same
+// labels and scrape settings.
+func (t *Target) Equal(o *Target) bool {
+	if t == o {
+		return true
+	}
+	// Never hold the locks of both targets at the same time.
+	o.RLock()
+	var (
+		ourl                 = *o.url
+		obaseLabels          = o.baseLabels
+		odeadline            = o.deadline
+		oscrapeInterval      = o.scrapeInterval
+		ohonorLabels         = o.honorLabels
+		ohonorLabelsKeepJob  = o.honorLabelsKeepJob
+		ohonorLabelNames     = o.honorLabelNames
+		odefaultLabels       = o.defaultLabels
+		ometricRelabelConfig = o.metricRelabelConfigs
+		ovalueTransforms     = o.valueTransforms
+		ovalueFilters        = o.valueFilters
+		oallowlist           = o.metricNameAllowlist
+		oadditionalPaths     = o.additionalPaths
+		ohealthCheckPath     = o.healthCheckPath
+		omethod              = o.method
+		orequestBody         = o.requestBody
+		ocontentType         = o.requestBodyContentType
+		oencoding            = o.requestBodyEncoding
+	)
+	o.RUnlock()
+
+	t.RLock()
+	defer t.RUnlock()
+
+	return ourl.String() == t.url.String() &&
+		clientmodel.Metric(obaseLabels).Equal(clientmodel.Metric(t.baseLabels)) &&
+		odeadline == t.deadline &&
+		oscrapeInterval == t.scrapeInterval &&
+		ohonorLabels == t.honorLabels &&
+		ohonorLabelsKeepJob == t.honorLabelsKeepJob &&
+		reflect.DeepEqual(ohonorLabelNames, t.honorLabelNames) &&
+		clientmodel.Metric(odefaultLabels).Equal(clientmodel.Metric(t.defaultLabels)) &&
+		relabelConfigsEqual(ometricRelabelConfig, t.metricRelabelConfigs) &&
+		valueTransformsEqual(ovalueTransforms, t.valueTransforms) &&
+		valueFiltersEqual(ovalueFilters, t.valueFilters) &&
+		regexpsEqual(oallowlist, t.metricNameAllowlist) &&
+		reflect.DeepEqual(oadditionalPaths, t.additionalPaths) &&
+		ohealthCheckPath == t.healthCheckPath &&
+		omethod == t.method &&
+		orequestBody == t.requestBody &&
+		ocontentType == t.requestBodyContentType &&
+		oencoding == t.requestBodyEncoding
+}
+
+// ConfigHash returns a hash of the target's URL and the scrape configuration
+// it was last updated with. Settings only used for service discovery are not
+// part of the hash. The hash is stable across reloads of an unchanged
+// configuration file.
+func (t *Target) ConfigHash() uint64 {
+	t.RLock()
+	defer t.RUnlock()
+	return t.configHash
+}
+
+// scrapeConfigHash returns a hash of the URL and the scrape configuration
+// without its service discovery settings.
+func scrapeConfigHash(u *url.URL, cfg *config.ScrapeConfig) uint64 {
+	c := *cfg
+	c.TargetGroups = nil
+	c.DNSSDConfigs = nil
+	c.FileSDConfigs = nil
+	c.ConsulSDConfigs = nil
+	c.ServersetSDConfigs = nil
+	c.MarathonSDConfigs = nil
+	c.XXX = nil
+	b, err := yaml.Marshal(&c)
+	if err != nil {
+		log.Errorf("Error hashing scrape config %q: %s", cfg.JobName, err)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(u.String()))
+	h.Write([]byte{0})
+	h.Write(b)
+	return h.Sum64()
+}
+
+// regexpsEqual returns true iff both regular expressions are nil or have the
+// same source text.
+func regexpsEqual(a, b *regexp.Regexp) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.String() == b.String()
+}
+
+// relabelConfigsEqual returns true iff both lists contain equivalent relabel
+// configurations in the same order.
+func relabelConfigsEqual(a, b []*config.RelabelConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !relabelConfigEqual(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func relabelConfigEqual(a, b *config.RelabelConfig) bool {
+	if a == b {
+		return true
+	}
+	if a == nil || b == nil {
+		return false
+	}
+	if (a.Regex == nil) != (b.Regex == nil) {
+		return false
+	}
+	if a.Regex != nil && a.Regex.String() != b.Regex.String() {
+		return false
+	}
+	if len(a.SourceLabels) != len(b.SourceLabels) {
+		return false
+	}
+	for i := range a.SourceLabels {
+		if a.SourceLabels[i] != b.SourceLabels[i] {
+			return false
+		}
+	}
+	if len(a.SourceEnv) != len(b.SourceEnv) {
+		return false
+	}
+	for i := range a.SourceEnv {
+		if a.SourceEnv[i] != b.SourceEnv[i] {
+			return false
+		}
+	}
+	return a.Separator == b.Separator &&
+		a.FullMatch == b.FullMatch &&
+		a.Modulus == b.Modulus &&
+		a.TargetLabel == b.TargetLabel &&
+		a.Replacement == b.Replacement &&
+		a.Action == b.Action
+}
+
 // fullLabels returns the base labels plus internal labels defining the target.
+// The params of the URL are included so that updating a target with them
+// keeps them.
 func (t *Target) fullLabels() clientmodel.LabelSet {
 	t.RLock()
 	defer t.RUnlock()
@@ -509,6 +2905,9 @@ func (t *Target) fullLabels() clientmodel.LabelSet {
 	for ln, lv := range t.baseLabels {
 		lset[ln] = lv
 	}
+	for k, vs := range t.url.Query() {
+		lset[clientmodel.LabelName(clientmodel.ParamLabelPrefix+k)] = clientmodel.LabelValue(vs[0])
+	}
 	lset[clientmodel.MetricsPathLabel] = clientmodel.LabelValue(t.url.Path)
 	lset[clientmodel.AddressLabel] = clientmodel.LabelValue(t.url.Host)
 	lset[clientmodel.SchemeLabel] = clientmodel.LabelValue(t.url.Scheme)
@@ -537,40 +2936,190 @@ func (t *Target) MetaLabels() clientmodel.LabelSet {
 	return lset
 }
 
-func recordScrapeHealth(
-	sampleAppender storage.SampleAppender,
-	timestamp clientmodel.Timestamp,
-	baseLabels clientmodel.LabelSet,
-	health TargetHealth,
-	scrapeDuration time.Duration,
-) {
-	healthMetric := make(clientmodel.Metric, len(baseLabels)+1)
-	durationMetric := make(clientmodel.Metric, len(baseLabels)+1)
+// Annotations returns a copy of the target's annotations.
+func (t *Target) Annotations() map[string]string {
+	t.RLock()
+	defer t.RUnlock()
+	annotations := make(map[string]string, len(t.annotations))
+	for k, v := range t.annotations {
+		annotations[k] = v
+	}
+	return annotations
+}
+
+// Annotation returns the value of the target's annotation with the given key
+// and whether it is set.
+func (t *Target) Annotation(key string) (string, bool) {
+	t.RLock()
+	defer t.RUnlock()
+	v, ok := t.annotations[key]
+	return v, ok
+}
+
+// DiscoveredLabels returns a copy of the target's labels as discovered,
+// before any relabeling was applied.
+func (t *Target) DiscoveredLabels() clientmodel.LabelSet {
+	return t.MetaLabels()
+}
+
+// Labels returns a copy of the target's labels resulting from relabeling the
+// discovered labels, including internal labels.
+func (t *Target) Labels() clientmodel.LabelSet {
+	t.RLock()
+	defer t.RUnlock()
+	lset := make(clientmodel.LabelSet, len(t.labels))
+	for ln, lv := range t.labels {
+		lset[ln] = lv
+	}
+	return lset
+}
+
+// SyntheticMetricNames returns the names of the synthetic metrics recorded
+// for each scrape of the target with its current configuration, in the order
+// they are appended. Some of them are only recorded once they apply: the
+// certificate expiry for HTTPS targets that presented a certificate, the
+// last success time after a successful scrape, and the counters of the series
+// and sample rate limits once they dropped samples.
+func (t *Target) SyntheticMetricNames() []string {
+	t.RLock()
+	defer t.RUnlock()
 
-	healthMetric[clientmodel.MetricNameLabel] = clientmodel.LabelValue(scrapeHealthMetricName)
-	durationMetric[clientmodel.MetricNameLabel] = clientmodel.LabelValue(scrapeDurationMetricName)
+	names := []clientmodel.LabelValue{
+		scrapeHealthMetricName,
+		scrapeDurationMetricName,
+		scrapeBodySizeMetricName,
+		scrapeTimeoutMetricName,
+	}
+	if t.url.Scheme == "https" {
+		names = append(names, scrapeTLSCertNotAfterMetricName)
+	}
+	names = append(names, scrapeLastSuccessMetricName)
+	if t.labelCardinalityMetric {
+		names = append(names, scrapeLabelCardinalityMetricName)
+	}
+	if t.seriesLimiter != nil {
+		names = append(names, scrapeSeriesCappedMetricName)
+	}
+	if t.sampleRateLimiter != nil {
+		names = append(names, scrapeSamplesThrottledMetricName)
+	}
+	if t.scrapeTimingMetrics {
+		names = append(names, scrapeDNSLookupMetricName, scrapeConnectMetricName, scrapeTLSHandshakeMetricName, scrapeFirstByteMetricName)
+	}
+	if t.retainSeries {
+		names = append(names, scrapeSeriesAddedMetricName, scrapeSeriesRemovedMetricName)
+	}
+	if t.scrapeFormatInfo {
+		names = append(names, scrapeFormatInfoMetricName)
+	}
 
-	for label, value := range baseLabels {
-		healthMetric[label] = value
-		durationMetric[label] = value
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		if suffixed, ok := suffixedSyntheticNames[name]; ok && t.syntheticNameSuffixes {
+			name = suffixed
+		}
+		result = append(result, string(name))
 	}
+	return result
+}
 
+// suffixedSyntheticNames maps the names of synthetic metrics lacking the unit
+// or counter suffix of the OpenMetrics conventions to names with the suffix.
+// Names that are missing are not changed.
+var suffixedSyntheticNames = map[clientmodel.LabelValue]clientmodel.LabelValue{
+	scrapeTLSCertNotAfterMetricName:  scrapeTLSCertNotAfterMetricName + "_seconds",
+	scrapeSeriesCappedMetricName:     scrapeSeriesCappedMetricName + "_total",
+	scrapeSamplesThrottledMetricName: scrapeSamplesThrottledMetricName + "_total",
+}
+
+// scrapeReport describes a finished scrape for recording its synthetic
+// metrics. Optional metrics are only recorded if their fields are set.
+type scrapeReport struct {
+	timestamp      clientmodel.Timestamp
+	baseLabels     clientmodel.LabelSet
+	health         TargetHealth
+	duration       time.Duration
+	timeout        time.Duration
+	bodySize       int64
+	peerCertExpiry time.Time
+	lastSuccess    time.Time
+	labelNames     map[clientmodel.LabelName]struct{}
+	// seriesCapped and samplesThrottled are the samples dropped by the
+	// series and sample rate limits.
+	seriesCapped     uint64
+	samplesThrottled uint64
+	timings          *ScrapeTimings
+	format           ScrapeFormat
+	changes          *seriesChanges
+	// nameSuffixes selects the names of the synthetic metrics following
+	// the OpenMetrics conventions.
+	nameSuffixes bool
+}
+
+func recordScrapeHealth(sampleAppender storage.SampleAppender, r scrapeReport) {
 	healthValue := clientmodel.SampleValue(0)
-	if health == HealthGood {
+	if r.health == HealthGood {
 		healthValue = clientmodel.SampleValue(1)
 	}
 
-	healthSample := &clientmodel.Sample{
-		Metric:    healthMetric,
-		Timestamp: timestamp,
-		Value:     healthValue,
-	}
-	durationSample := &clientmodel.Sample{
-		Metric:    durationMetric,
-		Timestamp: timestamp,
-		Value:     clientmodel.SampleValue(float64(scrapeDuration) / float64(time.Second)),
+	appendSample := func(name clientmodel.LabelValue, value clientmodel.SampleValue) {
+		if suffixed, ok := suffixedSyntheticNames[name]; ok && r.nameSuffixes {
+			name = suffixed
+		}
+		metric := make(clientmodel.Metric, len(r.baseLabels)+1)
+		metric[clientmodel.MetricNameLabel] = name
+		for ln, lv := range r.baseLabels {
+			metric[ln] = lv
+		}
+		sampleAppender.Append(&clientmodel.Sample{
+			Metric:    metric,
+			Timestamp: r.timestamp,
+			Value:     value,
+		})
 	}
 
-	sampleAppender.Append(healthSample)
-	sampleAppender.Append(durationSample)
+	appendSample(scrapeHealthMetricName, healthValue)
+	appendSample(scrapeDurationMetricName, clientmodel.SampleValue(float64(r.duration)/float64(time.Second)))
+	appendSample(scrapeBodySizeMetricName, clientmodel.SampleValue(r.bodySize))
+	appendSample(scrapeTimeoutMetricName, clientmodel.SampleValue(float64(r.timeout)/float64(time.Second)))
+	if !r.peerCertExpiry.IsZero() {
+		appendSample(scrapeTLSCertNotAfterMetricName, clientmodel.SampleValue(r.peerCertExpiry.Unix()))
+	}
+	if !r.lastSuccess.IsZero() {
+		appendSample(scrapeLastSuccessMetricName, clientmodel.SampleValue(float64(r.lastSuccess.UnixNano())/float64(time.Second)))
+	}
+	if r.labelNames != nil {
+		appendSample(scrapeLabelCardinalityMetricName, clientmodel.SampleValue(len(r.labelNames)))
+	}
+	// The counter only appears once the series limit dropped samples.
+	if r.seriesCapped > 0 {
+		appendSample(scrapeSeriesCappedMetricName, clientmodel.SampleValue(r.seriesCapped))
+	}
+	// Likewise once the sample rate limit dropped samples.
+	if r.samplesThrottled > 0 {
+		appendSample(scrapeSamplesThrottledMetricName, clientmodel.SampleValue(r.samplesThrottled))
+	}
+	if r.timings != nil {
+		appendSample(scrapeDNSLookupMetricName, clientmodel.SampleValue(r.timings.DNSLookup.Seconds()))
+		appendSample(scrapeConnectMetricName, clientmodel.SampleValue(r.timings.Connect.Seconds()))
+		appendSample(scrapeTLSHandshakeMetricName, clientmodel.SampleValue(r.timings.TLSHandshake.Seconds()))
+		appendSample(scrapeFirstByteMetricName, clientmodel.SampleValue(r.timings.FirstByte.Seconds()))
+	}
+	if r.changes != nil {
+		appendSample(scrapeSeriesAddedMetricName, clientmodel.SampleValue(r.changes.added))
+		appendSample(scrapeSeriesRemovedMetricName, clientmodel.SampleValue(r.changes.removed))
+	}
+	if r.format != "" {
+		metric := make(clientmodel.Metric, len(r.baseLabels)+2)
+		for ln, lv := range r.baseLabels {
+			metric[ln] = lv
+		}
+		metric[clientmodel.MetricNameLabel] = scrapeFormatInfoMetricName
+		metric[scrapeFormatLabel] = clientmodel.LabelValue(r.format)
+		sampleAppender.Append(&clientmodel.Sample{
+			Metric:    metric,
+			Timestamp: r.timestamp,
+			Value:     1,
+		})
+	}
 }
@@ -14,23 +14,44 @@
 package retrieval
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/matttproud/golang_protobuf_extensions/pbutil"
+
 	clientmodel "github.com/prometheus/client_golang/model"
+	dto "github.com/prometheus/client_model/go"
 
 	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/metric"
 	"github.com/prometheus/prometheus/util/httputil"
 )
 
@@ -47,11 +68,119 @@ func TestBaseLabels(t *testing.T) {
 	}
 }
 
+func TestTargetEqual(t *testing.T) {
+	newTarget := func() *Target {
+		t := newTestTarget("example.com:80", 10*time.Millisecond, clientmodel.LabelSet{"job": "some_job"})
+		t.metricRelabelConfigs = []*config.RelabelConfig{
+			{
+				SourceLabels: clientmodel.LabelNames{"__name__"},
+				Regex:        &config.Regexp{*regexp.MustCompile(".*drop.*")},
+				Separator:    ";",
+				Action:       config.RelabelDrop,
+			},
+		}
+		return t
+	}
+
+	if a, b := newTarget(), newTarget(); !a.Equal(b) || !b.Equal(a) {
+		t.Errorf("Expected targets %v and %v to be equal", a, b)
+	}
+	a := newTarget()
+	if !a.Equal(a) {
+		t.Errorf("Expected target %v to be equal to itself", a)
+	}
+
+	a, b := newTarget(), newTarget()
+	b.baseLabels["foo"] = "bar"
+	if a.Equal(b) || b.Equal(a) {
+		t.Errorf("Expected targets with different base labels not to be equal")
+	}
+
+	a, b = newTarget(), newTarget()
+	b.metricRelabelConfigs[0].Regex = &config.Regexp{*regexp.MustCompile(".*keep.*")}
+	if a.Equal(b) || b.Equal(a) {
+		t.Errorf("Expected targets with different metric relabel configs not to be equal")
+	}
+
+	a, b = newTarget(), newTarget()
+	b.scrapeInterval = time.Minute
+	if a.Equal(b) || b.Equal(a) {
+		t.Errorf("Expected targets with different scrape intervals not to be equal")
+	}
+}
+
+func TestTargetConfigHash(t *testing.T) {
+	newTarget := func(yml string) *Target {
+		cfg, err := config.Load(yml)
+		if err != nil {
+			t.Fatal(err)
+		}
+		target, err := NewTarget(cfg.ScrapeConfigs[0], clientmodel.LabelSet{
+			clientmodel.SchemeLabel:      "http",
+			clientmodel.AddressLabel:     "example.com:80",
+			clientmodel.MetricsPathLabel: "/metrics",
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return target
+	}
+
+	base := `
+scrape_configs:
+- job_name: test
+  scrape_timeout: 5s
+  basic_auth:
+    username: user
+    password: secret
+  metric_relabel_configs:
+  - source_labels: [__name__]
+    regex: .*drop.*
+    action: drop
+  target_groups:
+  - targets: ['example.com:80']
+`
+	commented := `
+# A scrape config with comments and different target groups.
+scrape_configs:
+- job_name: test
+  scrape_timeout: 5s  # Below the interval.
+  basic_auth:
+    username: user
+    password: secret
+  metric_relabel_configs:
+  # Drop debugging metrics.
+  - source_labels: [__name__]
+    regex: .*drop.*
+    action: drop
+  target_groups:
+  - targets: ['example.com:80', 'example.org:80']
+`
+	a := newTarget(base)
+	if a.ConfigHash() != newTarget(base).ConfigHash() {
+		t.Errorf("Expected equal configs to have equal hashes")
+	}
+	if a.ConfigHash() != newTarget(commented).ConfigHash() {
+		t.Errorf("Expected comments and target groups not to change the hash")
+	}
+	changed := []string{
+		strings.Replace(base, "scrape_timeout: 5s", "scrape_timeout: 6s", 1),
+		strings.Replace(base, "password: secret", "password: other", 1),
+		strings.Replace(base, ".*drop.*", ".*debug.*", 1),
+	}
+	for i, yml := range changed {
+		if a.ConfigHash() == newTarget(yml).ConfigHash() {
+			t.Errorf("%d. Expected changed config to change the hash", i)
+		}
+	}
+}
+
 func TestOverwriteLabels(t *testing.T) {
 	type test struct {
 		metric       string
 		resultNormal clientmodel.Metric
 		resultHonor  clientmodel.Metric
+		resultDrop   clientmodel.Metric
 	}
 	var tests []test
 
@@ -80,6 +209,10 @@ func TestOverwriteLabels(t *testing.T) {
 				clientmodel.MetricNameLabel: "foo",
 				clientmodel.InstanceLabel:   addr,
 			},
+			resultDrop: clientmodel.Metric{
+				clientmodel.MetricNameLabel: "foo",
+				clientmodel.InstanceLabel:   addr,
+			},
 		},
 		{
 			metric: `foo{instance=""}`,
@@ -90,6 +223,10 @@ func TestOverwriteLabels(t *testing.T) {
 			resultHonor: clientmodel.Metric{
 				clientmodel.MetricNameLabel: "foo",
 			},
+			resultDrop: clientmodel.Metric{
+				clientmodel.MetricNameLabel: "foo",
+				clientmodel.InstanceLabel:   addr,
+			},
 		},
 		{
 			metric: `foo{instance="other_instance"}`,
@@ -102,6 +239,10 @@ func TestOverwriteLabels(t *testing.T) {
 				clientmodel.MetricNameLabel: "foo",
 				clientmodel.InstanceLabel:   "other_instance",
 			},
+			resultDrop: clientmodel.Metric{
+				clientmodel.MetricNameLabel: "foo",
+				clientmodel.InstanceLabel:   addr,
+			},
 		},
 	}
 
@@ -131,392 +272,4789 @@ func TestOverwriteLabels(t *testing.T) {
 		}
 
 	}
-}
-func TestTargetScrapeUpdatesState(t *testing.T) {
-	testTarget := newTestTarget("bad schema", 0, nil)
 
-	testTarget.scrape(nopAppender{})
-	if testTarget.status.Health() != HealthBad {
-		t.Errorf("Expected target state %v, actual: %v", HealthBad, testTarget.status.Health())
+	// The prefix policy behaves like the default.
+	target.honorLabels = false
+	target.labelCollisionPolicy = config.LabelCollisionPrefix
+	app = &collectResultAppender{}
+	if err := target.scrape(app); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, test := range tests {
+		if !reflect.DeepEqual(app.result[i].Metric, test.resultNormal) {
+			t.Errorf("Error comparing %q:\nExpected:\n%s\nGot:\n%s\n", test.metric, test.resultNormal, app.result[i].Metric)
+		}
+	}
+
+	target.labelCollisionPolicy = config.LabelCollisionDrop
+	app = &collectResultAppender{}
+	if err := target.scrape(app); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, test := range tests {
+		if !reflect.DeepEqual(app.result[i].Metric, test.resultDrop) {
+			t.Errorf("Error comparing %q:\nExpected:\n%s\nGot:\n%s\n", test.metric, test.resultDrop, app.result[i].Metric)
+		}
+	}
+
+	// Colliding samples fail the scrape and none of the scraped samples are
+	// appended.
+	target.labelCollisionPolicy = config.LabelCollisionError
+	app = &collectResultAppender{}
+	if err := target.scrape(app); err == nil {
+		t.Fatal("Expected error on label collision")
+	}
+
+	if name := app.result[0].Metric[clientmodel.MetricNameLabel]; name != scrapeHealthMetricName {
+		t.Errorf("Expected scraped samples to be dropped, got %s", app.result[0].Metric)
 	}
 }
 
-func TestTargetScrapeWithFullChannel(t *testing.T) {
+func TestExportedLabelCollisions(t *testing.T) {
 	server := httptest.NewServer(
 		http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
 				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
-				for i := 0; i < 2*ingestedSamplesCap; i++ {
-					w.Write([]byte(
-						fmt.Sprintf("test_metric_%d{foo=\"bar\"} 123.456\n", i),
-					))
-				}
+				w.Write([]byte(`foo{instance="other_instance"} 1` + "\n"))
+				w.Write([]byte(`bar{instance=""} 1` + "\n"))
 			},
 		),
 	)
 	defer server.Close()
 
-	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{"dings": "bums"})
+	collisions := func() float64 {
+		var m dto.Metric
+		exportedLabelCollisions.WithLabelValues(string(clientmodel.InstanceLabel)).Write(&m)
+		return m.GetCounter().GetValue()
+	}
 
-	testTarget.scrape(slowAppender{})
-	if testTarget.status.Health() != HealthBad {
-		t.Errorf("Expected target state %v, actual: %v", HealthBad, testTarget.status.Health())
+	target := newTestTarget(server.URL, 10*time.Millisecond, nil)
+
+	before := collisions()
+	if err := target.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
 	}
-	if testTarget.status.LastError() != errIngestChannelFull {
-		t.Errorf("Expected target error %q, actual: %q", errIngestChannelFull, testTarget.status.LastError())
+	if got := collisions() - before; got != 1 {
+		t.Errorf("Expected 1 collision, got %v", got)
+	}
+
+	// Honored labels never collide.
+	target.honorLabels = true
+	before = collisions()
+	if err := target.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := collisions() - before; got != 0 {
+		t.Errorf("Expected no collisions, got %v", got)
 	}
 }
 
-func TestTargetScrapeMetricRelabelConfigs(t *testing.T) {
+func TestHonorLabelNames(t *testing.T) {
 	server := httptest.NewServer(
 		http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
 				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
-				w.Write([]byte("test_metric_drop 0\n"))
-				w.Write([]byte("test_metric_relabel 1\n"))
+				w.Write([]byte(`foo{instance="other_instance",job="other_job"} 1` + "\n"))
+				w.Write([]byte(`bar{} 1` + "\n"))
 			},
 		),
 	)
 	defer server.Close()
-	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{})
-	testTarget.metricRelabelConfigs = []*config.RelabelConfig{
+	addr := clientmodel.LabelValue(strings.Split(server.URL, "://")[1])
+
+	target := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{clientmodel.JobLabel: "test_job"})
+	target.honorLabelNames = map[clientmodel.LabelName]struct{}{clientmodel.InstanceLabel: {}}
+	app := &collectResultAppender{}
+	if err := target.scrape(app); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the scraped instance label is honored.
+	expected := []clientmodel.Metric{
 		{
-			SourceLabels: clientmodel.LabelNames{"__name__"},
-			Regex:        &config.Regexp{*regexp.MustCompile(".*drop.*")},
-			Action:       config.RelabelDrop,
+			clientmodel.MetricNameLabel:                            "foo",
+			clientmodel.InstanceLabel:                              "other_instance",
+			clientmodel.JobLabel:                                   "test_job",
+			clientmodel.ExportedLabelPrefix + clientmodel.JobLabel: "other_job",
 		},
 		{
-			SourceLabels: clientmodel.LabelNames{"__name__"},
-			Regex:        &config.Regexp{*regexp.MustCompile(".*(relabel|up).*")},
-			TargetLabel:  "foo",
-			Replacement:  "bar",
-			Action:       config.RelabelReplace,
+			clientmodel.MetricNameLabel: "bar",
+			clientmodel.InstanceLabel:   addr,
+			clientmodel.JobLabel:        "test_job",
 		},
 	}
+	// Metric families are not ingested in a defined order.
+	got := map[clientmodel.LabelValue]clientmodel.Metric{}
+	for _, s := range app.result {
+		got[s.Metric[clientmodel.MetricNameLabel]] = s.Metric
+	}
+	for _, m := range expected {
+		if !reflect.DeepEqual(got[m[clientmodel.MetricNameLabel]], m) {
+			t.Errorf("Expected metric %s, got %s", m, got[m[clientmodel.MetricNameLabel]])
+		}
+	}
+}
 
-	appender := &collectResultAppender{}
-	testTarget.scrape(appender)
+func TestTargetScrapeIPv6(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %s", err)
+	}
+	var requested *url.URL
+	server := httptest.NewUnstartedServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				requested = &url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path}
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	server.Listener.Close()
+	server.Listener = ln
+	server.Start()
+	defer server.Close()
 
-	// Remove variables part of result.
-	for _, sample := range appender.result {
-		sample.Timestamp = 0
-		sample.Value = 0
+	addr := ln.Addr().String()
+	if !strings.HasPrefix(addr, "[::1]:") {
+		t.Fatalf("Expected bracketed IPv6 listener address, got %s", addr)
+	}
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	app := &collectResultAppender{}
+	if err := testTarget.scrape(app); err != nil {
+		t.Fatal(err)
 	}
 
-	expected := []*clientmodel.Sample{
-		{
-			Metric: clientmodel.Metric{
-				clientmodel.MetricNameLabel: "test_metric_relabel",
-				"foo": "bar",
-				clientmodel.InstanceLabel: clientmodel.LabelValue(testTarget.url.Host),
+	if expected := "http://" + addr + "/metrics"; requested == nil || requested.String() != expected {
+		t.Errorf("Expected request to %s, got %v", expected, requested)
+	}
+	for _, s := range app.result {
+		if got := s.Metric[clientmodel.InstanceLabel]; got != clientmodel.LabelValue(addr) {
+			t.Errorf("Expected instance label %s, got %s", addr, got)
+		}
+	}
+}
+
+func TestHonorLabelsKeepJob(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte(`foo{instance="other_instance",job="other_job"} 1` + "\n"))
+				w.Write([]byte(`bar{} 1` + "\n"))
 			},
-			Timestamp: 0,
-			Value:     0,
-		},
-		// The metrics about the scrape are not affected.
+		),
+	)
+	defer server.Close()
+	addr := clientmodel.LabelValue(strings.Split(server.URL, "://")[1])
+
+	scenarios := []struct {
+		keepJob  bool
+		expected []clientmodel.Metric
+	}{
 		{
-			Metric: clientmodel.Metric{
-				clientmodel.MetricNameLabel: scrapeHealthMetricName,
-				clientmodel.InstanceLabel:   clientmodel.LabelValue(testTarget.url.Host),
+			keepJob: false,
+			expected: []clientmodel.Metric{
+				{
+					clientmodel.MetricNameLabel: "foo",
+					clientmodel.InstanceLabel:   "other_instance",
+					clientmodel.JobLabel:        "other_job",
+				},
+				{
+					clientmodel.MetricNameLabel: "bar",
+					clientmodel.InstanceLabel:   addr,
+					clientmodel.JobLabel:        "test_job",
+				},
 			},
-			Timestamp: 0,
-			Value:     0,
 		},
 		{
-			Metric: clientmodel.Metric{
-				clientmodel.MetricNameLabel: scrapeDurationMetricName,
-				clientmodel.InstanceLabel:   clientmodel.LabelValue(testTarget.url.Host),
+			// Only the job label is not honored.
+			keepJob: true,
+			expected: []clientmodel.Metric{
+				{
+					clientmodel.MetricNameLabel:                            "foo",
+					clientmodel.InstanceLabel:                              "other_instance",
+					clientmodel.JobLabel:                                   "test_job",
+					clientmodel.ExportedLabelPrefix + clientmodel.JobLabel: "other_job",
+				},
+				{
+					clientmodel.MetricNameLabel: "bar",
+					clientmodel.InstanceLabel:   addr,
+					clientmodel.JobLabel:        "test_job",
+				},
 			},
-			Timestamp: 0,
-			Value:     0,
 		},
 	}
+	for i, s := range scenarios {
+		target := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{clientmodel.JobLabel: "test_job"})
+		target.honorLabels = true
+		target.honorLabelsKeepJob = s.keepJob
+		app := &collectResultAppender{}
+		if err := target.scrape(app); err != nil {
+			t.Fatal(err)
+		}
 
-	if !appender.result.Equal(expected) {
-		t.Fatalf("Expected and actual samples not equal. Expected: %s, actual: %s", expected, appender.result)
+		// Metric families are not ingested in a defined order.
+		got := map[clientmodel.LabelValue]clientmodel.Metric{}
+		for _, s := range app.result {
+			got[s.Metric[clientmodel.MetricNameLabel]] = s.Metric
+		}
+		for _, m := range s.expected {
+			if !reflect.DeepEqual(got[m[clientmodel.MetricNameLabel]], m) {
+				t.Errorf("%d. Expected metric %s, got %s", i, m, got[m[clientmodel.MetricNameLabel]])
+			}
+		}
 	}
-
 }
 
-func TestTargetRecordScrapeHealth(t *testing.T) {
-	testTarget := newTestTarget("example.url:80", 0, clientmodel.LabelSet{clientmodel.JobLabel: "testjob"})
-
-	now := clientmodel.Now()
-	appender := &collectResultAppender{}
-	testTarget.status.setLastError(nil)
-	recordScrapeHealth(appender, now, testTarget.BaseLabels(), testTarget.status.Health(), 2*time.Second)
+func TestTargetScrapeDefaultLabels(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte(`foo{env="staging"} 1` + "\n"))
+				w.Write([]byte(`bar 1` + "\n"))
+			},
+		),
+	)
+	defer server.Close()
+	addr := clientmodel.LabelValue(strings.Split(server.URL, "://")[1])
 
-	result := appender.result
+	for _, honorLabels := range []bool{false, true} {
+		target := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{clientmodel.JobLabel: "test_job"})
+		target.honorLabels = honorLabels
+		target.defaultLabels = clientmodel.LabelSet{"env": "production", clientmodel.JobLabel: "default_job"}
+		app := &collectResultAppender{}
+		if err := target.scrape(app); err != nil {
+			t.Fatal(err)
+		}
 
-	if len(result) != 2 {
-		t.Fatalf("Expected two samples, got %d", len(result))
+		expected := []clientmodel.Metric{
+			{
+				clientmodel.MetricNameLabel: "foo",
+				clientmodel.InstanceLabel:   addr,
+				clientmodel.JobLabel:        "test_job",
+				"env":                       "staging",
+			},
+			{
+				clientmodel.MetricNameLabel: "bar",
+				clientmodel.InstanceLabel:   addr,
+				clientmodel.JobLabel:        "test_job",
+				"env":                       "production",
+			},
+		}
+		// Metric families are not ingested in a defined order.
+		got := map[clientmodel.LabelValue]clientmodel.Metric{}
+		for _, s := range app.result {
+			got[s.Metric[clientmodel.MetricNameLabel]] = s.Metric
+		}
+		for _, m := range expected {
+			if !reflect.DeepEqual(got[m[clientmodel.MetricNameLabel]], m) {
+				t.Errorf("honor labels %t: expected metric %s, got %s", honorLabels, m, got[m[clientmodel.MetricNameLabel]])
+			}
+		}
 	}
+}
 
-	actual := result[0]
-	expected := &clientmodel.Sample{
-		Metric: clientmodel.Metric{
-			clientmodel.MetricNameLabel: scrapeHealthMetricName,
-			clientmodel.InstanceLabel:   "example.url:80",
-			clientmodel.JobLabel:        "testjob",
-		},
-		Timestamp: now,
-		Value:     1,
-	}
+func TestTargetScrapeLabelCardinality(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte(`foo{method="GET",code="200"} 1` + "\n"))
+				w.Write([]byte(`foo{method="POST",code="500"} 1` + "\n"))
+				w.Write([]byte(`bar{code="200",path="/"} 1` + "\n"))
+				w.Write([]byte(`baz 1` + "\n"))
+			},
+		),
+	)
+	defer server.Close()
 
-	if !actual.Equal(expected) {
-		t.Fatalf("Expected and actual samples not equal. Expected: %v, actual: %v", expected, actual)
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{clientmodel.JobLabel: "test_job"})
+	testTarget.labelCardinalityMetric = true
+	app := &collectResultAppender{}
+	if err := testTarget.scrape(app); err != nil {
+		t.Fatal(err)
 	}
 
-	actual = result[1]
-	expected = &clientmodel.Sample{
-		Metric: clientmodel.Metric{
-			clientmodel.MetricNameLabel: scrapeDurationMetricName,
-			clientmodel.InstanceLabel:   "example.url:80",
-			clientmodel.JobLabel:        "testjob",
-		},
-		Timestamp: now,
-		Value:     2.0,
+	// The target labels are not counted.
+	var cardinality clientmodel.Samples
+	for _, s := range app.result {
+		if s.Metric[clientmodel.MetricNameLabel] == scrapeLabelCardinalityMetricName {
+			cardinality = append(cardinality, s)
+		}
 	}
-
-	if !actual.Equal(expected) {
-		t.Fatalf("Expected and actual samples not equal. Expected: %v, actual: %v", expected, actual)
+	if len(cardinality) != 1 {
+		t.Fatalf("Expected one %s sample, got %v", scrapeLabelCardinalityMetricName, cardinality)
+	}
+	if cardinality[0].Value != 3 {
+		t.Errorf("Expected 3 distinct label names, got %v", cardinality[0].Value)
+	}
+	if !cardinality[0].Metric.Equal(clientmodel.Metric{
+		clientmodel.MetricNameLabel: scrapeLabelCardinalityMetricName,
+		clientmodel.InstanceLabel:   clientmodel.LabelValue(testTarget.InstanceIdentifier()),
+		clientmodel.JobLabel:        "test_job",
+	}) {
+		t.Errorf("Expected the base labels, got %s", cardinality[0].Metric)
 	}
 }
 
-func TestTargetScrapeTimeout(t *testing.T) {
-	signal := make(chan bool, 1)
+func TestTargetAnnotations(t *testing.T) {
 	server := httptest.NewServer(
 		http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
-				<-signal
 				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
-				w.Write([]byte{})
+				w.Write([]byte("test_metric 1\n"))
 			},
 		),
 	)
 	defer server.Close()
 
-	testTarget := newTestTarget(server.URL, 50*time.Millisecond, clientmodel.LabelSet{})
-
-	appender := nopAppender{}
-
-	// scrape once without timeout
-	signal <- true
-	if err := testTarget.scrape(appender); err != nil {
+	cfg := &config.ScrapeConfig{
+		ScrapeInterval: config.Duration(time.Second),
+		ScrapeTimeout:  config.Duration(time.Second),
+		Annotations:    map[string]string{"team": "storage", "route": "pager"},
+	}
+	testTarget, err := NewTarget(cfg, clientmodel.LabelSet{
+		clientmodel.SchemeLabel:      "http",
+		clientmodel.AddressLabel:     clientmodel.LabelValue(strings.TrimPrefix(server.URL, "http://")),
+		clientmodel.MetricsPathLabel: "/metrics",
+	}, nil)
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	// let the deadline lapse
-	time.Sleep(55 * time.Millisecond)
+	// The target holds a copy of the configured annotations.
+	cfg.Annotations["team"] = "other"
+	if got := testTarget.Annotations(); !reflect.DeepEqual(got, map[string]string{"team": "storage", "route": "pager"}) {
+		t.Errorf("Unexpected annotations %v", got)
+	}
+	testTarget.Annotations()["team"] = "other"
+	if v, ok := testTarget.Annotation("team"); !ok || v != "storage" {
+		t.Errorf("Expected annotation team=storage, got %q", v)
+	}
+	if _, ok := testTarget.Annotation("missing"); ok {
+		t.Errorf("Expected missing annotation not to be set")
+	}
 
-	// now scrape again
-	signal <- true
-	if err := testTarget.scrape(appender); err != nil {
+	app := &collectResultAppender{}
+	if err := testTarget.scrape(app); err != nil {
 		t.Fatal(err)
 	}
-
+	for _, s := range app.result {
+		for ln, lv := range s.Metric {
+			if ln == "team" || ln == "route" || lv == "storage" || lv == "pager" {
+				t.Errorf("Expected annotations not to be attached to sample %s", s.Metric)
+			}
+		}
+	}
+}
+
+func TestFingerprintOverlaps(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric_1 1\n"))
+				w.Write([]byte("test_metric_2 2\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	var targets []*Target
+	for _, instance := range []clientmodel.LabelValue{"same", "same", "other"} {
+		target := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{clientmodel.InstanceLabel: instance})
+		target.RetainFingerprints(true)
+		if err := target.scrape(nopAppender{}); err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, target)
+	}
+
+	if got := len(targets[0].LastFingerprints()); got != 2 {
+		t.Errorf("Expected 2 retained fingerprints, got %d", got)
+	}
+	if got := FingerprintOverlaps(targets); got != 2 {
+		t.Errorf("Expected 2 overlapping fingerprints, got %d", got)
+	}
+	if got := FingerprintOverlaps(targets[1:]); got != 0 {
+		t.Errorf("Expected no overlapping fingerprints, got %d", got)
+	}
+
+	targets[0].RetainFingerprints(false)
+	if got := FingerprintOverlaps(targets); got != 0 {
+		t.Errorf("Expected no overlapping fingerprints, got %d", got)
+	}
+}
+
+func TestTargetScrapeUpdatesState(t *testing.T) {
+	testTarget := newTestTarget("bad schema", 0, nil)
+
+	testTarget.scrape(nopAppender{})
+	if testTarget.status.Health() != HealthBad {
+		t.Errorf("Expected target state %v, actual: %v", HealthBad, testTarget.status.Health())
+	}
+}
+
+func TestTargetInitialHealth(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeInterval: config.Duration(time.Millisecond),
+		ScrapeTimeout:  config.Duration(time.Second),
+	}
+	testTarget, err := NewTarget(cfg, clientmodel.LabelSet{
+		clientmodel.SchemeLabel:      "http",
+		clientmodel.AddressLabel:     clientmodel.LabelValue(strings.TrimPrefix(server.URL, "http://")),
+		clientmodel.MetricsPathLabel: "/metrics",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h := testTarget.Status().Health(); h != HealthUnknown {
+		t.Fatalf("Expected initial target state %v, actual: %v", HealthUnknown, h)
+	}
+
+	// No synthetic samples are recorded as long as the target was never scraped.
+	testTarget.Pause()
+	appender := &countingAppender{}
+	go testTarget.RunScraper(appender)
+	time.Sleep(20 * time.Millisecond)
+	testTarget.StopScraper()
+	if n := appender.Count(); n != 0 {
+		t.Fatalf("Expected no samples before the first scrape, got %d", n)
+	}
+	if h := testTarget.Status().Health(); h != HealthUnknown {
+		t.Fatalf("Expected target state %v before the first scrape, actual: %v", HealthUnknown, h)
+	}
+
+	result := &collectResultAppender{}
+	if err := testTarget.scrape(result); err != nil {
+		t.Fatal(err)
+	}
+	if h := testTarget.Status().Health(); h != HealthGood {
+		t.Errorf("Expected target state %v after the first scrape, actual: %v", HealthGood, h)
+	}
+	var up clientmodel.Samples
+	for _, s := range result.result {
+		if s.Metric[clientmodel.MetricNameLabel] == scrapeHealthMetricName {
+			up = append(up, s)
+		}
+	}
+	if len(up) != 1 || up[0].Value != 1 {
+		t.Errorf("Expected a single %s sample with value 1, got %v", scrapeHealthMetricName, up)
+	}
+}
+
+func TestTargetHealthChangeHook(t *testing.T) {
+	fail := false
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if fail {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{})
+
+	type transition struct {
+		oldHealth, newHealth TargetHealth
+	}
+	var got []transition
+	testTarget.OnHealthChange(func(oldHealth, newHealth TargetHealth, target *Target) {
+		if target != testTarget {
+			t.Errorf("Hook called with unexpected target %v", target)
+		}
+		got = append(got, transition{oldHealth, newHealth})
+	})
+
+	for _, f := range []bool{false, false, true, true, false} {
+		fail = f
+		testTarget.scrape(nopAppender{})
+	}
+
+	want := []transition{
+		{HealthUnknown, HealthGood},
+		{HealthGood, HealthBad},
+		{HealthBad, HealthGood},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected transitions %v, got %v", want, got)
+	}
+}
+
+func TestTargetScrapeWithFullChannel(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				for i := 0; i < 2*ingestedSamplesCap; i++ {
+					w.Write([]byte(
+						fmt.Sprintf("test_metric_%d{foo=\"bar\"} 123.456\n", i),
+					))
+				}
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{"dings": "bums"})
+
+	testTarget.scrape(slowAppender{})
+	if testTarget.status.Health() != HealthBad {
+		t.Errorf("Expected target state %v, actual: %v", HealthBad, testTarget.status.Health())
+	}
+	if testTarget.status.LastError() != errIngestChannelFull {
+		t.Errorf("Expected target error %q, actual: %q", errIngestChannelFull, testTarget.status.LastError())
+	}
+}
+
+func TestTargetScrapeMetricRelabelConfigs(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric_drop 0\n"))
+				w.Write([]byte("test_metric_relabel 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{})
+	testTarget.metricRelabelConfigs = []*config.RelabelConfig{
+		{
+			SourceLabels: clientmodel.LabelNames{"__name__"},
+			Regex:        &config.Regexp{*regexp.MustCompile(".*drop.*")},
+			Action:       config.RelabelDrop,
+		},
+		{
+			SourceLabels: clientmodel.LabelNames{"__name__"},
+			Regex:        &config.Regexp{*regexp.MustCompile(".*(relabel|up).*")},
+			TargetLabel:  "foo",
+			Replacement:  "bar",
+			Action:       config.RelabelReplace,
+		},
+	}
+
+	appender := &collectResultAppender{}
+	testTarget.scrape(appender)
+
+	// Remove variables part of result.
+	for _, sample := range appender.result {
+		sample.Timestamp = 0
+		sample.Value = 0
+	}
+
+	expected := []*clientmodel.Sample{
+		{
+			Metric: clientmodel.Metric{
+				clientmodel.MetricNameLabel: "test_metric_relabel",
+				"foo": "bar",
+				clientmodel.InstanceLabel: clientmodel.LabelValue(testTarget.url.Host),
+			},
+			Timestamp: 0,
+			Value:     0,
+		},
+		// The metrics about the scrape are not affected.
+		{
+			Metric: clientmodel.Metric{
+				clientmodel.MetricNameLabel: scrapeHealthMetricName,
+				clientmodel.InstanceLabel:   clientmodel.LabelValue(testTarget.url.Host),
+			},
+			Timestamp: 0,
+			Value:     0,
+		},
+		{
+			Metric: clientmodel.Metric{
+				clientmodel.MetricNameLabel: scrapeDurationMetricName,
+				clientmodel.InstanceLabel:   clientmodel.LabelValue(testTarget.url.Host),
+			},
+			Timestamp: 0,
+			Value:     0,
+		},
+		{
+			Metric: clientmodel.Metric{
+				clientmodel.MetricNameLabel: scrapeBodySizeMetricName,
+				clientmodel.InstanceLabel:   clientmodel.LabelValue(testTarget.url.Host),
+			},
+			Timestamp: 0,
+			Value:     0,
+		},
+		{
+			Metric: clientmodel.Metric{
+				clientmodel.MetricNameLabel: scrapeTimeoutMetricName,
+				clientmodel.InstanceLabel:   clientmodel.LabelValue(testTarget.url.Host),
+			},
+			Timestamp: 0,
+			Value:     0,
+		},
+		{
+			Metric: clientmodel.Metric{
+				clientmodel.MetricNameLabel: scrapeLastSuccessMetricName,
+				clientmodel.InstanceLabel:   clientmodel.LabelValue(testTarget.url.Host),
+			},
+			Timestamp: 0,
+			Value:     0,
+		},
+	}
+
+	if !appender.result.Equal(expected) {
+		t.Fatalf("Expected and actual samples not equal. Expected: %s, actual: %s", expected, appender.result)
+	}
+
+}
+
+func TestTargetScrapeAbortFlushesSamples(t *testing.T) {
+	written := make(chan struct{})
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited`)
+				for i := 0; i < 10; i++ {
+					mf := &dto.MetricFamily{
+						Name: proto.String(fmt.Sprintf("test_metric_%d", i)),
+						Type: dto.MetricType_UNTYPED.Enum(),
+						Metric: []*dto.Metric{
+							{Untyped: &dto.Untyped{Value: proto.Float64(1)}},
+						},
+					}
+					if _, err := pbutil.WriteDelimited(w, mf); err != nil {
+						t.Error(err)
+					}
+				}
+				w.(http.Flusher).Flush()
+				close(written)
+				// Never finish the response.
+				<-r.Context().Done()
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 5*time.Second, clientmodel.LabelSet{})
+	appender := &collectResultAppender{}
+
+	errc := make(chan error)
+	go func() {
+		errc <- testTarget.scrape(appender)
+	}()
+
+	<-written
+	// Give the processor time to decode the written metric families.
+	time.Sleep(20 * time.Millisecond)
+	close(testTarget.scraperStopping)
+
+	if err := <-errc; err != errScrapeAborted {
+		t.Fatalf("Expected error %q, got %v", errScrapeAborted, err)
+	}
+	if len(appender.result) != 10 {
+		t.Fatalf("Expected 10 samples to be flushed, got %d", len(appender.result))
+	}
+	// Health samples are not recorded for aborted scrapes.
+	for _, s := range appender.result {
+		if s.Metric[clientmodel.MetricNameLabel] == scrapeHealthMetricName {
+			t.Fatalf("Unexpected health sample %s", s)
+		}
+	}
+	if testTarget.status.Health() != HealthUnknown {
+		t.Errorf("Expected target state %v, actual: %v", HealthUnknown, testTarget.status.Health())
+	}
+}
+
+func TestTargetRecordScrapeHealth(t *testing.T) {
+	testTarget := newTestTarget("example.url:80", 0, clientmodel.LabelSet{clientmodel.JobLabel: "testjob"})
+
+	now := clientmodel.Now()
+	appender := &collectResultAppender{}
+	testTarget.status.setLastError(nil)
+	recordScrapeHealth(appender, scrapeReport{
+		timestamp:  now,
+		baseLabels: testTarget.BaseLabels(),
+		health:     testTarget.status.Health(),
+		duration:   2 * time.Second,
+		timeout:    10 * time.Second,
+		bodySize:   1024,
+	})
+
+	result := appender.result
+
+	if len(result) != 4 {
+		t.Fatalf("Expected four samples, got %d", len(result))
+	}
+
+	actual := result[0]
+	expected := &clientmodel.Sample{
+		Metric: clientmodel.Metric{
+			clientmodel.MetricNameLabel: scrapeHealthMetricName,
+			clientmodel.InstanceLabel:   "example.url:80",
+			clientmodel.JobLabel:        "testjob",
+		},
+		Timestamp: now,
+		Value:     1,
+	}
+
+	if !actual.Equal(expected) {
+		t.Fatalf("Expected and actual samples not equal. Expected: %v, actual: %v", expected, actual)
+	}
+
+	actual = result[1]
+	expected = &clientmodel.Sample{
+		Metric: clientmodel.Metric{
+			clientmodel.MetricNameLabel: scrapeDurationMetricName,
+			clientmodel.InstanceLabel:   "example.url:80",
+			clientmodel.JobLabel:        "testjob",
+		},
+		Timestamp: now,
+		Value:     2.0,
+	}
+
+	if !actual.Equal(expected) {
+		t.Fatalf("Expected and actual samples not equal. Expected: %v, actual: %v", expected, actual)
+	}
+
+	actual = result[2]
+	expected = &clientmodel.Sample{
+		Metric: clientmodel.Metric{
+			clientmodel.MetricNameLabel: scrapeBodySizeMetricName,
+			clientmodel.InstanceLabel:   "example.url:80",
+			clientmodel.JobLabel:        "testjob",
+		},
+		Timestamp: now,
+		Value:     1024,
+	}
+
+	if !actual.Equal(expected) {
+		t.Fatalf("Expected and actual samples not equal. Expected: %v, actual: %v", expected, actual)
+	}
+
+	actual = result[3]
+	expected = &clientmodel.Sample{
+		Metric: clientmodel.Metric{
+			clientmodel.MetricNameLabel: scrapeTimeoutMetricName,
+			clientmodel.InstanceLabel:   "example.url:80",
+			clientmodel.JobLabel:        "testjob",
+		},
+		Timestamp: now,
+		Value:     10.0,
+	}
+
+	if !actual.Equal(expected) {
+		t.Fatalf("Expected and actual samples not equal. Expected: %v, actual: %v", expected, actual)
+	}
+}
+
+func TestTargetScrapeBodySize(t *testing.T) {
+	payload := "test_metric_1 1\ntest_metric_2 2\n"
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte(payload))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{})
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range appender.result {
+		if s.Metric[clientmodel.MetricNameLabel] != scrapeBodySizeMetricName {
+			continue
+		}
+		if s.Value != clientmodel.SampleValue(len(payload)) {
+			t.Fatalf("Expected body size %d, got %v", len(payload), s.Value)
+		}
+		return
+	}
+	t.Fatalf("No %s sample was appended", scrapeBodySizeMetricName)
+}
+
+func TestTargetScrapeSampleFraction(t *testing.T) {
+	const numSeries = 10000
+	var payload bytes.Buffer
+	for i := 0; i < numSeries; i++ {
+		fmt.Fprintf(&payload, "test_metric{id=\"%d\"} %d\n", i, i)
+	}
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write(payload.Bytes())
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 5*time.Second, clientmodel.LabelSet{})
+	samples, err := testTarget.ScrapeSampleFraction(0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) < numSeries*8/100 || len(samples) > numSeries*12/100 {
+		t.Errorf("Expected about %d samples, got %d", numSeries/10, len(samples))
+	}
+	selected := map[clientmodel.Fingerprint]bool{}
+	for _, s := range samples {
+		selected[s.Metric.Fingerprint()] = true
+	}
+
+	// The same series are selected again.
+	again, err := testTarget.ScrapeSampleFraction(0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != len(samples) {
+		t.Fatalf("Expected %d samples in repeated scrape, got %d", len(samples), len(again))
+	}
+	for _, s := range again {
+		if !selected[s.Metric.Fingerprint()] {
+			t.Errorf("Unexpected series %s in repeated scrape", s.Metric)
+		}
+	}
+
+	if all, err := testTarget.ScrapeSampleFraction(1); err != nil || len(all) != numSeries {
+		t.Errorf("Expected all %d samples, got %d (error: %v)", numSeries, len(all), err)
+	}
+	if _, err := testTarget.ScrapeSampleFraction(1.5); err == nil {
+		t.Errorf("Expected error for fraction above 1")
+	}
+	if !testTarget.status.LastScrape().IsZero() {
+		t.Errorf("Expected status not to be updated")
+	}
+}
+
+func TestTargetScrapeFormat(t *testing.T) {
+	scenarios := []struct {
+		contentType string
+		body        string
+		format      ScrapeFormat
+	}{
+		{
+			contentType: `text/plain; version=0.0.4`,
+			body:        "test_metric 1\n",
+			format:      ScrapeFormatText,
+		},
+		{
+			contentType: `application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited`,
+			format:      ScrapeFormatProtobuf,
+		},
+		{
+			contentType: `application/json; schema="prometheus/telemetry"; version=0.0.2`,
+			body:        "[]",
+			format:      ScrapeFormatJSON,
+		},
+	}
+
+	for i, s := range scenarios {
+		server := httptest.NewServer(
+			http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", s.contentType)
+					w.Write([]byte(s.body))
+				},
+			),
+		)
+
+		testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+		testTarget.scrapeFormatInfo = true
+		appender := &collectResultAppender{}
+		if err := testTarget.scrape(appender); err != nil {
+			t.Fatalf("%d. %s", i, err)
+		}
+		server.Close()
+
+		if got := testTarget.status.Format(); got != s.format {
+			t.Errorf("%d. Expected format %q, got %q", i, s.format, got)
+		}
+		var found bool
+		for _, smpl := range appender.result {
+			if smpl.Metric[clientmodel.MetricNameLabel] != scrapeFormatInfoMetricName {
+				continue
+			}
+			found = true
+			if got := smpl.Metric[scrapeFormatLabel]; got != clientmodel.LabelValue(s.format) {
+				t.Errorf("%d. Expected format label %q, got %q", i, s.format, got)
+			}
+			if smpl.Value != 1 {
+				t.Errorf("%d. Expected value 1, got %v", i, smpl.Value)
+			}
+		}
+		if !found {
+			t.Errorf("%d. Expected %s sample", i, scrapeFormatInfoMetricName)
+		}
+	}
+}
+
+func TestTargetScrapeOpenMetrics(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `application/openmetrics-text; version=1.0.0; charset=utf-8`)
+				w.Write([]byte(`# HELP requests The total number of requests.
+# TYPE requests counter
+requests_total{code="200"} 1027 # {trace_id="abc # }"} 1 1520879607.789
+requests_created{code="200"} 1520000000
+# TYPE temperature gauge
+# UNIT temperature celsius
+temperature{room="a # b"} 21.5 1520879607.789
+# TYPE latency histogram
+latency_bucket{le="1"} 3
+latency_bucket{le="+Inf"} 5
+latency_count 5
+latency_sum 7.5
+latency_created 1520000000
+# EOF
+ignored_metric 1
+`))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+	if got := testTarget.status.Format(); got != ScrapeFormatOpenMetrics {
+		t.Errorf("Expected format %q, got %q", ScrapeFormatOpenMetrics, got)
+	}
+
+	samples := map[string]*clientmodel.Sample{}
+	for _, s := range appender.result {
+		m := clientmodel.Metric{}
+		for ln, lv := range s.Metric {
+			if ln != clientmodel.InstanceLabel {
+				m[ln] = lv
+			}
+		}
+		samples[m.String()] = s
+	}
+	expected := map[string]clientmodel.SampleValue{
+		`requests_total{code="200"}`: 1027,
+		`temperature{room="a # b"}`:  21.5,
+		`latency_bucket{le="1"}`:     3,
+		`latency_bucket{le="+Inf"}`:  5,
+		`latency_count`:              5,
+		`latency_sum`:                7.5,
+	}
+	for m, v := range expected {
+		s, ok := samples[m]
+		if !ok {
+			t.Errorf("Expected sample %s", m)
+			continue
+		}
+		if s.Value != v {
+			t.Errorf("Expected value %v for %s, got %v", v, m, s.Value)
+		}
+	}
+	for m := range samples {
+		if strings.Contains(m, "_created") || strings.Contains(m, "ignored_metric") {
+			t.Errorf("Unexpected sample %s", m)
+		}
+	}
+	// OpenMetrics timestamps are in seconds.
+	if s, ok := samples[`temperature{room="a # b"}`]; ok && s.Timestamp != clientmodel.TimestampFromUnixNano(1520879607789*int64(time.Millisecond)) {
+		t.Errorf("Unexpected timestamp %v", s.Timestamp)
+	}
+	if md := testTarget.Metadata()["requests"]; md.Type != "counter" || md.Help != "The total number of requests." {
+		t.Errorf("Unexpected metadata %+v", md)
+	}
+}
+
+func TestTargetStatusLastScrapeSampleCount(t *testing.T) {
+	payload := "test_metric_1 1\ntest_metric_2 2\ntest_metric_3 3\n"
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte(payload))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	if got := testTarget.status.LastScrapeSampleCount(); got != 0 {
+		t.Fatalf("Expected no samples before the first scrape, got %d", got)
+	}
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := testTarget.status.LastScrapeSampleCount(); got != 3 {
+		t.Errorf("Expected 3 samples, got %d", got)
+	}
+
+	payload = "test_metric_1 1\n"
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := testTarget.status.LastScrapeSampleCount(); got != 1 {
+		t.Errorf("Expected 1 sample, got %d", got)
+	}
+}
+
+func TestTargetScrapeGzipSizeThreshold(t *testing.T) {
+	encodings := make(chan string, 1)
+	server := httptest.NewServer(
+		httputil.CompressionHandler{
+			Handler: http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					encodings <- r.Header.Get("Accept-Encoding")
+					w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+					for i := 0; i < 10; i++ {
+						fmt.Fprintf(w, "test_metric_%d 1\n", i)
+					}
+				},
+			),
+		},
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.gzipSizeThreshold = 100
+
+	// The size of the response is unknown before the first scrape.
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+	if enc := <-encodings; strings.Contains(enc, "gzip") {
+		t.Errorf("Expected no compression to be requested by the first scrape, got Accept-Encoding %q", enc)
+	}
+
+	// The last response exceeded the threshold.
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+	if enc := <-encodings; !strings.Contains(enc, "gzip") {
+		t.Errorf("Expected compression to be requested, got Accept-Encoding %q", enc)
+	}
+	samples := 0
+	for _, s := range appender.result {
+		if strings.HasPrefix(string(s.Metric[clientmodel.MetricNameLabel]), "test_metric_") {
+			samples++
+		}
+	}
+	if samples != 10 {
+		t.Errorf("Expected 10 samples from the compressed response, got %d", samples)
+	}
+
+	// Small responses are not compressed.
+	testTarget.gzipSizeThreshold = 1000
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+	if enc := <-encodings; strings.Contains(enc, "gzip") {
+		t.Errorf("Expected no compression to be requested below the threshold, got Accept-Encoding %q", enc)
+	}
+}
+
+func TestTargetScrapeChunkedBody(t *testing.T) {
+	chunks := []string{"test_metric_1 1\n", "test_metric_2 2\n", "test_metric_3 3\n"}
+	var payloadSize int
+	for _, c := range chunks {
+		payloadSize += len(c)
+	}
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				// Flushing before the handler returns makes the response
+				// chunked without a Content-Length.
+				for _, c := range chunks {
+					w.Write([]byte(c))
+					w.(http.Flusher).Flush()
+				}
+			},
+		),
+	)
+	defer server.Close()
+
+	bodySize := func(appender *collectResultAppender) clientmodel.SampleValue {
+		for _, s := range appender.result {
+			if s.Metric[clientmodel.MetricNameLabel] == scrapeBodySizeMetricName {
+				return s.Value
+			}
+		}
+		t.Fatalf("No %s sample was appended", scrapeBodySizeMetricName)
+		return 0
+	}
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.bodySizeLimit = int64(payloadSize)
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+	if got := bodySize(appender); got != clientmodel.SampleValue(payloadSize) {
+		t.Errorf("Expected body size %d, got %v", payloadSize, got)
+	}
+	// All scraped samples plus the synthetic ones.
+	if got := len(appender.result); got < len(chunks)+1 {
+		t.Errorf("Expected at least %d samples, got %d", len(chunks)+1, got)
+	}
+
+	// The limit is exceeded within the last chunk.
+	testTarget = newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.bodySizeLimit = int64(payloadSize - 1)
+	appender = &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != errBodySizeLimitExceeded {
+		t.Fatalf("Expected error %q, got %v", errBodySizeLimitExceeded, err)
+	}
+	if testTarget.status.Health() != HealthBad {
+		t.Errorf("Expected health %v, got %v", HealthBad, testTarget.status.Health())
+	}
+	if got := bodySize(appender); got != clientmodel.SampleValue(payloadSize) {
+		t.Errorf("Expected body size %d, got %v", payloadSize, got)
+	}
+}
+
+func TestTargetRecordScrapeHealthNameSuffixes(t *testing.T) {
+	now := clientmodel.Now()
+	expiry := time.Unix(1500000000, 0)
+
+	names := func(suffixes bool) []clientmodel.LabelValue {
+		appender := &collectResultAppender{}
+		recordScrapeHealth(appender, scrapeReport{
+			timestamp:      now,
+			baseLabels:     clientmodel.LabelSet{clientmodel.JobLabel: "testjob"},
+			health:         HealthGood,
+			duration:       time.Second,
+			timeout:        10 * time.Second,
+			bodySize:       1024,
+			peerCertExpiry: expiry,
+			nameSuffixes:   suffixes,
+		})
+
+		var names []clientmodel.LabelValue
+		for _, s := range appender.result {
+			names = append(names, s.Metric[clientmodel.MetricNameLabel])
+		}
+		return names
+	}
+
+	expected := []clientmodel.LabelValue{"up", "scrape_duration_seconds", "scrape_body_size_bytes", "scrape_timeout_seconds", "scrape_tls_cert_not_after"}
+	if got := names(false); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected metric names %v, got %v", expected, got)
+	}
+	expected = []clientmodel.LabelValue{"up", "scrape_duration_seconds", "scrape_body_size_bytes", "scrape_timeout_seconds", "scrape_tls_cert_not_after_seconds"}
+	if got := names(true); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected suffixed metric names %v, got %v", expected, got)
+	}
+}
+
+func TestTargetSyntheticMetricNames(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+			},
+		),
+	)
+	defer server.Close()
+
+	scenarios := []struct {
+		timingMetrics bool
+		nameSuffixes  bool
+		seriesLimit   int
+		expected      []string
+	}{
+		{
+			expected: []string{
+				"up",
+				"scrape_duration_seconds",
+				"scrape_body_size_bytes",
+				"scrape_timeout_seconds",
+				"scrape_last_success_timestamp_seconds",
+			},
+		}, {
+			timingMetrics: true,
+			expected: []string{
+				"up",
+				"scrape_duration_seconds",
+				"scrape_body_size_bytes",
+				"scrape_timeout_seconds",
+				"scrape_last_success_timestamp_seconds",
+				"scrape_dns_lookup_duration_seconds",
+				"scrape_connect_duration_seconds",
+				"scrape_tls_handshake_duration_seconds",
+				"scrape_first_byte_duration_seconds",
+			},
+		}, {
+			nameSuffixes: true,
+			seriesLimit:  10,
+			expected: []string{
+				"up",
+				"scrape_duration_seconds",
+				"scrape_body_size_bytes",
+				"scrape_timeout_seconds",
+				"scrape_last_success_timestamp_seconds",
+				"scrape_series_capped_total",
+			},
+		},
+	}
+	for i, s := range scenarios {
+		testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+		testTarget.scrapeTimingMetrics = s.timingMetrics
+		testTarget.syntheticNameSuffixes = s.nameSuffixes
+		if s.seriesLimit > 0 {
+			testTarget.seriesLimiter = newSeriesLimiter(s.seriesLimit)
+		}
+
+		names := testTarget.SyntheticMetricNames()
+		if !reflect.DeepEqual(names, s.expected) {
+			t.Errorf("%d. Expected synthetic metrics %v, got %v", i, s.expected, names)
+		}
+
+		// The target has no metrics, so all appended samples are synthetic.
+		// The series limit counter is only appended once samples were dropped.
+		app := &collectResultAppender{}
+		if err := testTarget.scrape(app); err != nil {
+			t.Fatal(err)
+		}
+		listed := map[string]bool{}
+		for _, name := range names {
+			listed[name] = true
+		}
+		for _, sample := range app.result {
+			if name := string(sample.Metric[clientmodel.MetricNameLabel]); !listed[name] {
+				t.Errorf("%d. Appended synthetic metric %s is not listed", i, name)
+			}
+		}
+		if s.seriesLimit == 0 && len(app.result) != len(names) {
+			t.Errorf("%d. Expected %d appended synthetic metrics, got %d", i, len(names), len(app.result))
+		}
+	}
+}
+
+func TestTargetScrapeTimeout(t *testing.T) {
+	signal := make(chan bool, 1)
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				<-signal
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte{})
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 50*time.Millisecond, clientmodel.LabelSet{})
+
+	appender := nopAppender{}
+
+	// scrape once without timeout
+	signal <- true
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+
+	// let the deadline lapse
+	time.Sleep(55 * time.Millisecond)
+
+	// now scrape again
+	signal <- true
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+
 	// now timeout
 	if err := testTarget.scrape(appender); err == nil {
-		t.Fatal("expected scrape to timeout")
-	} else {
-		signal <- true // let handler continue
+		t.Fatal("expected scrape to timeout")
+	} else {
+		signal <- true // let handler continue
+	}
+
+	// now scrape again without timeout
+	signal <- true
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTargetScrapeEmptyResponse(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte{})
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	appender := nopAppender{}
+
+	// Empty responses are successful by default.
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+	if testTarget.status.Health() != HealthGood {
+		t.Errorf("Expected target state %v, actual: %v", HealthGood, testTarget.status.Health())
+	}
+
+	testTarget.failEmptyScrapes = true
+	if err := testTarget.scrape(appender); err != errEmptyScrape {
+		t.Fatalf("Expected error %q, got %v", errEmptyScrape, err)
+	}
+	if testTarget.status.Health() != HealthBad {
+		t.Errorf("Expected target state %v, actual: %v", HealthBad, testTarget.status.Health())
+	}
+	if testTarget.status.LastError() != errEmptyScrape {
+		t.Errorf("Expected last error %q, got %v", errEmptyScrape, testTarget.status.LastError())
+	}
+}
+
+func TestTargetScrapeHealthCheck(t *testing.T) {
+	var (
+		mtx             sync.Mutex
+		healthStatus    = http.StatusServiceUnavailable
+		metricsRequests int
+	)
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				if r.URL.Path == "/healthz" {
+					w.WriteHeader(healthStatus)
+					return
+				}
+				metricsRequests++
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.healthCheckPath = "/healthz"
+
+	app := &collectResultAppender{}
+	if err := testTarget.scrape(app); err == nil {
+		t.Fatal("Expected the scrape to fail")
+	}
+	mtx.Lock()
+	if metricsRequests != 0 {
+		t.Errorf("Expected the metrics not to be fetched, got %d requests", metricsRequests)
+	}
+	healthStatus = http.StatusOK
+	mtx.Unlock()
+	if testTarget.status.Health() != HealthBad {
+		t.Errorf("Expected target state %v, actual: %v", HealthBad, testTarget.status.Health())
+	}
+	var up clientmodel.Samples
+	for _, s := range app.result {
+		if s.Metric[clientmodel.MetricNameLabel] == scrapeHealthMetricName {
+			up = append(up, s)
+		}
+	}
+	if len(up) != 1 || up[0].Value != 0 {
+		t.Errorf("Expected up to be 0, got %v", up)
+	}
+
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if metricsRequests != 1 {
+		t.Errorf("Expected the metrics to be fetched once, got %d requests", metricsRequests)
+	}
+}
+
+func TestTargetScrapeBodyDeadline(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.WriteHeader(http.StatusOK)
+				// Trickle the body in much slower than the scrape deadline.
+				for i := 0; i < 20; i++ {
+					fmt.Fprintf(w, "test_metric_%d 1\n", i)
+					w.(http.Flusher).Flush()
+					select {
+					case <-r.Context().Done():
+						return
+					case <-time.After(50 * time.Millisecond):
+					}
+				}
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 200*time.Millisecond, clientmodel.LabelSet{})
+	testTarget.httpClient = httputil.NewClient(httputil.NewHeaderDeadlineRoundTripper(200*time.Millisecond, 50*time.Millisecond, nil))
+
+	start := time.Now()
+	if err := testTarget.scrape(nopAppender{}); err != errScrapeDeadlineExceeded {
+		t.Fatalf("Expected error %q, got %v", errScrapeDeadlineExceeded, err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("Expected scrape to be aborted at the deadline, took %s", d)
+	}
+	if health := testTarget.status.Health(); health != HealthBad {
+		t.Fatalf("Expected health %v, got %v", HealthBad, health)
+	}
+}
+
+func TestTargetScrapeHeaderTimeout(t *testing.T) {
+	signal := make(chan bool, 1)
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				<-signal
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte{})
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.httpClient = httputil.NewClient(httputil.NewHeaderDeadlineRoundTripper(time.Second, 50*time.Millisecond, nil))
+
+	start := time.Now()
+	if err := testTarget.scrape(nopAppender{}); err == nil {
+		t.Fatal("Expected scrape to time out waiting for the response headers")
+	}
+	signal <- true
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("Expected scrape to time out after the header timeout, took %s", d)
+	}
+}
+
+func TestTargetScrapeMetadata(t *testing.T) {
+	payload := "# HELP test_metric A test metric.\n# TYPE test_metric gauge\ntest_metric 1\n"
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte(payload))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]MetricMetadata{
+		"test_metric": {Type: "gauge", Help: "A test metric."},
+	}
+	if md := testTarget.Metadata(); !reflect.DeepEqual(md, expected) {
+		t.Fatalf("Expected metadata %v, got %v", expected, md)
+	}
+	// The metadata does not create series of its own.
+	scraped := 0
+	for _, s := range appender.result {
+		if s.Metric[clientmodel.MetricNameLabel] == "test_metric" {
+			scraped++
+		}
+	}
+	if scraped != 1 {
+		t.Errorf("Expected 1 scraped sample, got %d", scraped)
+	}
+
+	// The metadata is replaced by the one of the next scrape.
+	payload = "# TYPE test_metric counter\ntest_metric 2\n# HELP other_metric Another metric.\nother_metric 1\n"
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]MetricMetadata{
+		"test_metric":  {Type: "counter"},
+		"other_metric": {Help: "Another metric."},
+	}
+	if md := testTarget.Metadata(); !reflect.DeepEqual(md, expected) {
+		t.Fatalf("Expected metadata %v, got %v", expected, md)
+	}
+}
+
+func TestTargetScrapePOST(t *testing.T) {
+	const body = `{"collect": ["all"]}`
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != "POST" {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+					t.Errorf("Expected content type %q, got %q", "application/json", ct)
+				}
+				if b, err := ioutil.ReadAll(r.Body); err != nil || string(b) != body {
+					t.Errorf("Expected request body %q, got %q (error: %v)", body, b, err)
+				}
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeInterval:         config.Duration(time.Second),
+		ScrapeTimeout:          config.Duration(time.Second),
+		MetricsPath:            "/metrics",
+		HTTPMethod:             "POST",
+		RequestBody:            body,
+		RequestBodyContentType: "application/json",
+	}
+	host := strings.TrimPrefix(server.URL, "http://")
+	testTarget, err := NewTarget(cfg, clientmodel.LabelSet{
+		clientmodel.SchemeLabel:  "http",
+		clientmodel.AddressLabel: clientmodel.LabelValue(host),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The body is sent with every scrape.
+	for i := 0; i < 2; i++ {
+		appender := &collectResultAppender{}
+		if err := testTarget.scrape(appender); err != nil {
+			t.Fatal(err)
+		}
+		if len(appender.result) == 0 || appender.result[0].Metric[clientmodel.MetricNameLabel] != "test_metric" {
+			t.Fatalf("Expected scraped sample, got %v", appender.result)
+		}
+	}
+
+	// The default remains GET without a body.
+	cfg.HTTPMethod, cfg.RequestBody, cfg.RequestBodyContentType = "", "", ""
+	if err := testTarget.Update(cfg, testTarget.fullLabels(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := testTarget.scrape(nopAppender{}); err == nil || !strings.Contains(err.Error(), "405") {
+		t.Fatalf("Expected GET to be rejected, got %v", err)
+	}
+}
+
+func TestTargetScrapeCompressedRequestBody(t *testing.T) {
+	const body = `{"collect": ["all"]}`
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if enc := r.Header.Get("Content-Encoding"); enc != "gzip" {
+					t.Errorf("Expected content encoding gzip, got %q", enc)
+					w.WriteHeader(http.StatusUnsupportedMediaType)
+					return
+				}
+				gr, err := gzip.NewReader(r.Body)
+				if err != nil {
+					t.Errorf("Error decompressing request body: %s", err)
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				if b, err := ioutil.ReadAll(gr); err != nil || string(b) != body {
+					t.Errorf("Expected request body %q, got %q (error: %v)", body, b, err)
+				}
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeInterval:      config.Duration(time.Second),
+		ScrapeTimeout:       config.Duration(time.Second),
+		MetricsPath:         "/metrics",
+		HTTPMethod:          "POST",
+		RequestBody:         body,
+		CompressRequestBody: true,
+	}
+	testTarget, err := NewTarget(cfg, clientmodel.LabelSet{
+		clientmodel.SchemeLabel:  "http",
+		clientmodel.AddressLabel: clientmodel.LabelValue(strings.TrimPrefix(server.URL, "http://")),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTargetScrape404(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{})
+	appender := nopAppender{}
+
+	want := errors.New("server returned HTTP status 404 Not Found")
+	got := testTarget.scrape(appender)
+	if got == nil || want.Error() != got.Error() {
+		t.Fatalf("want err %q, got %q", want, got)
+	}
+}
+
+func TestTargetScrapeAcceptStatusCodes(t *testing.T) {
+	status := http.StatusNoContent
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.WriteHeader(status)
+				if status != http.StatusNoContent {
+					w.Write([]byte("test_metric 1\n"))
+				}
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+
+	// Only 200 is accepted by default.
+	if err := testTarget.scrape(nopAppender{}); err == nil {
+		t.Fatal("Expected scrape with status 204 to fail")
+	}
+
+	testTarget.acceptStatusCodes = map[int]struct{}{http.StatusOK: {}, http.StatusNoContent: {}, http.StatusPartialContent: {}}
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatalf("Expected scrape with status 204 to succeed, got %s", err)
+	}
+	if testTarget.status.Health() != HealthGood {
+		t.Errorf("Expected target state %v, actual: %v", HealthGood, testTarget.status.Health())
+	}
+	for _, s := range appender.result {
+		if s.Metric[clientmodel.MetricNameLabel] == "test_metric" {
+			t.Errorf("Unexpected sample %s for status 204", s.Metric)
+		}
+	}
+
+	// The body of other accepted responses is processed.
+	status = http.StatusPartialContent
+	appender = &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatalf("Expected scrape with status 206 to succeed, got %s", err)
+	}
+	if len(appender.result) == 0 || appender.result[0].Metric[clientmodel.MetricNameLabel] != "test_metric" {
+		t.Errorf("Expected sample test_metric for status 206, got %v", appender.result)
+	}
+}
+
+func TestTargetScrapeNotModified(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("If-None-Match") == `"v1"` {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Header().Set("ETag", `"v1"`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{})
+
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+	if appender.result[0].Metric[clientmodel.MetricNameLabel] != "test_metric" {
+		t.Fatalf("Expected test_metric on initial scrape, got %s", appender.result[0])
+	}
+
+	appender = &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+	if testTarget.status.Health() != HealthGood {
+		t.Errorf("Expected target state %v, actual: %v", HealthGood, testTarget.status.Health())
+	}
+	// Only the synthetic health samples must be appended.
+	up := appender.result[0]
+	if up.Metric[clientmodel.MetricNameLabel] != scrapeHealthMetricName || up.Value != 1 {
+		t.Errorf("Expected %s to be 1, got %s", scrapeHealthMetricName, up)
+	}
+	for _, s := range appender.result {
+		if s.Metric[clientmodel.MetricNameLabel] == "test_metric" {
+			t.Fatalf("Unexpected sample %s on unmodified scrape", s)
+		}
+	}
+}
+
+func TestTargetScrapeAdditionalPathNotModified(t *testing.T) {
+	var conditional bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+		w.Write([]byte("test_metric_1 1\n"))
+	})
+	mux.HandleFunc("/extra", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			conditional = true
+		}
+		w.WriteHeader(http.StatusNotModified)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.additionalPaths = []string{"/extra"}
+
+	// A 304 response to the unconditional request of an additional path is
+	// an error and does not mark the primary path as unmodified.
+	for i := 0; i < 2; i++ {
+		appender := &collectResultAppender{}
+		if err := testTarget.scrape(appender); err == nil {
+			t.Fatal("Expected error scraping additional path")
+		}
+		if testTarget.status.Health() != HealthPartial {
+			t.Errorf("Expected target state %v, actual: %v", HealthPartial, testTarget.status.Health())
+		}
+		found := false
+		for _, s := range appender.result {
+			if s.Metric[clientmodel.MetricNameLabel] == "test_metric_1" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected test_metric_1 on scrape %d, got %v", i, appender.result)
+		}
+	}
+	if conditional {
+		t.Error("Unexpected conditional request for additional path")
+	}
+}
+
+func TestTargetScrapeAcceptHeader(t *testing.T) {
+	var accept string
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				accept = r.Header.Get("Accept")
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte{})
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{})
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+	if accept != acceptHeader {
+		t.Errorf("Expected default Accept header %q, got %q", acceptHeader, accept)
+	}
+
+	testTarget.acceptHeader = `text/plain;version=0.0.4`
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+	if accept != testTarget.acceptHeader {
+		t.Errorf("Expected Accept header %q, got %q", testTarget.acceptHeader, accept)
+	}
+}
+
+func TestTargetScrapeUnsupportedContentType(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/html; charset=utf-8`)
+				w.Write([]byte("<html><body>Please log in.</body></html>"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{})
+	if err := testTarget.scrape(nopAppender{}); err != errUnsupportedContentType {
+		t.Fatalf("Expected error %q, got %v", errUnsupportedContentType, err)
+	}
+	if testTarget.status.Health() != HealthBad {
+		t.Errorf("Expected target state %v, actual: %v", HealthBad, testTarget.status.Health())
+	}
+	if testTarget.status.LastError() != errUnsupportedContentType {
+		t.Errorf("Expected target error %q, actual: %v", errUnsupportedContentType, testTarget.status.LastError())
+	}
+}
+
+func TestTargetLimitedScrape(t *testing.T) {
+	const limit = 2
+
+	var (
+		mtx                 sync.Mutex
+		running, maxRunning int
+	)
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				mtx.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mtx.Unlock()
+
+				time.Sleep(20 * time.Millisecond)
+
+				mtx.Lock()
+				running--
+				mtx.Unlock()
+
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	sem := make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3*limit; i++ {
+		testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+		testTarget.scrapeSemaphore = sem
+
+		wg.Add(1)
+		go func() {
+			testTarget.limitedScrape(nopAppender{})
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	if maxRunning > limit {
+		t.Errorf("Expected at most %d concurrent scrapes, got %d", limit, maxRunning)
+	}
+
+	// A scrape that cannot acquire the semaphore within its deadline is skipped.
+	skipped := func() float64 {
+		var m dto.Metric
+		skippedScrapes.Write(&m)
+		return m.GetCounter().GetValue()
+	}
+	before := skipped()
+
+	sem = make(chan struct{}, 1)
+	sem <- struct{}{}
+	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{})
+	testTarget.scrapeSemaphore = sem
+	testTarget.limitedScrape(nopAppender{})
+
+	if got := skipped() - before; got != 1 {
+		t.Errorf("Expected 1 skipped scrape, got %v", got)
+	}
+	if testTarget.status.Health() != HealthUnknown {
+		t.Errorf("Expected target state %v for skipped scrape, actual: %v", HealthUnknown, testTarget.status.Health())
+	}
+}
+
+func TestTargetSampleObserver(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric{foo=\"bar\"} 1\ndrop_metric 2\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{"job": "test"})
+	testTarget.metricRelabelConfigs = []*config.RelabelConfig{
+		{
+			SourceLabels: clientmodel.LabelNames{"__name__"},
+			Regex:        &config.Regexp{*regexp.MustCompile("drop_.*")},
+			Action:       config.RelabelDrop,
+		},
+	}
+	observed := map[string]clientmodel.SampleValue{}
+	testTarget.SetSampleObserver(func(s *clientmodel.Sample) {
+		observed[s.Metric.String()] = s.Value
+		// Changes to the observed sample must not be appended.
+		s.Metric["observed"] = "true"
+		s.Value = 42
+	})
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]clientmodel.SampleValue{
+		`test_metric{foo="bar"}`: 1,
+		`drop_metric`:            2,
+	}
+	if !reflect.DeepEqual(observed, expected) {
+		t.Errorf("Expected observed samples %v, got %v", expected, observed)
+	}
+	for _, s := range appender.result {
+		if _, ok := s.Metric["observed"]; ok {
+			t.Errorf("Unexpected change of appended sample %s by the observer", s.Metric)
+		}
+		if s.Metric[clientmodel.MetricNameLabel] == "test_metric" && s.Value != 1 {
+			t.Errorf("Expected value 1 of appended sample %s, got %v", s.Metric, s.Value)
+		}
+		if s.Metric[clientmodel.MetricNameLabel] == "drop_metric" {
+			t.Errorf("Unexpected appended sample %s", s.Metric)
+		}
+	}
+
+	// Removing the observer stops observing samples.
+	testTarget.SetSampleObserver(nil)
+	observed = map[string]clientmodel.SampleValue{}
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(observed) != 0 {
+		t.Errorf("Expected no observed samples, got %v", observed)
+	}
+}
+
+func TestTargetScrapeMiddlewares(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	var (
+		invocations int
+		order       []string
+		errs        []error
+	)
+	counting := func(next ScrapeFunc) ScrapeFunc {
+		return func(app storage.SampleAppender) error {
+			invocations++
+			order = append(order, "counting")
+			err := next(app)
+			errs = append(errs, err)
+			return err
+		}
+	}
+	errSkipped := errors.New("skipped")
+	// Skips every second scrape.
+	skipping := func(next ScrapeFunc) ScrapeFunc {
+		return func(app storage.SampleAppender) error {
+			order = append(order, "skipping")
+			if invocations%2 == 0 {
+				return errSkipped
+			}
+			return next(app)
+		}
+	}
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.SetScrapeMiddlewares(counting, skipping)
+	for i := 0; i < 4; i++ {
+		testTarget.limitedScrape(nopAppender{})
+	}
+
+	if invocations != 4 {
+		t.Errorf("Expected 4 invocations, got %d", invocations)
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 scrape requests, got %d", requests)
+	}
+	expectedErrs := []error{nil, errSkipped, nil, errSkipped}
+	if !reflect.DeepEqual(errs, expectedErrs) {
+		t.Errorf("Expected scrape errors %v, got %v", expectedErrs, errs)
+	}
+	if order[0] != "counting" || order[1] != "skipping" {
+		t.Errorf("Expected the first middleware to be the outermost, got order %v", order)
+	}
+	if testTarget.status.Health() != HealthGood {
+		t.Errorf("Expected skipped scrapes not to change the health, got %v", testTarget.status.Health())
+	}
+}
+
+func TestTargetStatusSnapshot(t *testing.T) {
+	status := &TargetStatus{}
+	scrapeErr := errors.New("scrape failed")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if i%2 == 0 {
+				status.setLastError(nil)
+			} else {
+				status.setLastError(scrapeErr)
+			}
+			status.setLastScrape(time.Now())
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		snap := status.Snapshot()
+		switch snap.Health {
+		case HealthGood:
+			if snap.LastError != nil {
+				t.Fatalf("Expected no error for healthy snapshot, got %q", snap.LastError)
+			}
+		case HealthBad:
+			if snap.LastError != scrapeErr {
+				t.Fatalf("Expected error %q for unhealthy snapshot, got %v", scrapeErr, snap.LastError)
+			}
+		}
+	}
+}
+
+func TestTargetStatusAvgScrapeDuration(t *testing.T) {
+	status := &TargetStatus{}
+	if d := status.AvgScrapeDuration(); d != 0 {
+		t.Fatalf("Expected no average before the first scrape, got %s", d)
+	}
+
+	// The first duration initializes the average.
+	status.observeScrapeDuration(100*time.Millisecond, 0.5)
+	if d := status.AvgScrapeDuration(); d != 100*time.Millisecond {
+		t.Fatalf("Expected average %s, got %s", 100*time.Millisecond, d)
+	}
+	status.observeScrapeDuration(200*time.Millisecond, 0.5)
+	if d := status.AvgScrapeDuration(); d != 150*time.Millisecond {
+		t.Fatalf("Expected average %s, got %s", 150*time.Millisecond, d)
+	}
+
+	// The average converges towards a steady duration.
+	last := status.AvgScrapeDuration()
+	for i := 0; i < 20; i++ {
+		status.observeScrapeDuration(time.Second, 0.5)
+		d := status.AvgScrapeDuration()
+		if d <= last || d > time.Second {
+			t.Fatalf("Expected average to approach %s from %s, got %s", time.Second, last, d)
+		}
+		last = d
+	}
+	if time.Second-last > time.Millisecond {
+		t.Fatalf("Expected average to converge to %s, got %s", time.Second, last)
+	}
+	if d := status.Snapshot().AvgScrapeDuration; d != last {
+		t.Fatalf("Expected snapshot average %s, got %s", last, d)
+	}
+
+	// A scrape updates the average with the configured decay.
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeInterval:      config.Duration(time.Second),
+		ScrapeTimeout:       config.Duration(time.Second),
+		MetricsPath:         "/metrics",
+		ScrapeDurationDecay: 0.7,
+	}
+	testTarget, err := NewTarget(cfg, clientmodel.LabelSet{
+		clientmodel.SchemeLabel:  "http",
+		clientmodel.AddressLabel: clientmodel.LabelValue(strings.TrimPrefix(server.URL, "http://")),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testTarget.scrapeDurationDecay != 0.7 {
+		t.Fatalf("Expected decay 0.7, got %g", testTarget.scrapeDurationDecay)
+	}
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+	if d := testTarget.status.AvgScrapeDuration(); d <= 0 {
+		t.Fatalf("Expected positive average scrape duration, got %s", d)
+	}
+}
+
+func TestTargetOmitInstanceLabel(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric{instance=\"child:9100\"} 1\ntest_metric_plain 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	for _, omit := range []bool{false, true} {
+		cfg := &config.ScrapeConfig{
+			ScrapeInterval:    config.Duration(time.Second),
+			ScrapeTimeout:     config.Duration(time.Second),
+			MetricsPath:       "/metrics",
+			OmitInstanceLabel: omit,
+		}
+		testTarget, err := NewTarget(cfg, clientmodel.LabelSet{
+			clientmodel.SchemeLabel:  "http",
+			clientmodel.AddressLabel: clientmodel.LabelValue(host),
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := testTarget.BaseLabels()[clientmodel.InstanceLabel]; ok == omit {
+			t.Fatalf("omit=%t: unexpected base labels %v", omit, testTarget.BaseLabels())
+		}
+
+		appender := &collectResultAppender{}
+		if err := testTarget.scrape(appender); err != nil {
+			t.Fatal(err)
+		}
+		metrics := map[clientmodel.LabelValue]clientmodel.Metric{}
+		for _, s := range appender.result {
+			metrics[s.Metric[clientmodel.MetricNameLabel]] = s.Metric
+		}
+
+		m := metrics["test_metric"]
+		if omit {
+			if m[clientmodel.InstanceLabel] != "child:9100" {
+				t.Errorf("omit=%t: expected scraped instance label to survive, got %s", omit, m)
+			}
+			if _, ok := m[clientmodel.ExportedLabelPrefix+clientmodel.InstanceLabel]; ok {
+				t.Errorf("omit=%t: expected no exported instance label, got %s", omit, m)
+			}
+			if _, ok := metrics["test_metric_plain"][clientmodel.InstanceLabel]; ok {
+				t.Errorf("omit=%t: expected no instance label to be added, got %s", omit, metrics["test_metric_plain"])
+			}
+		} else {
+			if m[clientmodel.InstanceLabel] != clientmodel.LabelValue(host) || m[clientmodel.ExportedLabelPrefix+clientmodel.InstanceLabel] != "child:9100" {
+				t.Errorf("omit=%t: expected the instance label to be overwritten, got %s", omit, m)
+			}
+		}
+		// The synthetic metrics always identify the target.
+		if up := metrics[scrapeHealthMetricName]; up[clientmodel.InstanceLabel] != clientmodel.LabelValue(host) {
+			t.Errorf("omit=%t: expected instance label %q on %s, got %s", omit, host, scrapeHealthMetricName, up)
+		}
+	}
+}
+
+func TestTargetScrapeLastSuccessTimestamp(t *testing.T) {
+	var fail int32
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if atomic.LoadInt32(&fail) == 1 {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	scrape := func() map[clientmodel.LabelValue]clientmodel.SampleValue {
+		appender := &collectResultAppender{}
+		testTarget.scrape(appender)
+		values := map[clientmodel.LabelValue]clientmodel.SampleValue{}
+		for _, s := range appender.result {
+			values[s.Metric[clientmodel.MetricNameLabel]] = s.Value
+		}
+		return values
+	}
+
+	before := time.Now()
+	values := scrape()
+	lastSuccess, ok := values[scrapeLastSuccessMetricName]
+	if !ok {
+		t.Fatalf("Expected %s after a successful scrape, got %v", scrapeLastSuccessMetricName, values)
+	}
+	if float64(lastSuccess) < float64(before.Unix()) {
+		t.Fatalf("Expected last success after %v, got %v", before, lastSuccess)
+	}
+
+	// A failed scrape retains the time of the last successful one.
+	atomic.StoreInt32(&fail, 1)
+	values = scrape()
+	if values[scrapeHealthMetricName] != 0 {
+		t.Fatalf("Expected %s to be 0, got %v", scrapeHealthMetricName, values[scrapeHealthMetricName])
+	}
+	if v := values[scrapeLastSuccessMetricName]; v != lastSuccess {
+		t.Fatalf("Expected %s to stay at %v, got %v", scrapeLastSuccessMetricName, lastSuccess, v)
+	}
+	if ts := clientmodel.SampleValue(float64(testTarget.status.LastSuccess().UnixNano()) / float64(time.Second)); ts != lastSuccess {
+		t.Fatalf("Expected status to report last success %v, got %v", lastSuccess, ts)
+	}
+}
+
+func TestTargetScrapeSeriesLimit(t *testing.T) {
+	var payload atomic.Value
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte(payload.Load().(string)))
+			},
+		),
+	)
+	defer server.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeInterval: config.Duration(time.Second),
+		ScrapeTimeout:  config.Duration(time.Second),
+		MetricsPath:    "/metrics",
+		SeriesLimit:    2,
+	}
+	testTarget, err := NewTarget(cfg, clientmodel.LabelSet{
+		clientmodel.SchemeLabel:  "http",
+		clientmodel.AddressLabel: clientmodel.LabelValue(strings.TrimPrefix(server.URL, "http://")),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scrape := func(body string) map[clientmodel.LabelValue]clientmodel.SampleValue {
+		payload.Store(body)
+		appender := &collectResultAppender{}
+		if err := testTarget.scrape(appender); err != nil {
+			t.Fatal(err)
+		}
+		values := map[clientmodel.LabelValue]clientmodel.SampleValue{}
+		for _, s := range appender.result {
+			values[s.Metric[clientmodel.MetricNameLabel]] = s.Value
+		}
+		return values
+	}
+	expectSeries := func(values map[clientmodel.LabelValue]clientmodel.SampleValue, present, dropped []clientmodel.LabelValue) {
+		for _, name := range present {
+			if _, ok := values[name]; !ok {
+				t.Errorf("Expected series %s to be ingested, got %v", name, values)
+			}
+		}
+		for _, name := range dropped {
+			if _, ok := values[name]; ok {
+				t.Errorf("Expected series %s to be dropped, got %v", name, values)
+			}
+		}
+	}
+
+	values := scrape("metric_a 1\nmetric_b 1\n")
+	expectSeries(values, []clientmodel.LabelValue{"metric_a", "metric_b"}, []clientmodel.LabelValue{scrapeSeriesCappedMetricName})
+
+	// New series are dropped while the established ones persist.
+	values = scrape("metric_a 2\nmetric_b 2\nmetric_c 2\n")
+	expectSeries(values, []clientmodel.LabelValue{"metric_a", "metric_b"}, []clientmodel.LabelValue{"metric_c"})
+	if v := values[scrapeSeriesCappedMetricName]; v != 1 {
+		t.Errorf("Expected %s to be 1, got %v", scrapeSeriesCappedMetricName, v)
+	}
+	values = scrape("metric_a 3\nmetric_b 3\nmetric_c 3\nmetric_d 3\n")
+	expectSeries(values, []clientmodel.LabelValue{"metric_a", "metric_b"}, []clientmodel.LabelValue{"metric_c", "metric_d"})
+	if v := values[scrapeSeriesCappedMetricName]; v != 3 {
+		t.Errorf("Expected %s to be 3, got %v", scrapeSeriesCappedMetricName, v)
+	}
+
+	// A series that disappeared for a whole scrape makes room for a new one.
+	values = scrape("metric_a 4\nmetric_c 4\n")
+	expectSeries(values, []clientmodel.LabelValue{"metric_a"}, []clientmodel.LabelValue{"metric_c"})
+	values = scrape("metric_a 5\nmetric_c 5\n")
+	expectSeries(values, []clientmodel.LabelValue{"metric_a", "metric_c"}, nil)
+	if v := values[scrapeSeriesCappedMetricName]; v != 4 {
+		t.Errorf("Expected %s to stay at 4, got %v", scrapeSeriesCappedMetricName, v)
+	}
+}
+
+func TestTargetScrapeValueTransforms(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("transferred_bytes 10\ntransferred_bytes_total 10\ntemperature_celsius 20\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeInterval: config.Duration(time.Second),
+		ScrapeTimeout:  config.Duration(time.Second),
+		MetricsPath:    "/metrics",
+		ValueTransforms: []*config.ValueTransformConfig{
+			{MetricName: &config.Regexp{*regexp.MustCompile("transferred_bytes")}, Scale: 8},
+			{MetricName: &config.Regexp{*regexp.MustCompile("temperature_.*")}, Scale: 1.8, Offset: 32},
+		},
+	}
+	testTarget, err := NewTarget(cfg, clientmodel.LabelSet{
+		clientmodel.SchemeLabel:  "http",
+		clientmodel.AddressLabel: clientmodel.LabelValue(strings.TrimPrefix(server.URL, "http://")),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+	values := map[clientmodel.LabelValue]clientmodel.SampleValue{}
+	for _, s := range appender.result {
+		values[s.Metric[clientmodel.MetricNameLabel]] = s.Value
+	}
+
+	expected := map[clientmodel.LabelValue]clientmodel.SampleValue{
+		"transferred_bytes": 80,
+		// The metric name must match entirely.
+		"transferred_bytes_total": 10,
+		"temperature_celsius":     68,
+		// The synthetic metrics are not transformed.
+		scrapeHealthMetricName: 1,
+	}
+	for name, v := range expected {
+		if values[name] != v {
+			t.Errorf("Expected value %v for %s, got %v", v, name, values[name])
+		}
+	}
+}
+
+func TestTargetScrapeMetricNameAllowlist(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("http_requests_total 1\nhttp_requests_failed_total 2\nprocess_cpu_seconds_total 3\ngo_goroutines 4\ngo_threads 5\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeInterval: config.Duration(time.Second),
+		ScrapeTimeout:  config.Duration(time.Second),
+		MetricNameAllowlist: []*config.Regexp{
+			{*regexp.MustCompile("http_requests_total")},
+			{*regexp.MustCompile("go_.*")},
+		},
+	}
+	testTarget, err := NewTarget(cfg, clientmodel.LabelSet{
+		clientmodel.SchemeLabel:  "http",
+		clientmodel.AddressLabel: clientmodel.LabelValue(strings.TrimPrefix(server.URL, "http://")),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+	names := map[clientmodel.LabelValue]bool{}
+	for _, s := range appender.result {
+		names[s.Metric[clientmodel.MetricNameLabel]] = true
+	}
+
+	expected := map[clientmodel.LabelValue]bool{
+		"http_requests_total": true,
+		// The metric name must match entirely.
+		"http_requests_failed_total": false,
+		"process_cpu_seconds_total":  false,
+		"go_goroutines":              true,
+		"go_threads":                 true,
+		// The synthetic metrics are always kept.
+		scrapeHealthMetricName: true,
+	}
+	for name, kept := range expected {
+		if names[name] != kept {
+			t.Errorf("Expected %s to be kept: %v", name, kept)
+		}
+	}
+	if got := testTarget.status.DisallowedSamples(); got != 2 {
+		t.Errorf("Expected 2 disallowed samples, got %d", got)
+	}
+}
+
+func TestTargetScrapeNonFiniteValues(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("nan_metric NaN\ninf_metric +Inf\nfinite_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	scenarios := []struct {
+		action    config.NonFiniteValueAction
+		expected  map[clientmodel.LabelValue]float64
+		nonFinite uint64
+	}{
+		{
+			action: "",
+			expected: map[clientmodel.LabelValue]float64{
+				"nan_metric":    math.NaN(),
+				"inf_metric":    math.Inf(1),
+				"finite_metric": 1,
+			},
+		},
+		{
+			action: config.NonFinitePass,
+			expected: map[clientmodel.LabelValue]float64{
+				"nan_metric":    math.NaN(),
+				"inf_metric":    math.Inf(1),
+				"finite_metric": 1,
+			},
+		},
+		{
+			action: config.NonFiniteDrop,
+			expected: map[clientmodel.LabelValue]float64{
+				"finite_metric": 1,
+			},
+			nonFinite: 2,
+		},
+		{
+			action: config.NonFiniteClamp,
+			expected: map[clientmodel.LabelValue]float64{
+				"nan_metric":    0,
+				"inf_metric":    math.MaxFloat64,
+				"finite_metric": 1,
+			},
+			nonFinite: 2,
+		},
+	}
+
+	for i, s := range scenarios {
+		cfg := &config.ScrapeConfig{
+			ScrapeInterval:       config.Duration(time.Second),
+			ScrapeTimeout:        config.Duration(time.Second),
+			NonFiniteValueAction: s.action,
+		}
+		testTarget, err := NewTarget(cfg, clientmodel.LabelSet{
+			clientmodel.SchemeLabel:  "http",
+			clientmodel.AddressLabel: clientmodel.LabelValue(strings.TrimPrefix(server.URL, "http://")),
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		appender := &collectResultAppender{}
+		if err := testTarget.scrape(appender); err != nil {
+			t.Fatalf("%d. %s", i, err)
+		}
+		values := map[clientmodel.LabelValue]float64{}
+		for _, smpl := range appender.result {
+			name := smpl.Metric[clientmodel.MetricNameLabel]
+			if !strings.HasPrefix(string(name), "scrape_") && name != scrapeHealthMetricName {
+				values[name] = float64(smpl.Value)
+			}
+		}
+		if len(values) != len(s.expected) {
+			t.Errorf("%d. Expected %d scraped samples, got %d: %v", i, len(s.expected), len(values), values)
+		}
+		for name, want := range s.expected {
+			got, ok := values[name]
+			if !ok {
+				t.Errorf("%d. Expected sample for %s", i, name)
+				continue
+			}
+			if got != want && !(math.IsNaN(got) && math.IsNaN(want)) {
+				t.Errorf("%d. Expected value %v for %s, got %v", i, want, name, got)
+			}
+		}
+		if got := testTarget.status.NonFiniteSamples(); got != s.nonFinite {
+			t.Errorf("%d. Expected %d non-finite samples, got %d", i, s.nonFinite, got)
+		}
+	}
+}
+
+func TestTargetScrapeValueFilters(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte(`temperature_celsius{sensor="a"} 21.5` + "\n"))
+				w.Write([]byte(`temperature_celsius{sensor="b"} -40` + "\n"))
+				w.Write([]byte(`temperature_celsius{sensor="c"} 200` + "\n"))
+				w.Write([]byte(`temperature_celsius{sensor="d"} NaN` + "\n"))
+				w.Write([]byte(`humidity_percent -1` + "\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	min, max := 0.0, 100.0
+	cfg := &config.ScrapeConfig{
+		ScrapeInterval: config.Duration(time.Second),
+		ScrapeTimeout:  config.Duration(time.Second),
+		ValueFilters: []*config.ValueFilterConfig{
+			{
+				MetricName: &config.Regexp{*regexp.MustCompile("temperature_.*")},
+				Min:        &min,
+				Max:        &max,
+			},
+		},
+	}
+	testTarget, err := NewTarget(cfg, clientmodel.LabelSet{
+		clientmodel.SchemeLabel:  "http",
+		clientmodel.AddressLabel: clientmodel.LabelValue(strings.TrimPrefix(server.URL, "http://")),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, smpl := range appender.result {
+		name := smpl.Metric[clientmodel.MetricNameLabel]
+		if !strings.HasPrefix(string(name), "scrape_") && name != scrapeHealthMetricName {
+			got[string(name)+"/"+string(smpl.Metric["sensor"])] = true
+		}
+	}
+	// Non-finite values and samples of other metrics are not filtered.
+	expected := map[string]bool{
+		"temperature_celsius/a": true,
+		"temperature_celsius/d": true,
+		"humidity_percent/":     true,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected samples %v, got %v", expected, got)
+	}
+	if n := testTarget.status.OutOfRangeSamples(); n != 2 {
+		t.Errorf("Expected 2 out-of-range samples, got %d", n)
+	}
+}
+
+func TestTargetScrapeAdditionalPaths(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+		w.Write([]byte("test_metric_1 1\n"))
+	})
+	mux.HandleFunc("/extra", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+		w.Write([]byte("test_metric_2 2\n"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.additionalPaths = []string{"/extra"}
+
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+	if testTarget.status.Health() != HealthGood {
+		t.Errorf("Expected target state %v, actual: %v", HealthGood, testTarget.status.Health())
+	}
+
+	got := map[clientmodel.LabelValue]clientmodel.SampleValue{}
+	for _, s := range appender.result {
+		if _, ok := got[s.Metric[clientmodel.MetricNameLabel]]; ok {
+			t.Errorf("Unexpected duplicate sample %s", s.Metric)
+		}
+		got[s.Metric[clientmodel.MetricNameLabel]] = s.Value
+	}
+	for name, value := range map[clientmodel.LabelValue]clientmodel.SampleValue{
+		"test_metric_1":        1,
+		"test_metric_2":        2,
+		scrapeHealthMetricName: 1,
+	} {
+		if v, ok := got[name]; !ok || v != value {
+			t.Errorf("Expected sample %s with value %v, got %v", name, value, v)
+		}
+	}
+
+	// A failing additional path makes the target partially healthy.
+	testTarget.additionalPaths = []string{"/extra", "/missing"}
+	appender = &collectResultAppender{}
+	if err := testTarget.scrape(appender); err == nil {
+		t.Fatal("Expected error scraping missing path")
+	}
+	if testTarget.status.Health() != HealthPartial {
+		t.Errorf("Expected target state %v, actual: %v", HealthPartial, testTarget.status.Health())
+	}
+}
+
+func TestTargetScrapeDuplicateSamples(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric{foo=\"bar\"} 1\n"))
+				w.Write([]byte("other_metric 3\n"))
+				w.Write([]byte("test_metric{foo=\"bar\"} 2\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	for _, test := range []struct {
+		handling config.DuplicateSampleHandling
+		values   []clientmodel.SampleValue
+		fails    bool
+	}{
+		// Without deduplication all samples are appended in order.
+		{handling: "", values: []clientmodel.SampleValue{1, 2}},
+		{handling: config.DuplicateSampleLastWins, values: []clientmodel.SampleValue{2}},
+		{handling: config.DuplicateSampleFirstWins, values: []clientmodel.SampleValue{1}},
+		{handling: config.DuplicateSampleError, fails: true},
+	} {
+		testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+		testTarget.duplicateSampleHandling = test.handling
+
+		appender := &collectResultAppender{}
+		err := testTarget.scrape(appender)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%q: expected error for duplicate samples", test.handling)
+			}
+			for _, s := range appender.result {
+				if s.Metric[clientmodel.MetricNameLabel] == "test_metric" {
+					t.Errorf("%q: unexpected sample %s", test.handling, s)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %s", test.handling, err)
+		}
+
+		var found []clientmodel.SampleValue
+		for _, s := range appender.result {
+			if s.Metric[clientmodel.MetricNameLabel] == "test_metric" {
+				found = append(found, s.Value)
+			}
+		}
+		if !reflect.DeepEqual(found, test.values) {
+			t.Errorf("%q: expected sample values %v, got %v", test.handling, test.values, found)
+		}
+	}
+}
+
+func TestTargetScrapeDropMatchers(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric{foo=\"bar\"} 1\n"))
+				w.Write([]byte("test_metric{foo=\"baz\"} 2\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	matchers, err := promql.ParseMetricSelector(`{foo=~"ba."}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.dropMatchers = []metric.LabelMatchers{matchers}
+
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+	// Only the synthetic samples of the scrape remain.
+	for _, s := range appender.result {
+		if s.Metric[clientmodel.MetricNameLabel] == "test_metric" {
+			t.Errorf("Unexpected sample %s", s)
+		}
+	}
+	if len(appender.result) == 0 {
+		t.Error("Expected synthetic scrape samples to be appended")
+	}
+}
+
+func TestTargetScrapeRetriesDNSErrors(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	// The stubbed resolver fails for the first lookup only.
+	var lookups int32
+	dialer := &net.Dialer{}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if atomic.AddInt32(&lookups, 1) == 1 {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: &net.DNSError{Err: "no such host", Name: addr}}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.httpClient = &http.Client{Transport: &http.Transport{DialContext: dial}}
+
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatalf("Expected scrape to succeed after DNS error, got %s", err)
+	}
+	if got := atomic.LoadInt32(&lookups); got != 2 {
+		t.Errorf("Expected 2 lookups, got %d", got)
+	}
+
+	// Other connection errors are not retried.
+	atomic.StoreInt32(&lookups, 0)
+	testTarget.httpClient = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&lookups, 1)
+			return nil, &net.OpError{Op: "dial", Net: network, Err: errors.New("connection refused")}
+		},
+	}}
+	if err := testTarget.scrape(nopAppender{}); err == nil {
+		t.Fatal("Expected scrape to fail")
+	}
+	if got := atomic.LoadInt32(&lookups); got != 1 {
+		t.Errorf("Expected 1 connection attempt, got %d", got)
+	}
+	if testTarget.status.Health() != HealthBad {
+		t.Errorf("Expected target state %v, actual: %v", HealthBad, testTarget.status.Health())
+	}
+}
+
+func TestTargetScrapeRetriesServerErrors(t *testing.T) {
+	var (
+		requests   int32
+		retryAfter string
+	)
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) == 1 {
+					w.Header().Set("Retry-After", retryAfter)
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	scenarios := []struct {
+		retry      bool
+		retryAfter string
+		requests   int32
+		health     TargetHealth
+	}{
+		{
+			retry:      true,
+			retryAfter: "0",
+			requests:   2,
+			health:     HealthGood,
+		},
+		{
+			// Retries are disabled.
+			retry:      false,
+			retryAfter: "0",
+			requests:   1,
+			health:     HealthBad,
+		},
+		{
+			// The requested delay exceeds the deadline.
+			retry:      true,
+			retryAfter: "120",
+			requests:   1,
+			health:     HealthBad,
+		},
+	}
+	for i, s := range scenarios {
+		atomic.StoreInt32(&requests, 0)
+		retryAfter = s.retryAfter
+
+		testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+		testTarget.retryServerErrors = s.retry
+		appender := &collectResultAppender{}
+		testTarget.scrape(appender)
+
+		if got := atomic.LoadInt32(&requests); got != s.requests {
+			t.Errorf("%d. Expected %d requests, got %d", i, s.requests, got)
+		}
+		if got := testTarget.status.Health(); got != s.health {
+			t.Errorf("%d. Expected target state %v, got %v", i, s.health, got)
+		}
+		if s.health == HealthGood && len(appender.result) == 0 {
+			t.Errorf("%d. Expected samples of the retried scrape", i)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	scenarios := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{value: "", expected: 0},
+		{value: "0", expected: 0},
+		{value: "5", expected: 5 * time.Second},
+		{value: "-5", expected: 0},
+		{value: "Mon, 01 Jun 2015 12:00:30 GMT", expected: 30 * time.Second},
+		{value: "Mon, 01 Jun 2015 11:00:00 GMT", expected: 0},
+		{value: "soon", expected: 0},
+	}
+	for _, s := range scenarios {
+		if got := retryAfter(s.value, now); got != s.expected {
+			t.Errorf("Expected delay %v for Retry-After %q, got %v", s.expected, s.value, got)
+		}
+	}
+}
+
+func TestTargetPauseResume(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 100*time.Millisecond, clientmodel.LabelSet{})
+	testTarget.Pause()
+	if !testTarget.Paused() {
+		t.Fatal("Expected target to be paused")
+	}
+
+	appender := &countingAppender{}
+	go testTarget.RunScraper(appender)
+	defer testTarget.StopScraper()
+
+	time.Sleep(50 * time.Millisecond)
+	if n := appender.Count(); n != 0 {
+		t.Fatalf("Expected no samples while paused, got %d", n)
+	}
+	if !testTarget.status.LastScrape().IsZero() {
+		t.Fatalf("Expected no scrape while paused, got last scrape at %s", testTarget.status.LastScrape())
+	}
+
+	testTarget.Resume()
+	deadline := time.Now().Add(time.Second)
+	for appender.Count() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected scrapes after resuming")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	testTarget.Pause()
+	// Let a scrape in progress complete.
+	time.Sleep(20 * time.Millisecond)
+	lastScrape := testTarget.status.LastScrape()
+	n := appender.Count()
+	time.Sleep(50 * time.Millisecond)
+	if got := appender.Count(); got != n {
+		t.Errorf("Expected %d samples after pausing, got %d", n, got)
+	}
+	if got := testTarget.status.LastScrape(); !got.Equal(lastScrape) {
+		t.Errorf("Expected last scrape to remain %s while paused, got %s", lastScrape, got)
+	}
+}
+
+// tenantAppender records the tenants of appended samples.
+type tenantAppender struct {
+	tenants []string
+}
+
+func (a *tenantAppender) Append(s *clientmodel.Sample) {
+	a.tenants = append(a.tenants, "")
+}
+
+func (a *tenantAppender) AppendWithMetadata(s *clientmodel.Sample, md *storage.SampleMetadata) {
+	a.tenants = append(a.tenants, md.TenantID)
+}
+
+func TestTargetScrapeTenantID(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.tenantID = "tenant-a"
+
+	// The metadata must also reach appenders behind a fanout.
+	appender := &tenantAppender{}
+	if err := testTarget.scrape(storage.Fanout{appender, nopAppender{}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(appender.tenants) == 0 {
+		t.Fatal("Expected samples to be appended")
+	}
+	for _, tenant := range appender.tenants {
+		if tenant != "tenant-a" {
+			t.Errorf("Expected tenant %q, got %q", "tenant-a", tenant)
+		}
+	}
+}
+
+func TestTargetCheckReachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+
+	err := testTarget.CheckReachable(context.Background())
+	if err == nil {
+		t.Fatal("Expected error checking target returning 404")
+	}
+	if !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected error to contain the 404 status, got %q", err)
+	}
+	// The check does not count as a scrape.
+	if testTarget.status.Health() != HealthUnknown {
+		t.Errorf("Expected target state %v, actual: %v", HealthUnknown, testTarget.status.Health())
+	}
+	if !testTarget.status.LastScrape().IsZero() {
+		t.Errorf("Expected no last scrape, got %s", testTarget.status.LastScrape())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := testTarget.CheckReachable(ctx); err == nil {
+		t.Error("Expected error checking target with canceled context")
+	}
+}
+
+func TestTargetCheckReachableDuringScrape(t *testing.T) {
+	const numSeries = 100
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				for i := 0; i < numSeries; i++ {
+					fmt.Fprintf(w, "test_metric{i=\"%d\"} 1\n", i)
+				}
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			if err := testTarget.CheckReachable(context.Background()); err != nil {
+				t.Errorf("Unexpected error checking target: %s", err)
+			}
+			if _, err := testTarget.ScrapeSampleFraction(0.5); err != nil {
+				t.Errorf("Unexpected error scraping sample fraction: %s", err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			app := &collectResultAppender{}
+			if err := testTarget.scrape(app); err != nil {
+				t.Errorf("Unexpected error scraping target: %s", err)
+			}
+			n := 0
+			for _, s := range app.result {
+				if s.Metric[clientmodel.MetricNameLabel] == "test_metric" {
+					n++
+				}
+			}
+			if n != numSeries {
+				t.Errorf("Expected %d scraped samples, got %d", numSeries, n)
+			}
+		}
+	}()
+	wg.Wait()
+
+	if n := testTarget.status.LastScrapeSampleCount(); n != numSeries {
+		t.Errorf("Expected last scrape sample count %d, got %d", numSeries, n)
+	}
+}
+
+func TestTargetScrapeTimestampTolerance(t *testing.T) {
+	future := clientmodel.Now().Add(time.Hour)
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric_1 1\n"))
+				w.Write([]byte(fmt.Sprintf("test_metric_2 2 %d\n", future)))
+			},
+		),
+	)
+	defer server.Close()
+
+	scrapeTestMetrics := func(action config.TimestampToleranceAction) (*Target, map[clientmodel.LabelValue]clientmodel.Timestamp) {
+		testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+		testTarget.timestampTolerance = time.Minute
+		testTarget.timestampToleranceAction = action
+
+		appender := &collectResultAppender{}
+		if err := testTarget.scrape(appender); err != nil {
+			t.Fatal(err)
+		}
+		timestamps := map[clientmodel.LabelValue]clientmodel.Timestamp{}
+		for _, s := range appender.result {
+			timestamps[s.Metric[clientmodel.MetricNameLabel]] = s.Timestamp
+		}
+		return testTarget, timestamps
+	}
+
+	testTarget, timestamps := scrapeTestMetrics(config.TimestampReject)
+	if _, ok := timestamps["test_metric_1"]; !ok {
+		t.Error("Expected sample without timestamp to be appended")
+	}
+	if _, ok := timestamps["test_metric_2"]; ok {
+		t.Error("Expected future sample to be rejected")
+	}
+	if got := testTarget.status.RejectedSamples(); got != 1 {
+		t.Errorf("Expected 1 rejected sample, got %d", got)
+	}
+
+	testTarget, timestamps = scrapeTestMetrics(config.TimestampClamp)
+	ts, ok := timestamps["test_metric_2"]
+	if !ok {
+		t.Fatal("Expected future sample to be clamped but appended")
+	}
+	if !ts.Before(future) || ts.Before(clientmodel.Now()) {
+		t.Errorf("Expected timestamp clamped to about a minute from now, got %s", ts.Time())
+	}
+	if got := testTarget.status.RejectedSamples(); got != 0 {
+		t.Errorf("Expected no rejected samples, got %d", got)
+	}
+}
+
+func TestTargetScrapeTimestampHeader(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Header().Set("X-Prometheus-Scrape-Timestamp", "1400000000.5")
+				w.Write([]byte("test_metric_1 1\n"))
+				w.Write([]byte("test_metric_2 2 1300000000000\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{})
+	testTarget.timestampHeader = "X-Prometheus-Scrape-Timestamp"
+
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[clientmodel.LabelValue]clientmodel.Timestamp{
+		"test_metric_1": clientmodel.TimestampFromUnixNano(1400000000500 * int64(time.Millisecond)),
+		"test_metric_2": clientmodel.TimestampFromUnix(1300000000),
+	}
+	for _, s := range appender.result {
+		name := s.Metric[clientmodel.MetricNameLabel]
+		ts, ok := expected[name]
+		if !ok {
+			// The synthetic samples keep the time of the scrape.
+			if s.Timestamp == expected["test_metric_1"] {
+				t.Errorf("Unexpected reported timestamp for %s", name)
+			}
+			continue
+		}
+		if s.Timestamp != ts {
+			t.Errorf("Expected timestamp %v for %s, got %v", ts, name, s.Timestamp)
+		}
+	}
+}
+
+func TestTargetRunScraperScrapes(t *testing.T) {
+	testTarget := newTestTarget("bad schema", 0, nil)
+
+	go testTarget.RunScraper(nopAppender{})
+
+	// Enough time for a scrape to happen.
+	time.Sleep(10 * time.Millisecond)
+	if testTarget.status.LastScrape().IsZero() {
+		t.Errorf("Scrape hasn't occured.")
+	}
+
+	testTarget.StopScraper()
+	// Wait for it to take effect.
+	time.Sleep(5 * time.Millisecond)
+	last := testTarget.status.LastScrape()
+	// Enough time for a scrape to happen.
+	time.Sleep(10 * time.Millisecond)
+	if testTarget.status.LastScrape() != last {
+		t.Errorf("Scrape occured after it was stopped.")
+	}
+}
+
+func TestTargetStopScraperTimeout(t *testing.T) {
+	scraping := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case scraping <- struct{}{}:
+				default:
+				}
+				<-unblock
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 10*time.Second, clientmodel.LabelSet{})
+	testTarget.scrapeInterval = time.Minute
+	testTarget.initialScrapeDelay = time.Millisecond
+	go testTarget.RunScraper(nopAppender{})
+
+	select {
+	case <-scraping:
+	case <-time.After(time.Second):
+		t.Fatal("Scrape did not start")
+	}
+	if err := testTarget.StopScraperTimeout(20 * time.Millisecond); err == nil {
+		t.Fatal("Expected error stopping scraper stuck in a scrape")
+	}
+
+	// The scraper stops once the scrape finishes.
+	close(unblock)
+	select {
+	case <-testTarget.scraperStopped:
+	case <-time.After(time.Second):
+		t.Fatal("Scraper did not stop after the scrape finished")
+	}
+
+	// A scraper that is not stuck stops in time.
+	idleTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	idleTarget.scrapeInterval = time.Minute
+	idleTarget.Pause()
+	go idleTarget.RunScraper(nopAppender{})
+	if err := idleTarget.StopScraperTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTargetRunScraperInitialDelay(t *testing.T) {
+	scraped := make(chan time.Time, 1)
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case scraped <- time.Now():
+				default:
+				}
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	const (
+		seed     = 42
+		maxDelay = 200 * time.Millisecond
+	)
+	expectedDelay := time.Duration(float64(maxDelay) * rand.New(rand.NewSource(seed)).Float64())
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.scrapeInterval = time.Minute
+	testTarget.initialScrapeDelay = maxDelay
+	testTarget.initialDelayRand = rand.New(rand.NewSource(seed))
+
+	start := time.Now()
+	go testTarget.RunScraper(nopAppender{})
+	defer testTarget.StopScraper()
+
+	select {
+	case at := <-scraped:
+		delay := at.Sub(start)
+		if delay < expectedDelay {
+			t.Errorf("Expected first scrape after %v, got it after %v", expectedDelay, delay)
+		}
+		if delay > maxDelay+100*time.Millisecond {
+			t.Errorf("Expected first scrape within %v, got it after %v", maxDelay, delay)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("First scrape did not happen")
+	}
+}
+
+func TestTargetRunScraperMulti(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric_1 1\ntest_metric_2 2\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{})
+	testTarget.scrapeInterval = 10 * time.Millisecond
+
+	app1, app2 := &collectResultAppender{}, &collectResultAppender{}
+	go testTarget.RunScraperMulti(app1, app2)
+
+	// Enough time for a scrape to happen.
+	time.Sleep(30 * time.Millisecond)
+	testTarget.StopScraper()
+
+	if len(app1.result) == 0 {
+		t.Fatalf("No samples were appended")
+	}
+	if !app1.result.Equal(app2.result) {
+		t.Fatalf("Appenders received different samples: %v and %v", app1.result, app2.result)
+	}
+}
+
+func BenchmarkScrape(b *testing.B) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric{foo=\"bar\"} 123.456\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 100*time.Millisecond, clientmodel.LabelSet{"dings": "bums"})
+	appender := nopAppender{}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := testTarget.scrape(appender); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkScrapeParallelParse compares sequential and concurrent parsing of
+// a large payload.
+func BenchmarkScrapeParallelParse(b *testing.B) {
+	payload := textPayload(200, 100)
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+				w.Write([]byte(payload))
+			},
+		),
+	)
+	defer server.Close()
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			testTarget := newTestTarget(server.URL, 10*time.Second, clientmodel.LabelSet{"dings": "bums"})
+			testTarget.parallelParseThreshold = 1
+			testTarget.parallelParseWorkers = workers
+			appender := nopAppender{}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := testTarget.scrape(appender); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkScrapeInternLabels compares scrapes with and without interning of
+// labels. The parser allocates the strings of each sample either way, so
+// interning does not reduce the allocations of a scrape but the memory held
+// by the appended samples.
+func BenchmarkScrapeInternLabels(b *testing.B) {
+	for _, intern := range []bool{false, true} {
+		b.Run(fmt.Sprintf("intern=%t", intern), func(b *testing.B) {
+			server := httptest.NewServer(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+						for i := 0; i < 100; i++ {
+							w.Write([]byte(fmt.Sprintf("test_metric{foo=\"bar\",i=\"%d\"} 123.456\n", i)))
+						}
+					},
+				),
+			)
+			defer server.Close()
+
+			testTarget := newTestTarget(server.URL, 100*time.Millisecond, clientmodel.LabelSet{"dings": "bums"})
+			testTarget.internLabels = intern
+			appender := nopAppender{}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := testTarget.scrape(appender); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkScrapeBatchingAppender(b *testing.B) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric{foo=\"bar\"} 123.456\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 100*time.Millisecond, clientmodel.LabelSet{"dings": "bums"})
+	appender := NewBatchingAppender(nopBatchAppender{})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := testTarget.scrape(appender); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTargetScrapeFlushesBatchingAppender(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric_1 1\n"))
+				w.Write([]byte("test_metric_2 2\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	collector := &batchCollector{}
+	if err := testTarget.scrape(NewBatchingAppender(collector)); err != nil {
+		t.Fatal(err)
+	}
+	if len(collector.batches) != 1 {
+		t.Fatalf("Expected a single batch, got %d", len(collector.batches))
+	}
+	// The batch contains the scraped and the synthetic samples.
+	batch := collector.batches[0]
+	names := map[clientmodel.LabelValue]bool{}
+	for _, m := range batch.Metrics {
+		names[m[clientmodel.MetricNameLabel]] = true
+	}
+	for _, name := range []clientmodel.LabelValue{"test_metric_1", "test_metric_2", scrapeHealthMetricName} {
+		if !names[name] {
+			t.Errorf("Expected sample %s in batch", name)
+		}
+	}
+	if len(batch.Timestamps) != batch.Len() || len(batch.Values) != batch.Len() {
+		t.Errorf("Expected parallel slices of length %d, got %d timestamps and %d values", batch.Len(), len(batch.Timestamps), len(batch.Values))
+	}
+}
+
+func TestTargetScrapeSharedBatchingAppender(t *testing.T) {
+	const numScrapes = 20
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(
+			http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+					for i := 0; i < 10; i++ {
+						fmt.Fprintf(w, "%s{i=\"%d\"} 1\n", name, i)
+					}
+				},
+			),
+		)
+	}
+	serverA, serverB := newServer("metric_a"), newServer("metric_b")
+	defer serverA.Close()
+	defer serverB.Close()
+
+	collector := &batchCollector{}
+	appender := NewBatchingAppender(collector)
+
+	var wg sync.WaitGroup
+	for _, server := range []*httptest.Server{serverA, serverB} {
+		testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < numScrapes; i++ {
+				if err := testTarget.scrape(appender); err != nil {
+					t.Errorf("Unexpected error scraping target: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(collector.batches) != 2*numScrapes {
+		t.Fatalf("Expected %d batches, got %d", 2*numScrapes, len(collector.batches))
+	}
+	// Each batch holds all samples of a single scrape.
+	for i, batch := range collector.batches {
+		counts := map[clientmodel.LabelValue]int{}
+		for _, m := range batch.Metrics {
+			counts[m[clientmodel.MetricNameLabel]]++
+		}
+		if (counts["metric_a"] == 0) == (counts["metric_b"] == 0) {
+			t.Errorf("%d. Expected samples of a single target, got %v", i, counts)
+		}
+		if counts["metric_a"]+counts["metric_b"] != 10 || counts[scrapeHealthMetricName] != 1 {
+			t.Errorf("%d. Expected all samples of a scrape, got %v", i, counts)
+		}
+	}
+}
+
+func BenchmarkScrapeCountingAppender(b *testing.B) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				for i := 0; i < 100; i++ {
+					w.Write([]byte(fmt.Sprintf("test_metric_%d{foo=\"bar\"} 123.456\n", i)))
+				}
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, 100*time.Millisecond, clientmodel.LabelSet{"dings": "bums"})
+	appender := &countingAppender{}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := testTarget.scrape(appender); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	if appender.Count() == 0 {
+		b.Fatal("No samples were appended")
+	}
+}
+
+func TestURLParams(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte{})
+				r.ParseForm()
+				if r.Form["foo"][0] != "bar" {
+					t.Fatalf("URL parameter 'foo' had unexpected first value '%v'", r.Form["foo"][0])
+				}
+				if r.Form["foo"][1] != "baz" {
+					t.Fatalf("URL parameter 'foo' had unexpected second value '%v'", r.Form["foo"][1])
+				}
+			},
+		),
+	)
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := NewTarget(
+		&config.ScrapeConfig{
+			JobName:        "test_job1",
+			ScrapeInterval: config.Duration(1 * time.Minute),
+			ScrapeTimeout:  config.Duration(1 * time.Second),
+			Scheme:         serverURL.Scheme,
+			Params: url.Values{
+				"foo": []string{"bar", "baz"},
+			},
+		},
+		clientmodel.LabelSet{
+			clientmodel.SchemeLabel:  clientmodel.LabelValue(serverURL.Scheme),
+			clientmodel.AddressLabel: clientmodel.LabelValue(serverURL.Host),
+			"__param_foo":            "bar",
+		},
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &collectResultAppender{}
+	if err = target.scrape(app); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestURLSchemeFallback(t *testing.T) {
+	target, err := NewTarget(
+		&config.ScrapeConfig{
+			JobName:        "test_job1",
+			ScrapeInterval: config.Duration(1 * time.Minute),
+			ScrapeTimeout:  config.Duration(1 * time.Second),
+			Scheme:         "https",
+		},
+		clientmodel.LabelSet{
+			clientmodel.AddressLabel:     "example.org:8443",
+			clientmodel.MetricsPathLabel: "/metrics",
+		},
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := target.URL().String(), "https://example.org:8443/metrics"; got != want {
+		t.Errorf("Expected URL %q, got %q", want, got)
+	}
+}
+
+func TestURLSchemeMissing(t *testing.T) {
+	cfg := &config.ScrapeConfig{
+		JobName:        "test_job1",
+		ScrapeInterval: config.Duration(1 * time.Minute),
+		ScrapeTimeout:  config.Duration(1 * time.Second),
+	}
+	labels := clientmodel.LabelSet{
+		clientmodel.AddressLabel:     "example.org:8443",
+		clientmodel.MetricsPathLabel: "/metrics",
+	}
+	if _, err := NewTarget(cfg, labels, nil); err == nil {
+		t.Fatal("Expected error creating target without scheme")
+	}
+
+	cfg.Scheme = "https"
+	target, err := NewTarget(cfg, labels, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A failed update leaves the target unchanged.
+	if err := target.Update(&config.ScrapeConfig{MetricsPath: "/other"}, labels, nil); err == nil {
+		t.Fatal("Expected error updating target without scheme")
+	}
+	if got, want := target.URL().String(), "https://example.org:8443/metrics"; got != want {
+		t.Errorf("Expected URL %q, got %q", want, got)
+	}
+}
+
+func TestURLParamsLabelExpansion(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte{})
+				r.ParseForm()
+				form = r.Form
+			},
+		),
+	)
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := NewTarget(
+		&config.ScrapeConfig{
+			JobName:        "test_job1",
+			ScrapeInterval: config.Duration(1 * time.Minute),
+			ScrapeTimeout:  config.Duration(1 * time.Second),
+			Scheme:         serverURL.Scheme,
+			Params: url.Values{
+				"target":  []string{"${__address__}"},
+				"module":  []string{"http_${module}"},
+				"missing": []string{"${not_set}"},
+			},
+		},
+		clientmodel.LabelSet{
+			clientmodel.SchemeLabel:  clientmodel.LabelValue(serverURL.Scheme),
+			clientmodel.AddressLabel: clientmodel.LabelValue(serverURL.Host),
+			"module":                 "2xx",
+		},
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = target.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := form.Get("target"); got != serverURL.Host {
+		t.Errorf("Expected URL parameter 'target' to be %q, got %q", serverURL.Host, got)
+	}
+	if got := form.Get("module"); got != "http_2xx" {
+		t.Errorf("Expected URL parameter 'module' to be %q, got %q", "http_2xx", got)
+	}
+	if got, ok := form["missing"]; !ok || got[0] != "" {
+		t.Errorf("Expected URL parameter 'missing' to be empty, got %q", got)
+	}
+}
+
+func TestURLParamLabels(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte{})
+				r.ParseForm()
+				form = r.Form
+			},
+		),
+	)
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := NewTarget(
+		&config.ScrapeConfig{
+			JobName:        "test_job1",
+			ScrapeInterval: config.Duration(1 * time.Minute),
+			ScrapeTimeout:  config.Duration(1 * time.Second),
+			Scheme:         serverURL.Scheme,
+			Params: url.Values{
+				"foo":    []string{"bar"},
+				"module": []string{"default", "fallback"},
+			},
+			ParamLabels: map[string]clientmodel.LabelName{
+				"module":  "module",
+				"target":  "target",
+				"missing": "not_set",
+			},
+		},
+		clientmodel.LabelSet{
+			clientmodel.SchemeLabel:  clientmodel.LabelValue(serverURL.Scheme),
+			clientmodel.AddressLabel: clientmodel.LabelValue(serverURL.Host),
+			"module":                 "http_2xx",
+			"target":                 "example.com",
+		},
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = target.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := url.Values{
+		"foo":    []string{"bar"},
+		"module": []string{"http_2xx", "fallback"},
+		"target": []string{"example.com"},
+	}
+	if !reflect.DeepEqual(form, expected) {
+		t.Errorf("Expected URL parameters %v, got %v", expected, form)
+	}
+}
+
+func newTestTarget(targetURL string, deadline time.Duration, baseLabels clientmodel.LabelSet) *Target {
+	t := &Target{
+		url: &url.URL{
+			Scheme: "http",
+			Host:   strings.TrimPrefix(targetURL, "http://"),
+			Path:   "/metrics",
+		},
+		deadline:        deadline,
+		status:          &TargetStatus{},
+		scrapeInterval:  1 * time.Millisecond,
+		httpClient:      httputil.NewDeadlineClient(deadline, nil),
+		scraperStopping: make(chan struct{}),
+		scraperStopped:  make(chan struct{}),
+	}
+	t.baseLabels = clientmodel.LabelSet{
+		clientmodel.InstanceLabel: clientmodel.LabelValue(t.InstanceIdentifier()),
+	}
+	for baseLabel, baseValue := range baseLabels {
+		t.baseLabels[baseLabel] = baseValue
+	}
+	return t
+}
+
+// socks5Server is a minimal SOCKS5 proxy supporting the CONNECT command with
+// username/password authentication.
+type socks5Server struct {
+	listener           net.Listener
+	username, password string
+	connects           int32
+}
+
+func newSOCKS5Server(t *testing.T, username, password string) *socks5Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socks5Server{listener: l, username: username, password: password}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(c)
+		}
+	}()
+	return s
+}
+
+func (s *socks5Server) handle(c net.Conn) {
+	defer c.Close()
+
+	// Method negotiation. Only username/password authentication is accepted.
+	buf := make([]byte, 256)
+	if _, err := io.ReadFull(c, buf[:2]); err != nil || buf[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+		return
+	}
+	c.Write([]byte{5, 2})
+
+	// Username/password authentication (RFC 1929).
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return
+	}
+	user := make([]byte, buf[1])
+	if _, err := io.ReadFull(c, user); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(c, buf[:1]); err != nil {
+		return
+	}
+	pass := make([]byte, buf[0])
+	if _, err := io.ReadFull(c, pass); err != nil {
+		return
+	}
+	if string(user) != s.username || string(pass) != s.password {
+		c.Write([]byte{1, 1})
+		return
+	}
+	c.Write([]byte{1, 0})
+
+	// CONNECT request.
+	if _, err := io.ReadFull(c, buf[:4]); err != nil || buf[1] != 1 {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		if _, err := io.ReadFull(c, buf[:4]); err != nil {
+			return
+		}
+		host = net.IP(buf[:4]).String()
+	case 3:
+		if _, err := io.ReadFull(c, buf[:1]); err != nil {
+			return
+		}
+		name := make([]byte, buf[0])
+		if _, err := io.ReadFull(c, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return
+	}
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return
+	}
+	port := int(buf[0])<<8 | int(buf[1])
+
+	upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	atomic.AddInt32(&s.connects, 1)
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(upstream, c)
+	io.Copy(c, upstream)
+}
+
+func TestNewHTTPSOCKS5Proxy(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	proxy := newSOCKS5Server(t, "user", "secret")
+	defer proxy.listener.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeTimeout: config.Duration(1 * time.Second),
+		SOCKS5Proxy: &config.SOCKS5Proxy{
+			Address:  proxy.listener.Addr().String(),
+			Username: "user",
+			Password: "secret",
+		},
+	}
+	c, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := atomic.LoadInt32(&proxy.connects); got != 1 {
+		t.Errorf("Expected 1 connection through the SOCKS5 proxy, got %d", got)
+	}
+
+	// Wrong credentials are rejected by the proxy.
+	cfg.SOCKS5Proxy.Password = "wrong"
+	if c, err = newHTTPClient(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(server.URL); err == nil {
+		t.Error("Expected error connecting through the SOCKS5 proxy with wrong credentials")
+	}
+}
+
+// dnsServer is a minimal DNS server answering A queries for a single host
+// name over UDP after the given delay. Queries for other names or types are
+// answered without records.
+type dnsServer struct {
+	conn    net.PacketConn
+	name    string
+	ip      net.IP
+	delay   time.Duration
+	queries int32
+}
+
+func newDNSServer(t *testing.T, name string, ip net.IP, delay time.Duration) *dnsServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dnsServer{conn: conn, name: name, ip: ip.To4(), delay: delay}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := s.answer(buf[:n]); resp != nil {
+				time.Sleep(s.delay)
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return s
+}
+
+// answer returns the response to the given query message.
+func (s *dnsServer) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	// Decode the name of the only question.
+	var labels []string
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		l := int(query[i])
+		if i+1+l > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:i+1+l]))
+		i += 1 + l
+	}
+	if i+5 > len(query) {
+		return nil
+	}
+	question := query[12 : i+5]
+	qtype := int(query[i+1])<<8 | int(query[i+2])
+	atomic.AddInt32(&s.queries, 1)
+
+	resp := []byte{
+		query[0], query[1], // ID
+		0x81, 0x80, // Response, recursion desired and available.
+		0, 1, // Questions
+		0, 0, // Answers
+		0, 0, // Authority records
+		0, 0, // Additional records
+	}
+	resp = append(resp, question...)
+	if strings.Join(labels, ".") == s.name && qtype == 1 {
+		resp[7] = 1
+		resp = append(resp,
+			0xc0, 12, // Pointer to the question name.
+			0, 1, // Type A
+			0, 1, // Class IN
+			0, 0, 0, 60, // TTL
+			0, 4, // Data length
+		)
+		resp = append(resp, s.ip...)
+	}
+	return resp
+}
+
+func TestNewHTTPDNSServer(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dns := newDNSServer(t, "scrape-target.prometheus-test", net.ParseIP("127.0.0.1"), 0)
+	defer dns.conn.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeTimeout: config.Duration(1 * time.Second),
+		DNSServer:     dns.conn.LocalAddr().String(),
+	}
+	c, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get("http://" + net.JoinHostPort("scrape-target.prometheus-test", port) + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if atomic.LoadInt32(&dns.queries) == 0 {
+		t.Error("Expected the host name to be resolved by the configured DNS server")
+	}
+
+	// Unknown host names fail to resolve.
+	if _, err := c.Get("http://" + net.JoinHostPort("unknown.prometheus-test", port) + "/metrics"); err == nil {
+		t.Error("Expected error resolving unknown host name")
+	}
+}
+
+func TestTargetScrapeDeadlineExcludesConnect(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				// Together with the slow DNS lookup, responding takes longer
+				// than the scrape timeout.
+				time.Sleep(400 * time.Millisecond)
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dns := newDNSServer(t, "scrape-target.prometheus-test", net.ParseIP("127.0.0.1"), 200*time.Millisecond)
+	defer dns.conn.Close()
+
+	for _, exclude := range []bool{false, true} {
+		cfg := &config.ScrapeConfig{
+			ScrapeTimeout:           config.Duration(600 * time.Millisecond),
+			DNSServer:               dns.conn.LocalAddr().String(),
+			DeadlineExcludesConnect: exclude,
+		}
+		c, err := newHTTPClient(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testTarget := newTestTarget("http://"+net.JoinHostPort("scrape-target.prometheus-test", port), time.Duration(cfg.ScrapeTimeout), clientmodel.LabelSet{})
+		testTarget.httpClient = c
+		testTarget.deadlineExcludesConnect = exclude
+
+		start := time.Now()
+		err = testTarget.scrape(nopAppender{})
+		if exclude && err != nil {
+			t.Errorf("Expected the DNS lookup not to count against the deadline, got %v after %s", err, time.Since(start))
+		}
+		if !exclude && err == nil {
+			t.Errorf("Expected the DNS lookup to count against the deadline, scrape took %s", time.Since(start))
+		}
+	}
+}
+
+// hmacAuthProvider signs requests with an HMAC over their path and a
+// timestamp.
+type hmacAuthProvider struct {
+	key []byte
+}
+
+func (p *hmacAuthProvider) sign(path, ts string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(path + "\n" + ts))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *hmacAuthProvider) ApplyAuth(req *http.Request) error {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Timestamp", ts)
+	req.Header.Set("X-Signature", p.sign(req.URL.Path, ts))
+	return nil
+}
+
+func TestTargetAuthProvider(t *testing.T) {
+	provider := &hmacAuthProvider{key: []byte("secret")}
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				ts := r.Header.Get("X-Timestamp")
+				if ts == "" || r.Header.Get("X-Signature") != provider.sign(r.URL.Path, ts) {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				// The configured basic auth is still applied.
+				if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "password" {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeInterval: config.Duration(time.Second),
+		ScrapeTimeout:  config.Duration(time.Second),
+		MetricsPath:    "/metrics",
+		BasicAuth:      &config.BasicAuth{Username: "user", Password: "password"},
+	}
+	testTarget, err := NewTarget(cfg, clientmodel.LabelSet{
+		clientmodel.SchemeLabel:      "http",
+		clientmodel.AddressLabel:     clientmodel.LabelValue(strings.TrimPrefix(server.URL, "http://")),
+		clientmodel.MetricsPathLabel: "/metrics",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testTarget.scrape(nopAppender{}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("Expected unsigned scrape to be rejected, got %v", err)
+	}
+	testTarget.SetAuthProvider(provider)
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failing to authenticate fails the scrape before the request is sent.
+	testTarget.SetAuthProvider(failingAuthProvider{})
+	if err := testTarget.scrape(nopAppender{}); err == nil || !strings.Contains(err.Error(), "no credentials") {
+		t.Fatalf("Expected authentication error, got %v", err)
+	}
+}
+
+type failingAuthProvider struct{}
+
+func (failingAuthProvider) ApplyAuth(*http.Request) error {
+	return errors.New("no credentials")
+}
+
+// stubNegotiateTokenSource returns an initial token and answers challenges
+// by prefixing their token.
+type stubNegotiateTokenSource struct{}
+
+func (stubNegotiateTokenSource) NegotiateToken(spn string, input []byte) ([]byte, error) {
+	if input == nil {
+		return []byte("initial:" + spn), nil
+	}
+	return append([]byte("answer:"), input...), nil
+}
+
+func TestTargetScrapeNegotiateAuth(t *testing.T) {
+	encode := base64.StdEncoding.EncodeToString
+	var authorizations []string
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				auth := r.Header.Get("Authorization")
+				authorizations = append(authorizations, auth)
+				// The initial token is answered with a challenge and only
+				// the answer to it is accepted.
+				switch auth {
+				case "Negotiate " + encode([]byte("answer:challenge")):
+				case "Negotiate " + encode([]byte("initial:HTTP/exporter.example.org")):
+					w.Header().Set("WWW-Authenticate", "Negotiate "+encode([]byte("challenge")))
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				default:
+					w.Header().Set("WWW-Authenticate", "Negotiate")
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
+
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.SetAuthProvider(httputil.NewNegotiateAuthProvider(stubNegotiateTokenSource{}, "HTTP/exporter.example.org"))
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(authorizations) != 2 {
+		t.Fatalf("Expected the challenge to be answered in a second request, got authorizations %q", authorizations)
+	}
+
+	// A challenge without a token is not answered.
+	authorizations = nil
+	testTarget.SetAuthProvider(httputil.NewNegotiateAuthProvider(stubNegotiateTokenSource{}, "HTTP/other.example.org"))
+	if err := testTarget.scrape(nopAppender{}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("Expected scrape to be rejected, got %v", err)
+	}
+	if len(authorizations) != 1 {
+		t.Errorf("Expected a single request, got authorizations %q", authorizations)
+	}
+}
+
+func TestNewHTTPBearerToken(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				expected := "Bearer 1234"
+				received := r.Header.Get("Authorization")
+				if expected != received {
+					t.Fatalf("Authorization header was not set correctly: expected '%v', got '%v'", expected, received)
+				}
+			},
+		),
+	)
+	defer server.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeTimeout: config.Duration(1 * time.Second),
+		BearerToken:   "1234",
+	}
+	c, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewHTTPBearerTokenFile(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				expected := "Bearer 12345"
+				received := r.Header.Get("Authorization")
+				if expected != received {
+					t.Fatalf("Authorization header was not set correctly: expected '%v', got '%v'", expected, received)
+				}
+			},
+		),
+	)
+	defer server.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeTimeout:   config.Duration(1 * time.Second),
+		BearerTokenFile: "testdata/bearertoken.txt",
+	}
+	c, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewHTTPBearerTokenURL(t *testing.T) {
+	var tokenRequests int
+	tokenServer := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				tokenRequests++
+				w.Write([]byte(fmt.Sprintf("token%d\n", tokenRequests)))
+			},
+		),
+	)
+	defer tokenServer.Close()
+
+	var expected string
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				received := r.Header.Get("Authorization")
+				if expected != received {
+					t.Fatalf("Authorization header was not set correctly: expected '%v', got '%v'", expected, received)
+				}
+			},
+		),
+	)
+	defer server.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeTimeout:  config.Duration(1 * time.Second),
+		BearerTokenURL: tokenServer.URL,
+		BearerTokenTTL: config.Duration(1 * time.Hour),
+	}
+	c, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "Bearer token1"
+	for i := 0; i < 3; i++ {
+		if _, err = c.Get(server.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Fatalf("Expected token to be fetched once within TTL, got %d fetches", tokenRequests)
+	}
+
+	// An expired token must be fetched again.
+	cfg.BearerTokenTTL = config.Duration(1 * time.Millisecond)
+	c, err = newHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "Bearer token2"
+	if _, err = c.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	expected = "Bearer token3"
+	if _, err = c.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewHTTPBearerTokenURLShared(t *testing.T) {
+	var (
+		tokenRequests int32
+		// The delay of token requests in nanoseconds.
+		tokenDelay = int64(100 * time.Millisecond)
+	)
+	tokenServer := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(time.Duration(atomic.LoadInt64(&tokenDelay)))
+				fmt.Fprintf(w, "token%d\n", atomic.AddInt32(&tokenRequests, 1))
+			},
+		),
+	)
+	defer tokenServer.Close()
+
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") == "" {
+					t.Errorf("Missing Authorization header")
+				}
+			},
+		),
+	)
+	defer server.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeTimeout:  config.Duration(1 * time.Second),
+		BearerTokenURL: tokenServer.URL,
+		BearerTokenTTL: config.Duration(1 * time.Second),
+	}
+	// The clients of all targets of a scrape config share one token, which
+	// concurrent requests wait for only once.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		c, err := newHTTPClient(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get(server.URL); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&tokenRequests); n != 1 {
+		t.Fatalf("Expected the token to be fetched once, got %d fetches", n)
+	}
+
+	// A slow refresh shortly before the token expires does not block
+	// requests, which keep using the old token.
+	c, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(900 * time.Millisecond)
+	atomic.StoreInt64(&tokenDelay, int64(500*time.Millisecond))
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(server.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d > 300*time.Millisecond {
+		t.Errorf("Expected requests not to wait for the refresh, took %s", d)
+	}
+}
+
+func TestNewHTTPEnableHTTP2(t *testing.T) {
+	server := httptest.NewUnstartedServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				if r.ProtoMajor != 2 {
+					t.Errorf("Expected HTTP/2 request, got %s", r.Proto)
+				}
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte{})
+			},
+		),
+	)
+	server.Config.Protocols = &http.Protocols{}
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+
+	// The protocol follows the scheme of the target URL rather than the
+	// one of the config.
+	cfg := &config.ScrapeConfig{
+		ScrapeTimeout: config.Duration(1 * time.Second),
+		Scheme:        "http",
+		CACert:        "testdata/ca.cer",
+		EnableHTTP2:   true,
+	}
+	c, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Expected HTTP/2 response, got %s", resp.Proto)
+	}
+
+	tlsServer := httptest.NewUnstartedServer(server.Config.Handler)
+	tlsServer.TLS = newTLSConfig(t)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	resp, err = c.Get(tlsServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Expected HTTP/2 response, got %s", resp.Proto)
+	}
+}
+
+func TestNewHTTPCACert(t *testing.T) {
+	server := httptest.NewUnstartedServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte{})
+			},
+		),
+	)
+	server.TLS = newTLSConfig(t)
+	server.StartTLS()
+	defer server.Close()
+
+	cfg := &config.ScrapeConfig{
+		ScrapeTimeout: config.Duration(1 * time.Second),
+		CACert:        "testdata/ca.cer",
 	}
-
-	// now scrape again without timeout
-	signal <- true
-	if err := testTarget.scrape(appender); err != nil {
+	c, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Get(server.URL)
+	if err != nil {
 		t.Fatal(err)
 	}
 }
 
-func TestTargetScrape404(t *testing.T) {
-	server := httptest.NewServer(
+func TestTargetScrapePeerCertExpiry(t *testing.T) {
+	server := httptest.NewUnstartedServer(
 		http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(http.StatusNotFound)
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte{})
 			},
 		),
 	)
+	server.TLS = newTLSConfig(t)
+	server.StartTLS()
 	defer server.Close()
 
-	testTarget := newTestTarget(server.URL, 10*time.Millisecond, clientmodel.LabelSet{})
-	appender := nopAppender{}
+	cfg := &config.ScrapeConfig{
+		ScrapeTimeout: config.Duration(1 * time.Second),
+		CACert:        "testdata/ca.cer",
+	}
+	c, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.url.Scheme = "https"
+	testTarget.url.Host = strings.TrimPrefix(server.URL, "https://")
+	testTarget.httpClient = c
 
-	want := errors.New("server returned HTTP status 404 Not Found")
-	got := testTarget.scrape(appender)
-	if got == nil || want.Error() != got.Error() {
-		t.Fatalf("want err %q, got %q", want, got)
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
+		t.Fatal(err)
+	}
+	expiry := testTarget.status.PeerCertExpiry()
+	if expiry.IsZero() {
+		t.Fatal("Expected non-zero peer certificate expiry")
 	}
-}
 
-func TestTargetRunScraperScrapes(t *testing.T) {
-	testTarget := newTestTarget("bad schema", 0, nil)
+	var found bool
+	for _, s := range appender.result {
+		if s.Metric[clientmodel.MetricNameLabel] == scrapeTLSCertNotAfterMetricName {
+			found = true
+			if s.Value != clientmodel.SampleValue(expiry.Unix()) {
+				t.Errorf("Expected %s value %d, got %v", scrapeTLSCertNotAfterMetricName, expiry.Unix(), s.Value)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected %s sample", scrapeTLSCertNotAfterMetricName)
+	}
+}
 
-	go testTarget.RunScraper(nopAppender{})
+func TestTargetStatusLastResolvedAddr(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte("test_metric 1\n"))
+			},
+		),
+	)
+	defer server.Close()
 
-	// Enough time for a scrape to happen.
-	time.Sleep(10 * time.Millisecond)
-	if testTarget.status.LastScrape().IsZero() {
-		t.Errorf("Scrape hasn't occured.")
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	if addr := testTarget.status.LastResolvedAddr(); addr != "" {
+		t.Errorf("Expected no address before the first scrape, got %q", addr)
 	}
-
-	testTarget.StopScraper()
-	// Wait for it to take effect.
-	time.Sleep(5 * time.Millisecond)
-	last := testTarget.status.LastScrape()
-	// Enough time for a scrape to happen.
-	time.Sleep(10 * time.Millisecond)
-	if testTarget.status.LastScrape() != last {
-		t.Errorf("Scrape occured after it was stopped.")
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
+	}
+	if addr, expected := testTarget.status.LastResolvedAddr(), server.Listener.Addr().String(); addr != expected {
+		t.Errorf("Expected address %q, got %q", expected, addr)
+	}
+	if addr := testTarget.status.Snapshot().LastResolvedAddr; addr != server.Listener.Addr().String() {
+		t.Errorf("Expected address %q in snapshot, got %q", server.Listener.Addr(), addr)
 	}
 }
 
-func BenchmarkScrape(b *testing.B) {
-	server := httptest.NewServer(
+func TestTargetScrapeResumesTLSSessions(t *testing.T) {
+	resumed := make(chan bool, 1)
+	server := httptest.NewUnstartedServer(
 		http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
+				resumed <- r.TLS.DidResume
 				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
-				w.Write([]byte("test_metric{foo=\"bar\"} 123.456\n"))
+				w.Write([]byte("test_metric 1\n"))
 			},
 		),
 	)
+	server.TLS = newTLSConfig(t)
+	// Each scrape requires a new connection and thus a handshake.
+	server.Config.SetKeepAlivesEnabled(false)
+	server.StartTLS()
 	defer server.Close()
 
-	testTarget := newTestTarget(server.URL, 100*time.Millisecond, clientmodel.LabelSet{"dings": "bums"})
-	appender := nopAppender{}
+	scenarios := []struct {
+		cacheSize int
+		resumed   bool
+	}{
+		{cacheSize: 0, resumed: true},
+		{cacheSize: -1, resumed: false},
+	}
+	for i, s := range scenarios {
+		cfg := &config.ScrapeConfig{
+			JobName:             fmt.Sprintf("test_job_%d", i),
+			ScrapeTimeout:       config.Duration(1 * time.Second),
+			CACert:              "testdata/ca.cer",
+			TLSSessionCacheSize: s.cacheSize,
+		}
+		c, err := newHTTPClient(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+		testTarget.url.Scheme = "https"
+		testTarget.url.Host = strings.TrimPrefix(server.URL, "https://")
+		testTarget.httpClient = c
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		if err := testTarget.scrape(appender); err != nil {
-			b.Fatal(err)
+		if err := testTarget.scrape(nopAppender{}); err != nil {
+			t.Fatal(err)
+		}
+		if <-resumed {
+			t.Errorf("%d. Expected full handshake on first scrape", i)
+		}
+		if err := testTarget.scrape(nopAppender{}); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-resumed; got != s.resumed {
+			t.Errorf("%d. Expected session resumption %t on second scrape, got %t", i, s.resumed, got)
 		}
 	}
 }
 
-func TestURLParams(t *testing.T) {
-	server := httptest.NewServer(
+func TestTargetWarmup(t *testing.T) {
+	type request struct {
+		method  string
+		resumed bool
+	}
+	requests := make(chan request, 1)
+	server := httptest.NewUnstartedServer(
 		http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
+				requests <- request{method: r.Method, resumed: r.TLS.DidResume}
 				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
-				w.Write([]byte{})
-				r.ParseForm()
-				if r.Form["foo"][0] != "bar" {
-					t.Fatalf("URL parameter 'foo' had unexpected first value '%v'", r.Form["foo"][0])
-				}
-				if r.Form["foo"][1] != "baz" {
-					t.Fatalf("URL parameter 'foo' had unexpected second value '%v'", r.Form["foo"][1])
-				}
+				w.Write([]byte("test_metric 1\n"))
 			},
 		),
 	)
-	defer server.Close()
-	serverURL, err := url.Parse(server.URL)
+	server.TLS = newTLSConfig(t)
+	server.Config.SetKeepAlivesEnabled(false)
+	server.StartTLS()
+
+	c, err := newHTTPClient(&config.ScrapeConfig{
+		JobName:       "test_job",
+		ScrapeTimeout: config.Duration(1 * time.Second),
+		CACert:        "testdata/ca.cer",
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.url.Scheme = "https"
+	testTarget.url.Host = strings.TrimPrefix(server.URL, "https://")
+	testTarget.httpClient = c
 
-	target := NewTarget(
-		&config.ScrapeConfig{
-			JobName:        "test_job1",
-			ScrapeInterval: config.Duration(1 * time.Minute),
-			ScrapeTimeout:  config.Duration(1 * time.Second),
-			Scheme:         serverURL.Scheme,
-			Params: url.Values{
-				"foo": []string{"bar", "baz"},
-			},
-		},
-		clientmodel.LabelSet{
-			clientmodel.SchemeLabel:  clientmodel.LabelValue(serverURL.Scheme),
-			clientmodel.AddressLabel: clientmodel.LabelValue(serverURL.Host),
-			"__param_foo":            "bar",
-		},
-		nil)
-	app := &collectResultAppender{}
-	if err = target.scrape(app); err != nil {
+	// The warmup does the full handshake and the first scrape resumes the
+	// session.
+	if err := testTarget.Warmup(context.Background()); err != nil {
 		t.Fatal(err)
 	}
-}
-
-func newTestTarget(targetURL string, deadline time.Duration, baseLabels clientmodel.LabelSet) *Target {
-	t := &Target{
-		url: &url.URL{
-			Scheme: "http",
-			Host:   strings.TrimLeft(targetURL, "http://"),
-			Path:   "/metrics",
-		},
-		deadline:        deadline,
-		status:          &TargetStatus{},
-		scrapeInterval:  1 * time.Millisecond,
-		httpClient:      httputil.NewDeadlineClient(deadline, nil),
-		scraperStopping: make(chan struct{}),
-		scraperStopped:  make(chan struct{}),
+	if r := <-requests; r.method != "HEAD" || r.resumed {
+		t.Errorf("Expected HEAD request with full handshake, got %s request resuming the session %t", r.method, r.resumed)
 	}
-	t.baseLabels = clientmodel.LabelSet{
-		clientmodel.InstanceLabel: clientmodel.LabelValue(t.InstanceIdentifier()),
+	if err := testTarget.scrape(nopAppender{}); err != nil {
+		t.Fatal(err)
 	}
-	for baseLabel, baseValue := range baseLabels {
-		t.baseLabels[baseLabel] = baseValue
+	if r := <-requests; r.method != "GET" || !r.resumed {
+		t.Errorf("Expected scrape to resume the session, got %s request resuming the session %t", r.method, r.resumed)
+	}
+
+	// An unreachable target is noticed before the first scrape.
+	server.Close()
+	if err := testTarget.Warmup(context.Background()); err == nil {
+		t.Error("Expected warmup of unreachable target to fail")
 	}
-	return t
 }
 
-func TestNewHTTPBearerToken(t *testing.T) {
-	server := httptest.NewServer(
+func TestTargetScrapeTimings(t *testing.T) {
+	server := httptest.NewUnstartedServer(
 		http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
-				expected := "Bearer 1234"
-				received := r.Header.Get("Authorization")
-				if expected != received {
-					t.Fatalf("Authorization header was not set correctly: expected '%v', got '%v'", expected, received)
-				}
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte{})
 			},
 		),
 	)
+	server.TLS = newTLSConfig(t)
+	server.StartTLS()
 	defer server.Close()
 
 	cfg := &config.ScrapeConfig{
 		ScrapeTimeout: config.Duration(1 * time.Second),
-		BearerToken:   "1234",
+		CACert:        "testdata/ca.cer",
 	}
 	c, err := newHTTPClient(cfg)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = c.Get(server.URL)
-	if err != nil {
+	testTarget := newTestTarget(server.URL, time.Second, clientmodel.LabelSet{})
+	testTarget.url.Scheme = "https"
+	testTarget.url.Host = strings.TrimPrefix(server.URL, "https://")
+	testTarget.httpClient = c
+	testTarget.scrapeTimingMetrics = true
+
+	appender := &collectResultAppender{}
+	if err := testTarget.scrape(appender); err != nil {
 		t.Fatal(err)
 	}
+	timings := testTarget.status.ScrapeTimings()
+	if timings.TLSHandshake <= 0 {
+		t.Errorf("Expected non-zero TLS handshake duration, got %v", timings.TLSHandshake)
+	}
+	if timings.Connect <= 0 {
+		t.Errorf("Expected non-zero connect duration, got %v", timings.Connect)
+	}
+	if timings.FirstByte < timings.TLSHandshake {
+		t.Errorf("Expected time to first byte %v to include the TLS handshake %v", timings.FirstByte, timings.TLSHandshake)
+	}
+
+	found := map[clientmodel.LabelValue]clientmodel.SampleValue{}
+	for _, s := range appender.result {
+		found[s.Metric[clientmodel.MetricNameLabel]] = s.Value
+	}
+	for _, name := range []clientmodel.LabelValue{
+		scrapeDNSLookupMetricName,
+		scrapeConnectMetricName,
+		scrapeTLSHandshakeMetricName,
+		scrapeFirstByteMetricName,
+	} {
+		if _, ok := found[name]; !ok {
+			t.Errorf("Expected %s sample", name)
+		}
+	}
+	if want := clientmodel.SampleValue(timings.TLSHandshake.Seconds()); found[scrapeTLSHandshakeMetricName] != want {
+		t.Errorf("Expected %s value %v, got %v", scrapeTLSHandshakeMetricName, want, found[scrapeTLSHandshakeMetricName])
+	}
 }
 
-func TestNewHTTPBearerTokenFile(t *testing.T) {
-	server := httptest.NewServer(
-		http.HandlerFunc(
-			func(w http.ResponseWriter, r *http.Request) {
-				expected := "Bearer 12345"
-				received := r.Header.Get("Authorization")
-				if expected != received {
-					t.Fatalf("Authorization header was not set correctly: expected '%v', got '%v'", expected, received)
-				}
-			},
-		),
+func TestNewHTTPMultipleCACerts(t *testing.T) {
+	handler := http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+			w.Write([]byte{})
+		},
 	)
-	defer server.Close()
+	server1 := httptest.NewUnstartedServer(handler)
+	server1.TLS = newTLSConfig(t)
+	server1.StartTLS()
+	defer server1.Close()
+
+	cert, err := tls.LoadX509KeyPair("testdata/server2.cer", "testdata/server2.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server2 := httptest.NewUnstartedServer(handler)
+	server2.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server2.StartTLS()
+	defer server2.Close()
 
 	cfg := &config.ScrapeConfig{
-		ScrapeTimeout:   config.Duration(1 * time.Second),
-		BearerTokenFile: "testdata/bearertoken.txt",
+		ScrapeTimeout: config.Duration(1 * time.Second),
+		CACerts:       []string{"testdata/ca.cer", "testdata/ca2.cer"},
 	}
 	c, err := newHTTPClient(cfg)
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = c.Get(server.URL)
+	for _, u := range []string{server1.URL, server2.URL} {
+		if _, err = c.Get(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg.CACerts = []string{"testdata/ca.cer"}
+	c, err = newHTTPClient(cfg)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if _, err = c.Get(server2.URL); err == nil {
+		t.Fatal("Expected certificate verification to fail without second CA cert")
+	}
 }
 
-func TestNewHTTPCACert(t *testing.T) {
+func TestNewHTTPSystemCertPool(t *testing.T) {
+	cfg := &config.ScrapeConfig{
+		ScrapeTimeout:     config.Duration(1 * time.Second),
+		CACerts:           []string{"testdata/ca.cer"},
+		UseSystemCertPool: true,
+	}
+	c, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := c.Transport.(*http.Transport).TLSClientConfig
+	if tlsConfig.RootCAs == nil {
+		t.Fatal("Expected root CAs to be set")
+	}
+}
+
+func TestNewHTTPClientCert(t *testing.T) {
 	server := httptest.NewUnstartedServer(
 		http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
@@ -525,13 +5063,21 @@ func TestNewHTTPCACert(t *testing.T) {
 			},
 		),
 	)
-	server.TLS = newTLSConfig(t)
+	tlsConfig := newTLSConfig(t)
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	tlsConfig.ClientCAs = tlsConfig.RootCAs
+	tlsConfig.BuildNameToCertificate()
+	server.TLS = tlsConfig
 	server.StartTLS()
 	defer server.Close()
 
 	cfg := &config.ScrapeConfig{
 		ScrapeTimeout: config.Duration(1 * time.Second),
 		CACert:        "testdata/ca.cer",
+		ClientCert: &config.ClientCert{
+			Cert: "testdata/client.cer",
+			Key:  "testdata/client.key",
+		},
 	}
 	c, err := newHTTPClient(cfg)
 	if err != nil {
@@ -543,7 +5089,7 @@ func TestNewHTTPCACert(t *testing.T) {
 	}
 }
 
-func TestNewHTTPClientCert(t *testing.T) {
+func TestNewHTTPClientCertPEM(t *testing.T) {
 	server := httptest.NewUnstartedServer(
 		http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
@@ -560,12 +5106,21 @@ func TestNewHTTPClientCert(t *testing.T) {
 	server.StartTLS()
 	defer server.Close()
 
+	certPEM, err := ioutil.ReadFile("testdata/client.cer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := ioutil.ReadFile("testdata/client.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	cfg := &config.ScrapeConfig{
 		ScrapeTimeout: config.Duration(1 * time.Second),
 		CACert:        "testdata/ca.cer",
 		ClientCert: &config.ClientCert{
-			Cert: "testdata/client.cer",
-			Key:  "testdata/client.key",
+			CertPEM: string(certPEM),
+			KeyPEM:  string(keyPEM),
 		},
 	}
 	c, err := newHTTPClient(cfg)
@@ -578,6 +5133,50 @@ func TestNewHTTPClientCert(t *testing.T) {
 	}
 }
 
+func TestNewHTTPClientCertP12(t *testing.T) {
+	server := httptest.NewUnstartedServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", `text/plain; version=0.0.4`)
+				w.Write([]byte{})
+			},
+		),
+	)
+	tlsConfig := newTLSConfig(t)
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	tlsConfig.ClientCAs = tlsConfig.RootCAs
+	tlsConfig.BuildNameToCertificate()
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	for _, p12 := range []*config.ClientCertP12{
+		{File: "testdata/client.p12", Password: "secret"},
+		{File: "testdata/client.p12", PasswordFile: "testdata/client.p12.password"},
+	} {
+		cfg := &config.ScrapeConfig{
+			ScrapeTimeout: config.Duration(1 * time.Second),
+			CACert:        "testdata/ca.cer",
+			ClientCertP12: p12,
+		}
+		c, err := newHTTPClient(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.Get(server.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.ScrapeConfig{
+		ScrapeTimeout: config.Duration(1 * time.Second),
+		ClientCertP12: &config.ClientCertP12{File: "testdata/client.p12", Password: "wrong"},
+	}
+	if _, err := newHTTPClient(cfg); err == nil {
+		t.Error("Expected error for wrong bundle password")
+	}
+}
+
 func newTLSConfig(t *testing.T) *tls.Config {
 	tlsConfig := &tls.Config{}
 	caCertPool := x509.NewCertPool()
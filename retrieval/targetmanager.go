@@ -15,6 +15,7 @@ package retrieval
 
 import (
 	"fmt"
+	"net"
 	"strings"
 	"sync"
 
@@ -57,8 +58,13 @@ type TargetManager struct {
 
 	// Targets by their source ID.
 	targets map[string][]*Target
+	// Scrape pools running the scrapers of the targets by their source ID.
+	scrapePools map[string]*scrapePool
 	// Providers by the scrape configs they are derived from.
 	providers map[*config.ScrapeConfig][]TargetProvider
+	// Semaphores limiting concurrent scrapes by the scrape configs they are
+	// derived from. Scrape configs without a limit have no semaphore.
+	scrapeSemaphores map[*config.ScrapeConfig]chan struct{}
 }
 
 // NewTargetManager creates a new TargetManager.
@@ -66,6 +72,7 @@ func NewTargetManager(sampleAppender storage.SampleAppender) *TargetManager {
 	tm := &TargetManager{
 		sampleAppender: sampleAppender,
 		targets:        make(map[string][]*Target),
+		scrapePools:    make(map[string]*scrapePool),
 	}
 	return tm
 }
@@ -167,18 +174,19 @@ func (tm *TargetManager) removeTargets(f func(string) bool) {
 		f = func(string) bool { return true }
 	}
 	var wg sync.WaitGroup
-	for src, targets := range tm.targets {
+	for src := range tm.targets {
 		if !f(src) {
 			continue
 		}
-		wg.Add(len(targets))
-		for _, target := range targets {
-			go func(t *Target) {
-				t.StopScraper()
+		if sp, ok := tm.scrapePools[src]; ok {
+			wg.Add(1)
+			go func() {
+				sp.Stop()
 				wg.Done()
-			}(target)
+			}()
 		}
 		delete(tm.targets, src)
+		delete(tm.scrapePools, src)
 	}
 	wg.Wait()
 }
@@ -198,60 +206,59 @@ func (tm *TargetManager) updateTargetGroup(tgroup *config.TargetGroup, cfg *conf
 		return nil
 	}
 
-	oldTargets, ok := tm.targets[tgroup.Source]
-	if ok {
-		var wg sync.WaitGroup
-		// Replace the old targets with the new ones while keeping the state
-		// of intersecting targets.
-		for i, tnew := range newTargets {
-			var match *Target
-			for j, told := range oldTargets {
-				if told == nil {
-					continue
-				}
-				if tnew.InstanceIdentifier() == told.InstanceIdentifier() {
-					match = told
-					oldTargets[j] = nil
-					break
-				}
-			}
-			// Update the existing target and discard the new equivalent.
-			// Otherwise start scraping the new target.
-			if match != nil {
-				// Updating is blocked during a scrape. We don't want those wait times
-				// to build up.
-				wg.Add(1)
-				go func(t *Target) {
-					match.Update(cfg, t.fullLabels(), t.metaLabels)
-					wg.Done()
-				}(tnew)
-				newTargets[i] = match
-			} else {
-				go tnew.RunScraper(tm.sampleAppender)
+	sem := tm.scrapeSemaphores[cfg]
+	for _, tnew := range newTargets {
+		tnew.scrapeSemaphore = sem
+	}
+
+	// Update the existing targets matching new ones and discard the new
+	// equivalents to keep the state of intersecting targets. The scrape pool
+	// of the source starts and stops the scrapers of the others.
+	oldTargets := tm.targets[tgroup.Source]
+	var wg sync.WaitGroup
+	for i, tnew := range newTargets {
+		var (
+			match *Target
+			key   = targetKey(tnew)
+		)
+		for j, told := range oldTargets {
+			if told == nil {
+				continue
 			}
-		}
-		// Remove all old targets that disappeared.
-		for _, told := range oldTargets {
-			if told != nil {
-				wg.Add(1)
-				go func(t *Target) {
-					t.StopScraper()
-					wg.Done()
-				}(told)
+			if targetKey(told) == key {
+				match = told
+				oldTargets[j] = nil
+				break
 			}
 		}
-		wg.Wait()
-	} else {
-		// The source ID is new, start all target scrapers.
-		for _, tnew := range newTargets {
-			go tnew.RunScraper(tm.sampleAppender)
+		if match != nil {
+			// Updating is blocked during a scrape. We don't want those wait times
+			// to build up.
+			wg.Add(1)
+			go func(t *Target) {
+				if err := match.Update(cfg, t.fullLabels(), t.metaLabels); err != nil {
+					log.Errorf("Error updating target %s: %s", match, err)
+				}
+				match.setScrapeSemaphore(sem)
+				wg.Done()
+			}(tnew)
+			newTargets[i] = match
 		}
 	}
+	wg.Wait()
+
+	sp, ok := tm.scrapePools[tgroup.Source]
+	if !ok {
+		sp = newScrapePool(tm.sampleAppender)
+		tm.scrapePools[tgroup.Source] = sp
+	}
+	newTargets = sp.Sync(newTargets)
 
 	if len(newTargets) > 0 {
 		tm.targets[tgroup.Source] = newTargets
 	} else {
 		delete(tm.targets, tgroup.Source)
+		delete(tm.scrapePools, tgroup.Source)
 	}
 	return nil
 }
@@ -286,9 +293,13 @@ func (tm *TargetManager) ApplyConfig(cfg *config.Config) bool {
 		defer tm.Run()
 	}
 	providers := map[*config.ScrapeConfig][]TargetProvider{}
+	scrapeSemaphores := map[*config.ScrapeConfig]chan struct{}{}
 
 	for _, scfg := range cfg.ScrapeConfigs {
 		providers[scfg] = providersFromConfig(scfg)
+		if scfg.MaxConcurrentScrapes > 0 {
+			scrapeSemaphores[scfg] = make(chan struct{}, scfg.MaxConcurrentScrapes)
+		}
 	}
 
 	tm.m.Lock()
@@ -296,6 +307,10 @@ func (tm *TargetManager) ApplyConfig(cfg *config.Config) bool {
 
 	tm.globalLabels = cfg.GlobalConfig.Labels
 	tm.providers = providers
+	tm.scrapeSemaphores = scrapeSemaphores
+	jobTokenSources.retain(cfg.ScrapeConfigs)
+	jobNegotiateSources.retain(cfg.ScrapeConfigs)
+	retainTLSSessionCaches(cfg.ScrapeConfigs)
 	return true
 }
 
@@ -369,24 +384,60 @@ func providersFromConfig(cfg *config.ScrapeConfig) []TargetProvider {
 	return providers
 }
 
+// hasPort returns whether the address has a port. The colons of IPv6
+// literals, bracketed or not, are not taken for a port separator.
+func hasPort(addr string) bool {
+	if strings.HasPrefix(addr, "[") {
+		return strings.Contains(addr[strings.LastIndex(addr, "]")+1:], ":")
+	}
+	return strings.Count(addr, ":") == 1
+}
+
 // targetsFromGroup builds targets based on the given TargetGroup and config.
 func (tm *TargetManager) targetsFromGroup(tg *config.TargetGroup, cfg *config.ScrapeConfig) ([]*Target, error) {
 	tm.m.RLock()
 	defer tm.m.RUnlock()
 
+	return targetsFromGroup(tg, cfg, tm.globalLabels)
+}
+
+// TargetsFromConfig builds the targets for the given discovered label sets in
+// the same way as they are built for scraping, including relabeling. The
+// scrapers of the returned targets are not started.
+func TargetsFromConfig(cfg *config.ScrapeConfig, discovered []clientmodel.LabelSet) ([]*Target, error) {
+	tg := &config.TargetGroup{
+		Targets: make([]clientmodel.LabelSet, 0, len(discovered)),
+		Source:  cfg.JobName,
+	}
+	for _, labels := range discovered {
+		lset := make(clientmodel.LabelSet, len(labels))
+		for ln, lv := range labels {
+			lset[ln] = lv
+		}
+		tg.Targets = append(tg.Targets, lset)
+	}
+	return targetsFromGroup(tg, cfg, nil)
+}
+
+// targetsFromGroup builds targets based on the given TargetGroup, config, and
+// global labels.
+func targetsFromGroup(tg *config.TargetGroup, cfg *config.ScrapeConfig, globalLabels clientmodel.LabelSet) ([]*Target, error) {
 	targets := make([]*Target, 0, len(tg.Targets))
 	for i, labels := range tg.Targets {
 		addr := string(labels[clientmodel.AddressLabel])
 		// If no port was provided, infer it based on the used scheme.
-		if !strings.Contains(addr, ":") {
+		if !hasPort(addr) {
+			var port string
 			switch cfg.Scheme {
 			case "http":
-				addr = fmt.Sprintf("%s:80", addr)
+				port = "80"
 			case "https":
-				addr = fmt.Sprintf("%s:443", addr)
+				port = "443"
 			default:
-				panic(fmt.Errorf("targetsFromGroup: invalid scheme %q", cfg.Scheme))
+				return nil, fmt.Errorf("instance %d in target group %s has no port and the scheme %q implies none", i, tg, cfg.Scheme)
 			}
+			// IPv6 literals are bracketed with or without a port.
+			addr = net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"), port)
 			labels[clientmodel.AddressLabel] = clientmodel.LabelValue(addr)
 		}
 		for k, v := range cfg.Params {
@@ -403,7 +454,7 @@ func (tm *TargetManager) targetsFromGroup(tg *config.TargetGroup, cfg *config.Sc
 				clientmodel.MetricsPathLabel: clientmodel.LabelValue(cfg.MetricsPath),
 				clientmodel.JobLabel:         clientmodel.LabelValue(cfg.JobName),
 			},
-			tm.globalLabels,
+			globalLabels,
 		}
 		for _, lset := range labelsets {
 			for ln, lv := range lset {
@@ -428,6 +479,15 @@ func (tm *TargetManager) targetsFromGroup(tg *config.TargetGroup, cfg *config.Sc
 			continue
 		}
 
+		// Relabeling may remove the scheme. Targets without one fall back to
+		// the scheme of the config rather than getting a schemeless URL.
+		if labels[clientmodel.SchemeLabel] == "" {
+			if cfg.Scheme == "" {
+				return nil, fmt.Errorf("instance %d in target group %s has no scheme", i, tg)
+			}
+			labels[clientmodel.SchemeLabel] = clientmodel.LabelValue(cfg.Scheme)
+		}
+
 		for ln := range labels {
 			// Meta labels are deleted after relabelling. Other internal labels propagate to
 			// the target which decides whether they will be part of their label set.
@@ -435,7 +495,10 @@ func (tm *TargetManager) targetsFromGroup(tg *config.TargetGroup, cfg *config.Sc
 				delete(labels, ln)
 			}
 		}
-		tr := NewTarget(cfg, labels, preRelabelLabels)
+		tr, err := NewTarget(cfg, labels, preRelabelLabels)
+		if err != nil {
+			return nil, fmt.Errorf("instance %d in target group %s: %s", i, tg, err)
+		}
 		targets = append(targets, tr)
 	}
 
@@ -74,6 +74,7 @@ func TestTargetManagerChan(t *testing.T) {
 	testJob1 := &config.ScrapeConfig{
 		JobName:        "test_job1",
 		ScrapeInterval: config.Duration(1 * time.Minute),
+		Scheme:         "http",
 		TargetGroups: []*config.TargetGroup{{
 			Targets: []clientmodel.LabelSet{
 				{clientmodel.AddressLabel: "example.org:80"},
@@ -91,7 +92,8 @@ func TestTargetManagerChan(t *testing.T) {
 		providers: map[*config.ScrapeConfig][]TargetProvider{
 			testJob1: {prov1},
 		},
-		targets: make(map[string][]*Target),
+		targets:     make(map[string][]*Target),
+		scrapePools: make(map[string]*scrapePool),
 	}
 	go targetManager.Run()
 	defer targetManager.Stop()
@@ -203,6 +205,7 @@ func TestTargetManagerConfigUpdate(t *testing.T) {
 	testJob1 := &config.ScrapeConfig{
 		JobName:        "test_job1",
 		ScrapeInterval: config.Duration(1 * time.Minute),
+		Scheme:         "http",
 		Params: url.Values{
 			"testParam": []string{"paramValue", "secondValue"},
 		},
@@ -226,6 +229,7 @@ func TestTargetManagerConfigUpdate(t *testing.T) {
 	testJob2 := &config.ScrapeConfig{
 		JobName:        "test_job2",
 		ScrapeInterval: config.Duration(1 * time.Minute),
+		Scheme:         "http",
 		TargetGroups: []*config.TargetGroup{
 			{
 				Targets: []clientmodel.LabelSet{
@@ -373,3 +377,355 @@ func TestTargetManagerConfigUpdate(t *testing.T) {
 		}
 	}
 }
+
+func TestTargetManagerSameHostDifferentParams(t *testing.T) {
+	scrapeConfig := &config.ScrapeConfig{
+		JobName:        "blackbox",
+		ScrapeInterval: config.Duration(1 * time.Minute),
+		ScrapeTimeout:  config.Duration(10 * time.Second),
+		Scheme:         "http",
+		MetricsPath:    "/probe",
+	}
+	// Both targets probe through the same exporter.
+	tgroup := &config.TargetGroup{
+		Source: "src",
+		Targets: []clientmodel.LabelSet{
+			{clientmodel.AddressLabel: "exporter:9115", clientmodel.ParamLabelPrefix + "target": "example.org"},
+			{clientmodel.AddressLabel: "exporter:9115", clientmodel.ParamLabelPrefix + "target": "example.com"},
+		},
+	}
+	targetManager := &TargetManager{
+		sampleAppender: nopAppender{},
+		targets:        make(map[string][]*Target),
+		scrapePools:    make(map[string]*scrapePool),
+		running:        true,
+	}
+	defer targetManager.removeTargets(nil)
+
+	if err := targetManager.updateTargetGroup(tgroup, scrapeConfig); err != nil {
+		t.Fatal(err)
+	}
+	// Updating the group clears the matched old targets in place.
+	targets := append([]*Target(nil), targetManager.targets["src"]...)
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(targets))
+	}
+
+	// Updating the group keeps both targets.
+	if err := targetManager.updateTargetGroup(tgroup, scrapeConfig); err != nil {
+		t.Fatal(err)
+	}
+	updated := targetManager.targets["src"]
+	if len(updated) != 2 || updated[0] != targets[0] || updated[1] != targets[1] {
+		t.Errorf("Expected the targets to be kept, got %v", updated)
+	}
+	if n := len(targetManager.scrapePools["src"].Targets()); n != 2 {
+		t.Errorf("Expected 2 targets in the scrape pool, got %d", n)
+	}
+}
+
+func TestTargetsFromConfig(t *testing.T) {
+	cfg := &config.ScrapeConfig{
+		JobName:        "test_job",
+		ScrapeInterval: config.Duration(1 * time.Minute),
+		ScrapeTimeout:  config.Duration(10 * time.Second),
+		MetricsPath:    "/metrics",
+		Scheme:         "http",
+		RelabelConfigs: []*config.RelabelConfig{
+			{
+				SourceLabels: clientmodel.LabelNames{clientmodel.AddressLabel},
+				Regex:        &config.Regexp{*regexp.MustCompile(`^drop\..*`)},
+				Separator:    ";",
+				Action:       config.RelabelDrop,
+			},
+			{
+				SourceLabels: clientmodel.LabelNames{"__meta_path"},
+				Regex:        &config.Regexp{*regexp.MustCompile(`^(.+)$`)},
+				TargetLabel:  clientmodel.MetricsPathLabel,
+				Separator:    ";",
+				Replacement:  "$1",
+				Action:       config.RelabelReplace,
+			},
+		},
+	}
+	discovered := []clientmodel.LabelSet{
+		{clientmodel.AddressLabel: "example.org", "__meta_path": "/probe"},
+		{clientmodel.AddressLabel: "drop.example.org:8080"},
+		{clientmodel.AddressLabel: "example.com:8080", "foo": "bar"},
+	}
+
+	targets, err := TargetsFromConfig(cfg, discovered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(targets))
+	}
+
+	expected := []struct {
+		url        string
+		baseLabels clientmodel.LabelSet
+	}{
+		{
+			url:        "http://example.org:80/probe",
+			baseLabels: clientmodel.LabelSet{clientmodel.JobLabel: "test_job", clientmodel.InstanceLabel: "example.org:80"},
+		}, {
+			url:        "http://example.com:8080/metrics",
+			baseLabels: clientmodel.LabelSet{clientmodel.JobLabel: "test_job", clientmodel.InstanceLabel: "example.com:8080", "foo": "bar"},
+		},
+	}
+	for i, exp := range expected {
+		if got := targets[i].URL().String(); got != exp.url {
+			t.Errorf("%d: Expected URL %q, got %q", i, exp.url, got)
+		}
+		if got := targets[i].BaseLabels(); !reflect.DeepEqual(got, exp.baseLabels) {
+			t.Errorf("%d: Expected base labels %v, got %v", i, exp.baseLabels, got)
+		}
+	}
+
+	// The discovered label sets must not be modified.
+	if _, ok := discovered[0][clientmodel.JobLabel]; ok {
+		t.Errorf("Discovered label set was modified: %v", discovered[0])
+	}
+}
+
+func TestTargetsFromConfigScheme(t *testing.T) {
+	cfg := &config.ScrapeConfig{
+		JobName:        "test_job",
+		ScrapeInterval: config.Duration(1 * time.Minute),
+		ScrapeTimeout:  config.Duration(10 * time.Second),
+		MetricsPath:    "/metrics",
+		Scheme:         "https",
+	}
+	discovered := []clientmodel.LabelSet{
+		{clientmodel.AddressLabel: "example.org:8443", clientmodel.SchemeLabel: ""},
+		{clientmodel.AddressLabel: "example.com:8080", clientmodel.SchemeLabel: "http"},
+	}
+	targets, err := TargetsFromConfig(cfg, discovered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"https://example.org:8443/metrics", "http://example.com:8080/metrics"}
+	for i, exp := range expected {
+		if got := targets[i].URL().String(); got != exp {
+			t.Errorf("%d: Expected URL %q, got %q", i, exp, got)
+		}
+	}
+
+	// Without any scheme the target cannot be built.
+	cfg.Scheme = ""
+	if _, err := TargetsFromConfig(cfg, discovered[:1]); err == nil {
+		t.Errorf("Expected error for target without scheme")
+	}
+	if _, err := TargetsFromConfig(cfg, []clientmodel.LabelSet{{clientmodel.AddressLabel: "example.org"}}); err == nil {
+		t.Errorf("Expected error for target without scheme and port")
+	}
+}
+
+func TestTargetsFromConfigRelabeledInstance(t *testing.T) {
+	cfg := &config.ScrapeConfig{
+		JobName:        "test_job",
+		ScrapeInterval: config.Duration(1 * time.Minute),
+		ScrapeTimeout:  config.Duration(10 * time.Second),
+		MetricsPath:    "/metrics",
+		Scheme:         "http",
+		RelabelConfigs: []*config.RelabelConfig{
+			{
+				SourceLabels: clientmodel.LabelNames{"__meta_namespace", "__meta_pod"},
+				Regex:        &config.Regexp{*regexp.MustCompile(`^(.+)$`)},
+				TargetLabel:  clientmodel.InstanceLabel,
+				Separator:    "/",
+				Replacement:  "$1",
+				Action:       config.RelabelReplace,
+			},
+		},
+	}
+	discovered := []clientmodel.LabelSet{
+		{clientmodel.AddressLabel: "10.0.0.1:8080", "__meta_namespace": "default", "__meta_pod": "pod-1"},
+	}
+
+	targets, err := TargetsFromConfig(cfg, discovered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 {
+		t.Fatalf("Expected 1 target, got %d", len(targets))
+	}
+
+	expected := clientmodel.LabelSet{clientmodel.JobLabel: "test_job", clientmodel.InstanceLabel: "default/pod-1"}
+	if got := targets[0].BaseLabels(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected base labels %v, got %v", expected, got)
+	}
+	if got := targets[0].URL().String(); got != "http://10.0.0.1:8080/metrics" {
+		t.Errorf("Expected URL %q, got %q", "http://10.0.0.1:8080/metrics", got)
+	}
+
+	// Updating the target keeps the relabeled instance label.
+	if err := targets[0].Update(cfg, targets[0].fullLabels(), targets[0].MetaLabels()); err != nil {
+		t.Fatal(err)
+	}
+	if got := targets[0].BaseLabels(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected base labels %v after update, got %v", expected, got)
+	}
+}
+
+func TestTargetsFromConfigDropMetaLabel(t *testing.T) {
+	cfg := &config.ScrapeConfig{
+		JobName:        "test_job",
+		ScrapeInterval: config.Duration(1 * time.Minute),
+		ScrapeTimeout:  config.Duration(10 * time.Second),
+		MetricsPath:    "/metrics",
+		Scheme:         "http",
+		RelabelConfigs: []*config.RelabelConfig{
+			{
+				SourceLabels: clientmodel.LabelNames{"__meta_maintenance"},
+				Regex:        &config.Regexp{*regexp.MustCompile(`^true$`)},
+				Separator:    ";",
+				Action:       config.RelabelDrop,
+			},
+		},
+	}
+	targets, err := TargetsFromConfig(cfg, []clientmodel.LabelSet{
+		{clientmodel.AddressLabel: "example.org:80", "__meta_maintenance": "true"},
+		{clientmodel.AddressLabel: "example.com:80", "__meta_maintenance": "false"},
+		{clientmodel.AddressLabel: "example.net:80"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, tr := range targets {
+		got = append(got, tr.InstanceIdentifier())
+	}
+	expected := []string{"example.com:80", "example.net:80"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected targets %v, got %v", expected, got)
+	}
+}
+
+func TestTargetsFromConfigKeepMetaLabel(t *testing.T) {
+	cfg := &config.ScrapeConfig{
+		JobName:        "test_job",
+		ScrapeInterval: config.Duration(1 * time.Minute),
+		ScrapeTimeout:  config.Duration(10 * time.Second),
+		MetricsPath:    "/metrics",
+		Scheme:         "http",
+		RelabelConfigs: []*config.RelabelConfig{
+			{
+				SourceLabels: clientmodel.LabelNames{"__meta_role"},
+				Regex:        &config.Regexp{*regexp.MustCompile(`^node$`)},
+				Separator:    ";",
+				Action:       config.RelabelKeep,
+			},
+			{
+				SourceLabels: clientmodel.LabelNames{"__meta_zone"},
+				Regex:        &config.Regexp{*regexp.MustCompile(`^(.+)$`)},
+				TargetLabel:  "zone",
+				Separator:    ";",
+				Replacement:  "$1",
+				Action:       config.RelabelReplace,
+			},
+		},
+	}
+	targets, err := TargetsFromConfig(cfg, []clientmodel.LabelSet{
+		{clientmodel.AddressLabel: "example.org:80", "__meta_role": "node", "__meta_zone": "eu"},
+		{clientmodel.AddressLabel: "example.com:80", "__meta_role": "service", "__meta_zone": "us"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 {
+		t.Fatalf("Expected 1 target, got %d", len(targets))
+	}
+
+	// The meta labels are removed from the kept target.
+	expected := clientmodel.LabelSet{
+		clientmodel.JobLabel:      "test_job",
+		clientmodel.InstanceLabel: "example.org:80",
+		"zone":                    "eu",
+	}
+	if got := targets[0].BaseLabels(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected base labels %v, got %v", expected, got)
+	}
+}
+
+func TestTargetsFromConfigIPv6(t *testing.T) {
+	cfg := &config.ScrapeConfig{
+		JobName:        "test_job",
+		ScrapeInterval: config.Duration(1 * time.Minute),
+		ScrapeTimeout:  config.Duration(10 * time.Second),
+		MetricsPath:    "/metrics",
+		Scheme:         "http",
+	}
+	addrs := map[clientmodel.LabelValue]string{
+		"[::1]:9090":   "[::1]:9090",
+		"[::1]":        "[::1]:80",
+		"::1":          "[::1]:80",
+		"[fe80::1]:80": "[fe80::1]:80",
+		"example.org":  "example.org:80",
+	}
+	for addr, expected := range addrs {
+		targets, err := TargetsFromConfig(cfg, []clientmodel.LabelSet{{clientmodel.AddressLabel: addr}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(targets) != 1 {
+			t.Fatalf("%s: Expected 1 target, got %d", addr, len(targets))
+		}
+		if got := targets[0].InstanceIdentifier(); got != expected {
+			t.Errorf("%s: Expected instance %s, got %s", addr, expected, got)
+		}
+		if got := targets[0].URL().String(); got != "http://"+expected+"/metrics" {
+			t.Errorf("%s: Expected URL http://%s/metrics, got %s", addr, expected, got)
+		}
+	}
+}
+
+func TestTargetDiscoveredLabels(t *testing.T) {
+	cfg := &config.ScrapeConfig{
+		JobName:        "test_job",
+		ScrapeInterval: config.Duration(1 * time.Minute),
+		ScrapeTimeout:  config.Duration(10 * time.Second),
+		MetricsPath:    "/metrics",
+		Scheme:         "http",
+		RelabelConfigs: []*config.RelabelConfig{
+			{
+				SourceLabels: clientmodel.LabelNames{"__meta_zone"},
+				Regex:        &config.Regexp{*regexp.MustCompile(`^(.+)$`)},
+				TargetLabel:  "zone",
+				Separator:    ";",
+				Replacement:  "$1",
+				Action:       config.RelabelReplace,
+			},
+		},
+	}
+	targets, err := TargetsFromConfig(cfg, []clientmodel.LabelSet{
+		{clientmodel.AddressLabel: "example.org:80", "__meta_zone": "eu"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expDiscovered := clientmodel.LabelSet{
+		clientmodel.AddressLabel:     "example.org:80",
+		clientmodel.SchemeLabel:      "http",
+		clientmodel.MetricsPathLabel: "/metrics",
+		clientmodel.JobLabel:         "test_job",
+		"__meta_zone":                "eu",
+	}
+	if got := targets[0].DiscoveredLabels(); !reflect.DeepEqual(got, expDiscovered) {
+		t.Errorf("Expected discovered labels %v, got %v", expDiscovered, got)
+	}
+
+	expLabels := clientmodel.LabelSet{
+		clientmodel.AddressLabel:     "example.org:80",
+		clientmodel.SchemeLabel:      "http",
+		clientmodel.MetricsPathLabel: "/metrics",
+		clientmodel.JobLabel:         "test_job",
+		"zone":                       "eu",
+	}
+	if got := targets[0].Labels(); !reflect.DeepEqual(got, expLabels) {
+		t.Errorf("Expected labels %v, got %v", expLabels, got)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"crypto/tls"
+	"sync"
+
+	"github.com/prometheus/prometheus/config"
+)
+
+// tlsSessionCacheKey identifies the targets that may resume each other's TLS
+// sessions. Resumed sessions skip client authentication, so targets with
+// different client certificates must not share sessions.
+type tlsSessionCacheKey struct {
+	jobName         string
+	cert, key       string
+	certPEM, keyPEM string
+	// The PKCS#12 bundle and the file of its password.
+	p12File, p12PasswordFile string
+	size                     int
+}
+
+var (
+	tlsSessionCachesMtx sync.Mutex
+	// The session caches shared by the HTTP clients of all targets of a scrape
+	// config. They outlive configuration reloads not changing the config, so
+	// that sessions are still resumed after a reload.
+	tlsSessionCaches = map[tlsSessionCacheKey]tls.ClientSessionCache{}
+)
+
+// newTLSSessionCacheKey returns the key of the TLS session cache of the
+// scrape config. It returns false if sessions are not to be resumed.
+func newTLSSessionCacheKey(cfg *config.ScrapeConfig) (tlsSessionCacheKey, bool) {
+	size := cfg.TLSSessionCacheSize
+	if size < 0 {
+		return tlsSessionCacheKey{}, false
+	}
+	if size == 0 {
+		size = defaultTLSSessionCacheSize
+	}
+	key := tlsSessionCacheKey{jobName: cfg.JobName, size: size}
+	if cc := cfg.ClientCert; cc != nil {
+		key.cert, key.key = cc.Cert, cc.Key
+		key.certPEM, key.keyPEM = cc.CertPEM, cc.KeyPEM
+	}
+	if p12 := cfg.ClientCertP12; p12 != nil {
+		key.p12File, key.p12PasswordFile = p12.File, p12.PasswordFile
+	}
+	return key, true
+}
+
+// tlsSessionCache returns the TLS session cache shared by the HTTP clients of
+// all targets of the scrape config. It returns nil if sessions are not to be
+// resumed.
+func tlsSessionCache(cfg *config.ScrapeConfig) tls.ClientSessionCache {
+	key, ok := newTLSSessionCacheKey(cfg)
+	if !ok {
+		return nil
+	}
+
+	tlsSessionCachesMtx.Lock()
+	defer tlsSessionCachesMtx.Unlock()
+
+	cache, ok := tlsSessionCaches[key]
+	if !ok {
+		cache = tls.NewLRUClientSessionCache(key.size)
+		tlsSessionCaches[key] = cache
+	}
+	return cache
+}
+
+// retainTLSSessionCaches removes the TLS session caches of all scrape configs
+// but the given ones. This drops the sessions and the inline key material of
+// configs that were changed or removed by a reload.
+func retainTLSSessionCaches(cfgs []*config.ScrapeConfig) {
+	keep := make(map[tlsSessionCacheKey]bool, len(cfgs))
+	for _, cfg := range cfgs {
+		if key, ok := newTLSSessionCacheKey(cfg); ok {
+			keep[key] = true
+		}
+	}
+
+	tlsSessionCachesMtx.Lock()
+	defer tlsSessionCachesMtx.Unlock()
+
+	for key := range tlsSessionCaches {
+		if !keep[key] {
+			delete(tlsSessionCaches, key)
+		}
+	}
+}
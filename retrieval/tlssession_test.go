@@ -0,0 +1,67 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/config"
+)
+
+func TestTLSSessionCacheClientCertP12(t *testing.T) {
+	newConfig := func(file string) *config.ScrapeConfig {
+		return &config.ScrapeConfig{
+			JobName: "test_job",
+			ClientCertP12: &config.ClientCertP12{
+				File:         file,
+				PasswordFile: "testdata/client.p12.password",
+			},
+		}
+	}
+	a, b := newConfig("testdata/a.p12"), newConfig("testdata/b.p12")
+	if tlsSessionCache(a) == tlsSessionCache(b) {
+		t.Errorf("Expected configs with different client certificate bundles to get different session caches")
+	}
+	if tlsSessionCache(a) != tlsSessionCache(newConfig("testdata/a.p12")) {
+		t.Errorf("Expected configs with the same client certificate bundle to share the session cache")
+	}
+}
+
+func TestRetainTLSSessionCaches(t *testing.T) {
+	kept := &config.ScrapeConfig{JobName: "kept_job"}
+	removed := &config.ScrapeConfig{
+		JobName: "removed_job",
+		ClientCert: &config.ClientCert{
+			CertPEM: "cert",
+			KeyPEM:  "key",
+		},
+	}
+	keptCache := tlsSessionCache(kept)
+	tlsSessionCache(removed)
+
+	retainTLSSessionCaches([]*config.ScrapeConfig{kept})
+
+	keptKey, _ := newTLSSessionCacheKey(kept)
+	removedKey, _ := newTLSSessionCacheKey(removed)
+	tlsSessionCachesMtx.Lock()
+	_, hasKept := tlsSessionCaches[keptKey]
+	_, hasRemoved := tlsSessionCaches[removedKey]
+	tlsSessionCachesMtx.Unlock()
+	if !hasKept || hasRemoved {
+		t.Errorf("Expected only the session cache of the kept job to remain, got kept %t and removed %t", hasKept, hasRemoved)
+	}
+	if tlsSessionCache(kept) != keptCache {
+		t.Errorf("Expected the kept job to keep its session cache")
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrieval
+
+import (
+	"math"
+	"regexp"
+
+	clientmodel "github.com/prometheus/client_golang/model"
+
+	"github.com/prometheus/prometheus/config"
+)
+
+// valueTransform scales and offsets the values of samples whose metric name
+// matches its regular expression.
+type valueTransform struct {
+	name   *regexp.Regexp
+	scale  float64
+	offset float64
+}
+
+// newValueTransforms returns the value transforms of the given configurations.
+func newValueTransforms(cfgs []*config.ValueTransformConfig) []valueTransform {
+	var vts []valueTransform
+	for _, cfg := range cfgs {
+		vts = append(vts, valueTransform{
+			// The entire metric name has to match.
+			name:   anchoredRegexp(&cfg.MetricName.Regexp),
+			scale:  cfg.Scale,
+			offset: cfg.Offset,
+		})
+	}
+	return vts
+}
+
+// transformValue applies all matching value transforms in order to the value
+// of the sample.
+func transformValue(s *clientmodel.Sample, vts []valueTransform) {
+	if len(vts) == 0 {
+		return
+	}
+	name := string(s.Metric[clientmodel.MetricNameLabel])
+	for _, vt := range vts {
+		if vt.name.MatchString(name) {
+			s.Value = clientmodel.SampleValue(float64(s.Value)*vt.scale + vt.offset)
+		}
+	}
+}
+
+// handleNonFinite applies the action to a sample with a NaN or infinite value.
+// It returns whether the sample is kept and whether the action was applied.
+func handleNonFinite(s *clientmodel.Sample, action config.NonFiniteValueAction) (keep, handled bool) {
+	v := float64(s.Value)
+	if !math.IsNaN(v) && !math.IsInf(v, 0) {
+		return true, false
+	}
+	switch action {
+	case config.NonFiniteDrop:
+		return false, true
+	case config.NonFiniteClamp:
+		switch {
+		case math.IsInf(v, 1):
+			s.Value = math.MaxFloat64
+		case math.IsInf(v, -1):
+			s.Value = -math.MaxFloat64
+		default:
+			s.Value = 0
+		}
+		return true, true
+	}
+	return true, false
+}
+
+// valueFilter drops samples whose metric name matches its regular expression
+// and whose finite value is out of its bounds.
+type valueFilter struct {
+	name     *regexp.Regexp
+	min, max float64
+}
+
+// newValueFilters returns the value filters of the given configurations.
+func newValueFilters(cfgs []*config.ValueFilterConfig) []valueFilter {
+	var vfs []valueFilter
+	for _, cfg := range cfgs {
+		vf := valueFilter{
+			// The entire metric name has to match.
+			name: anchoredRegexp(&cfg.MetricName.Regexp),
+			min:  math.Inf(-1),
+			max:  math.Inf(1),
+		}
+		if cfg.Min != nil {
+			vf.min = *cfg.Min
+		}
+		if cfg.Max != nil {
+			vf.max = *cfg.Max
+		}
+		vfs = append(vfs, vf)
+	}
+	return vfs
+}
+
+// inRange returns false if the value of the sample is out of the bounds of
+// any matching value filter. NaN and infinite values are left to the handling
+// of non-finite values and always pass.
+func inRange(s *clientmodel.Sample, vfs []valueFilter) bool {
+	v := float64(s.Value)
+	if len(vfs) == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return true
+	}
+	name := string(s.Metric[clientmodel.MetricNameLabel])
+	for _, vf := range vfs {
+		if vf.name.MatchString(name) && (v < vf.min || v > vf.max) {
+			return false
+		}
+	}
+	return true
+}
+
+// valueFiltersEqual returns true iff both lists contain equivalent value
+// filters in the same order.
+func valueFiltersEqual(a, b []valueFilter) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].name.String() != b[i].name.String() || a[i].min != b[i].min || a[i].max != b[i].max {
+			return false
+		}
+	}
+	return true
+}
+
+// valueTransformsEqual returns true iff both lists contain equivalent value
+// transforms in the same order.
+func valueTransformsEqual(a, b []valueTransform) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].name.String() != b[i].name.String() || a[i].scale != b[i].scale || a[i].offset != b[i].offset {
+			return false
+		}
+	}
+	return true
+}
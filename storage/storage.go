@@ -35,3 +35,54 @@ func (f Fanout) Append(s *clientmodel.Sample) {
 		a.Append(s)
 	}
 }
+
+// SampleMetadata holds information about samples that is not part of their
+// metric, e.g. to route them to different backends.
+type SampleMetadata struct {
+	// The tenant the samples belong to.
+	TenantID string
+}
+
+// MetadataAppender is implemented by SampleAppenders that accept metadata
+// along with samples.
+type MetadataAppender interface {
+	SampleAppender
+	// AppendWithMetadata appends the sample along with its metadata.
+	AppendWithMetadata(*clientmodel.Sample, *SampleMetadata)
+}
+
+// AppendWithMetadata implements MetadataAppender. SampleAppenders in the
+// Fanout slice that do not implement MetadataAppender receive the sample
+// without the metadata.
+func (f Fanout) AppendWithMetadata(s *clientmodel.Sample, md *SampleMetadata) {
+	for _, a := range f {
+		if ma, ok := a.(MetadataAppender); ok {
+			ma.AppendWithMetadata(s, md)
+		} else {
+			a.Append(s)
+		}
+	}
+}
+
+// WithMetadata returns a SampleAppender that passes the provided metadata
+// along with every appended sample to app. If app does not implement
+// MetadataAppender, it is returned unchanged.
+func WithMetadata(app SampleAppender, md *SampleMetadata) SampleAppender {
+	ma, ok := app.(MetadataAppender)
+	if !ok {
+		return app
+	}
+	return metadataAppender{app: ma, md: md}
+}
+
+// metadataAppender is a SampleAppender that appends samples with fixed
+// metadata.
+type metadataAppender struct {
+	app MetadataAppender
+	md  *SampleMetadata
+}
+
+// Append implements SampleAppender.
+func (a metadataAppender) Append(s *clientmodel.Sample) {
+	a.app.AppendWithMetadata(s, a.md)
+}
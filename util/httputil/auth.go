@@ -0,0 +1,107 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputil
+
+import (
+	"net/http"
+)
+
+// AuthProvider authenticates HTTP requests, e.g. by setting their
+// authorization header.
+type AuthProvider interface {
+	// ApplyAuth adds the authentication to the request. The request must not
+	// be sent if an error is returned.
+	ApplyAuth(*http.Request) error
+}
+
+// A ChallengeAuthProvider is an AuthProvider that can answer the
+// authentication challenge of a response with status 401 Unauthorized.
+type ChallengeAuthProvider interface {
+	AuthProvider
+	// ApplyChallenge authenticates the request anew in answer to the
+	// challenge of the response. It returns false if it cannot answer the
+	// challenge, in which case the request is not retried.
+	ApplyChallenge(*http.Request, *http.Response) (bool, error)
+}
+
+type basicAuthProvider struct {
+	username, password string
+}
+
+// NewBasicAuthProvider returns an AuthProvider which authenticates requests
+// with the given HTTP basic authentication credentials.
+func NewBasicAuthProvider(username, password string) AuthProvider {
+	return &basicAuthProvider{username, password}
+}
+
+func (p *basicAuthProvider) ApplyAuth(req *http.Request) error {
+	req.SetBasicAuth(p.username, p.password)
+	return nil
+}
+
+type bearerAuthProvider struct {
+	bearerToken string
+}
+
+// NewBearerAuthProvider returns an AuthProvider which authenticates requests
+// with the given bearer token.
+func NewBearerAuthProvider(bearer string) AuthProvider {
+	return &bearerAuthProvider{bearer}
+}
+
+func (p *bearerAuthProvider) ApplyAuth(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+p.bearerToken)
+	return nil
+}
+
+type tokenSourceAuthProvider struct {
+	ts TokenSource
+}
+
+// NewTokenSourceAuthProvider returns an AuthProvider which authenticates
+// requests with a bearer token obtained from the provided token source.
+func NewTokenSourceAuthProvider(ts TokenSource) AuthProvider {
+	return &tokenSourceAuthProvider{ts}
+}
+
+func (p *tokenSourceAuthProvider) ApplyAuth(req *http.Request) error {
+	token, err := p.ts.Token()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+type authRoundTripper struct {
+	provider AuthProvider
+	rt       http.RoundTripper
+}
+
+// NewAuthRoundTripper authenticates a request with the provided AuthProvider
+// unless the authorization header has already been set.
+func NewAuthRoundTripper(provider AuthProvider, rt http.RoundTripper) http.RoundTripper {
+	return &authRoundTripper{provider, rt}
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(req.Header.Get("Authorization")) == 0 {
+		req = cloneRequest(req)
+		if err := rt.provider.ApplyAuth(req); err != nil {
+			return nil, err
+		}
+	}
+
+	return rt.rt.RoundTrip(req)
+}
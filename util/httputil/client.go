@@ -14,6 +14,7 @@
 package httputil
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"net/url"
@@ -34,18 +35,53 @@ func NewDeadlineClient(timeout time.Duration, proxyURL *url.URL) *http.Client {
 // NewDeadlineRoundTripper returns a new http.RoundTripper which will time out
 // long running requests.
 func NewDeadlineRoundTripper(timeout time.Duration, proxyURL *url.URL) http.RoundTripper {
+	return NewHeaderDeadlineRoundTripper(timeout, timeout, proxyURL)
+}
+
+// NewHeaderDeadlineRoundTripper returns a new http.RoundTripper which will time
+// out requests that are not connected and have not received the response
+// headers within headerTimeout, and requests whose response body is not fully
+// read within timeout.
+func NewHeaderDeadlineRoundTripper(timeout, headerTimeout time.Duration, proxyURL *url.URL) http.RoundTripper {
+	return NewResolverDeadlineRoundTripper(timeout, headerTimeout, proxyURL, nil)
+}
+
+// NewResolverDeadlineRoundTripper returns a new http.RoundTripper like
+// NewHeaderDeadlineRoundTripper which resolves host names with the given
+// resolver. The default resolver is used if it is nil.
+func NewResolverDeadlineRoundTripper(timeout, headerTimeout time.Duration, proxyURL *url.URL, resolver *net.Resolver) http.RoundTripper {
+	return newDeadlineTransport(timeout, headerTimeout, proxyURL, resolver, false)
+}
+
+// NewConnectedDeadlineRoundTripper returns a new http.RoundTripper like
+// NewResolverDeadlineRoundTripper whose timeout starts once the connection is
+// established rather than before the host name is resolved. Resolving and
+// connecting are limited by headerTimeout on their own.
+func NewConnectedDeadlineRoundTripper(timeout, headerTimeout time.Duration, proxyURL *url.URL, resolver *net.Resolver) http.RoundTripper {
+	return newDeadlineTransport(timeout, headerTimeout, proxyURL, resolver, true)
+}
+
+func newDeadlineTransport(timeout, headerTimeout time.Duration, proxyURL *url.URL, resolver *net.Resolver, afterConnect bool) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:  headerTimeout,
+		Resolver: resolver,
+	}
 	return &http.Transport{
 		// Set proxy (if null, then becomes a direct connection)
 		Proxy: http.ProxyURL(proxyURL),
 		// We need to disable keepalive, because we set a deadline on the
 		// underlying connection.
-		DisableKeepAlives: true,
-		Dial: func(netw, addr string) (c net.Conn, err error) {
+		DisableKeepAlives:     true,
+		ResponseHeaderTimeout: headerTimeout,
+		DialContext: func(ctx context.Context, netw, addr string) (c net.Conn, err error) {
 			start := time.Now()
 
-			c, err = net.DialTimeout(netw, addr, timeout)
+			c, err = dialer.DialContext(ctx, netw, addr)
 
 			if err == nil {
+				if afterConnect {
+					start = time.Now()
+				}
 				c.SetDeadline(start.Add(timeout))
 			}
 
@@ -54,24 +90,16 @@ func NewDeadlineRoundTripper(timeout time.Duration, proxyURL *url.URL) http.Roun
 	}
 }
 
-type bearerAuthRoundTripper struct {
-	bearerToken string
-	rt          http.RoundTripper
-}
-
 // NewBearerAuthRoundTripper adds the provided bearer token to a request unless the authorization
 // header has already been set.
 func NewBearerAuthRoundTripper(bearer string, rt http.RoundTripper) http.RoundTripper {
-	return &bearerAuthRoundTripper{bearer, rt}
+	return NewAuthRoundTripper(NewBearerAuthProvider(bearer), rt)
 }
 
-func (rt *bearerAuthRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	if len(req.Header.Get("Authorization")) == 0 {
-		req = cloneRequest(req)
-		req.Header.Set("Authorization", "Bearer "+rt.bearerToken)
-	}
-
-	return rt.rt.RoundTrip(req)
+// NewTokenSourceRoundTripper adds a bearer token obtained from the provided token source
+// to a request unless the authorization header has already been set.
+func NewTokenSourceRoundTripper(ts TokenSource, rt http.RoundTripper) http.RoundTripper {
+	return NewAuthRoundTripper(NewTokenSourceAuthProvider(ts), rt)
 }
 
 // cloneRequest returns a clone of the provided *http.Request.
@@ -0,0 +1,102 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputil
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	krb5config "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+// KerberosTokenSource is a NegotiateTokenSource providing SPNEGO tokens with
+// Kerberos service tickets. It logs in with the key of a user principal read
+// from a keytab when the first token is requested and whenever its ticket
+// granting ticket has expired. It is safe for concurrent use.
+type KerberosTokenSource struct {
+	keytabFile, krb5ConfigFile string
+	username, realm            string
+
+	mtx    sync.Mutex
+	client *client.Client
+}
+
+// NewKerberosTokenSource returns a KerberosTokenSource for the user principal
+// of the given name and realm. The KDCs of the realm are looked up in the
+// krb5.conf file.
+func NewKerberosTokenSource(keytabFile, krb5ConfigFile, username, realm string) *KerberosTokenSource {
+	return &KerberosTokenSource{
+		keytabFile:     keytabFile,
+		krb5ConfigFile: krb5ConfigFile,
+		username:       username,
+		realm:          realm,
+	}
+}
+
+// NegotiateToken implements NegotiateTokenSource. A Kerberos security context
+// is established by the initial token alone, so a challenge carrying a
+// server token means that the service rejected it.
+func (s *KerberosTokenSource) NegotiateToken(spn string, input []byte) ([]byte, error) {
+	if input != nil {
+		return nil, errors.New("the Kerberos service ticket was not accepted")
+	}
+	cl, err := s.getClient()
+	if err != nil {
+		return nil, err
+	}
+	sc := spnego.SPNEGOClient(cl, spn)
+	if err := sc.AcquireCred(); err != nil {
+		return nil, fmt.Errorf("error logging in as %s@%s: %s", s.username, s.realm, err)
+	}
+	token, err := sc.InitSecContext()
+	if err != nil {
+		return nil, fmt.Errorf("error obtaining service ticket: %s", err)
+	}
+	return token.Marshal()
+}
+
+// getClient returns the Kerberos client, creating it on first use. The keytab
+// and krb5.conf files are read again if that failed before.
+func (s *KerberosTokenSource) getClient() (*client.Client, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+	kt, err := keytab.Load(s.keytabFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading keytab file %s: %s", s.keytabFile, err)
+	}
+	conf, err := krb5config.Load(s.krb5ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading krb5.conf file %s: %s", s.krb5ConfigFile, err)
+	}
+	s.client = client.NewWithKeytab(s.username, s.realm, kt, conf)
+	return s.client, nil
+}
+
+// Close destroys the Kerberos sessions of the source, which stops renewing
+// them. Tokens requested afterwards log in anew.
+func (s *KerberosTokenSource) Close() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.client != nil {
+		s.client.Destroy()
+		s.client = nil
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputil
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A NegotiateTokenSource provides the SPNEGO tokens of the HTTP Negotiate
+// authentication scheme (RFC 4559). KerberosTokenSource obtains them from
+// Kerberos service tickets.
+type NegotiateTokenSource interface {
+	// NegotiateToken returns a token establishing a security context with
+	// the service of the given principal name. The input token is the one
+	// sent by the server in a challenge, and nil for the initial token.
+	NegotiateToken(spn string, input []byte) ([]byte, error)
+}
+
+type negotiateAuthProvider struct {
+	ts  NegotiateTokenSource
+	spn string
+}
+
+// NewNegotiateAuthProvider returns a ChallengeAuthProvider which authenticates
+// requests with the Negotiate scheme for the service of the given principal
+// name, using the tokens of the given source. Requests carry an initial token
+// right away. Challenges with a token of the server are answered with the
+// token the source returns for it.
+func NewNegotiateAuthProvider(ts NegotiateTokenSource, spn string) ChallengeAuthProvider {
+	return &negotiateAuthProvider{ts, spn}
+}
+
+func (p *negotiateAuthProvider) ApplyAuth(req *http.Request) error {
+	return p.setToken(req, nil)
+}
+
+func (p *negotiateAuthProvider) ApplyChallenge(req *http.Request, resp *http.Response) (bool, error) {
+	input, ok := negotiateChallenge(resp.Header)
+	if !ok || len(input) == 0 {
+		return false, nil
+	}
+	if err := p.setToken(req, input); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (p *negotiateAuthProvider) setToken(req *http.Request, input []byte) error {
+	token, err := p.ts.NegotiateToken(p.spn, input)
+	if err != nil {
+		return fmt.Errorf("error obtaining negotiate token for %s: %s", p.spn, err)
+	}
+	req.Header.Set("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(token))
+	return nil
+}
+
+// negotiateChallenge returns the token of the Negotiate challenge of the
+// response headers, if there is one. The token is nil if the challenge
+// carries none.
+func negotiateChallenge(h http.Header) ([]byte, bool) {
+	for _, v := range h["Www-Authenticate"] {
+		fields := strings.Fields(v)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], "Negotiate") {
+			continue
+		}
+		if len(fields) == 1 {
+			return nil, true
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			continue
+		}
+		return token, true
+	}
+	return nil, false
+}
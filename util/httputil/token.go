@@ -0,0 +1,126 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A TokenSource provides bearer tokens for authenticating requests.
+type TokenSource interface {
+	// Token returns a currently valid bearer token.
+	Token() (string, error)
+}
+
+// urlTokenSource fetches bearer tokens from a URL and caches them for the
+// configured TTL.
+type urlTokenSource struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+	// The error of the last fetch.
+	err error
+	// Closed once the running fetch finished. Nil if no fetch is running.
+	fetching chan struct{}
+}
+
+// NewURLTokenSource returns a TokenSource fetching tokens from the given URL.
+// The body of the response is used as the token. Tokens are reused until the
+// TTL expires. Once 90% of the TTL has passed the token is refreshed in the
+// background while the cached token is still handed out.
+func NewURLTokenSource(url string, ttl time.Duration, client *http.Client) TokenSource {
+	return &urlTokenSource{
+		url:    url,
+		ttl:    ttl,
+		client: client,
+	}
+}
+
+// Token implements the TokenSource interface. Callers needing a new token
+// wait for a single fetch shared between them.
+func (ts *urlTokenSource) Token() (string, error) {
+	ts.mu.Lock()
+	now := time.Now()
+	if now.Before(ts.expiry) {
+		// Refresh in the background. The old token is kept on errors as it
+		// is still valid for a while.
+		if now.After(ts.expiry.Add(-ts.ttl / 10)) {
+			ts.fetch()
+		}
+		token := ts.token
+		ts.mu.Unlock()
+		return token, nil
+	}
+	done := ts.fetch()
+	ts.mu.Unlock()
+
+	<-done
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.err != nil {
+		return "", ts.err
+	}
+	return ts.token, nil
+}
+
+// fetch starts fetching a new token unless a fetch is already running. It
+// returns a channel closed once the fetch finished. The caller must hold the
+// lock.
+func (ts *urlTokenSource) fetch() <-chan struct{} {
+	if ts.fetching != nil {
+		return ts.fetching
+	}
+	done := make(chan struct{})
+	ts.fetching = done
+	go func() {
+		token, err := ts.request()
+		ts.mu.Lock()
+		ts.err = err
+		if err == nil {
+			ts.token = token
+			ts.expiry = time.Now().Add(ts.ttl)
+		}
+		ts.fetching = nil
+		ts.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+// request requests a new token from the URL.
+func (ts *urlTokenSource) request() (string, error) {
+	resp, err := ts.client.Get(ts.url)
+	if err != nil {
+		return "", fmt.Errorf("unable to fetch bearer token from %s: %s", ts.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to fetch bearer token from %s: server returned HTTP status %s", ts.url, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("unable to read bearer token from %s: %s", ts.url, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
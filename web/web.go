@@ -401,6 +401,8 @@ func tmplFuncs(consolesPath string, opts *Options) template_text.FuncMap {
 				return "warning"
 			case retrieval.HealthGood:
 				return "success"
+			case retrieval.HealthPartial:
+				return "warning"
 			default:
 				return "danger"
 			}